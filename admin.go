@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+type contextKey int
+
+const staffUserIDKey contextKey = iota
+
+// middlewareRequireRole only lets through requests whose access token carries
+// at least the given role. Roles are read from the token, so a role change
+// takes effect once the user gets a new access token.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header.Get("Authorization"))
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+
+		claims, err := cfg.parseAccessToken(r.Context(), token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+
+		if !auth.HasRole(claims.Role, role) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), staffUserIDKey, userID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// staffUserID returns the moderator or admin making a request that went
+// through middlewareRequireRole.
+func staffUserID(r *http.Request) uuid.NullUUID {
+	userID, ok := r.Context().Value(staffUserIDKey).(uuid.UUID)
+	return uuid.NullUUID{UUID: userID, Valid: ok}
+}
+
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+		Note string `json:"note"`
+	}
+
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be user, moderator or admin", nil)
+		return
+	}
+
+	// Admins can't lock themselves out; another admin has to demote them
+	adminID := staffUserID(r)
+	if adminID.UUID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	user, err := qtx.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: params.Role,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role", err)
+		return
+	}
+
+	_, err = qtx.CreateModerationAction(r.Context(), database.CreateModerationActionParams{
+		Action:      "set_role",
+		ModeratorID: adminID,
+		UserID:      uuid.NullUUID{UUID: userID, Valid: true},
+		Note:        params.Note,
+		Role:        sql.NullString{String: params.Role, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record moderation action", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handlerUnlockUser clears an account's failed logins and lockout, for users
+// locked out by someone else guessing their password.
+func (cfg *apiConfig) handlerUnlockUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Note string `json:"note"`
+	}
+
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	// The note is optional, so an empty body is fine
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlock user", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	unlocked, err := qtx.ResetFailedLogins(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlock user", err)
+		return
+	}
+	if unlocked == 0 {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	_, err = qtx.CreateModerationAction(r.Context(), database.CreateModerationActionParams{
+		Action:      "unlock",
+		ModeratorID: staffUserID(r),
+		UserID:      uuid.NullUUID{UUID: userID, Valid: true},
+		Note:        params.Note,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record moderation action", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlock user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type LoginEventResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Email     string    `json:"email"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginEventPageResponse struct {
+	Events     []LoginEventResponse `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// handlerGetLoginEvents lists a user's login attempts, newest first.
+func (cfg *apiConfig) handlerGetLoginEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	events, err := cfg.DB.GetLoginEventsPage(r.Context(), database.GetLoginEventsPageParams{
+		UserID:          uuid.NullUUID{UUID: userID, Valid: true},
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get login events", err)
+		return
+	}
+
+	page := LoginEventPageResponse{Events: []LoginEventResponse{}}
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[len(events)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	for _, event := range events {
+		page.Events = append(page.Events, LoginEventResponse{
+			ID:        event.ID,
+			Email:     event.Email,
+			IPAddress: event.IpAddress,
+			UserAgent: event.UserAgent,
+			Outcome:   event.Outcome,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
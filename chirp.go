@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"log"
 	"net/http"
 	"encoding/json"
 	"strings"
     "time"
     "errors"
-	"sort"
 
 	"github.com/google/uuid"
     "github.com/vanzei/goserver/internal/auth"
     "github.com/vanzei/goserver/internal/database"
+    "github.com/vanzei/goserver/internal/moderation"
 )
 
 type ChirpResponse struct {
@@ -19,26 +22,276 @@ type ChirpResponse struct {
     UpdatedAt time.Time  `json:"updated_at"`
     Body      string     `json:"body"`
     UserID    uuid.UUID  `json:"user_id"`
+    InReplyTo  uuid.NullUUID `json:"in_reply_to"`
+    ReplyCount int64         `json:"reply_count"`
+    LikeCount    int64 `json:"like_count"`
+    RechirpCount int64 `json:"rechirp_count"`
+    // Only set when the request carries a valid access token
+    LikedByMe *bool `json:"liked_by_me,omitempty"`
+    // Only set when the chirp appears in a feed because someone rechirped it
+    RechirpedBy *RechirpResponse `json:"rechirped_by,omitempty"`
+    Entities    ChirpEntities    `json:"entities"`
 }
 
-// Helper function to extract and validate JWT token
-func (cfg *apiConfig) validateJWTFromRequest(r *http.Request) (uuid.UUID, error) {
+type RechirpResponse struct {
+    UserID      uuid.UUID `json:"user_id"`
+    RechirpedAt time.Time `json:"rechirped_at"`
+}
+
+// Helper function to convert a database chirp into its API representation
+func newChirpResponse(chirp database.Chirp) ChirpResponse {
+    return ChirpResponse{
+        ID:        chirp.ID,
+        CreatedAt: chirp.CreatedAt,
+        UpdatedAt: chirp.UpdatedAt,
+        Body:      chirp.Body,
+        UserID:    chirp.UserID.UUID,
+        InReplyTo: chirp.InReplyTo,
+        Entities:  newChirpEntities(chirp.Body),
+    }
+}
+
+// Helper function to convert database chirps into API responses, loading
+// the counters stored in other tables with one query per counter. When
+// viewerID is set, the viewer's own likes are reported as well.
+func (cfg *apiConfig) chirpResponses(ctx context.Context, chirps []database.Chirp, viewerID uuid.NullUUID) ([]ChirpResponse, error) {
+    chirpResponses := []ChirpResponse{}
+    if len(chirps) == 0 {
+        return chirpResponses, nil
+    }
+
+    chirpIDs := make([]uuid.UUID, 0, len(chirps))
+    for _, chirp := range chirps {
+        chirpIDs = append(chirpIDs, chirp.ID)
+    }
+
+    replyCounts, err := cfg.DB.GetReplyCounts(ctx, chirpIDs)
+    if err != nil {
+        return nil, err
+    }
+    replyCountByChirp := make(map[uuid.UUID]int64, len(replyCounts))
+    for _, row := range replyCounts {
+        replyCountByChirp[row.InReplyTo.UUID] = row.ReplyCount
+    }
+
+    likeCounts, err := cfg.DB.GetLikeCounts(ctx, chirpIDs)
+    if err != nil {
+        return nil, err
+    }
+    likeCountByChirp := make(map[uuid.UUID]int64, len(likeCounts))
+    for _, row := range likeCounts {
+        likeCountByChirp[row.ChirpID] = row.LikeCount
+    }
+
+    rechirpCounts, err := cfg.DB.GetRechirpCounts(ctx, chirpIDs)
+    if err != nil {
+        return nil, err
+    }
+    rechirpCountByChirp := make(map[uuid.UUID]int64, len(rechirpCounts))
+    for _, row := range rechirpCounts {
+        rechirpCountByChirp[row.ChirpID] = row.RechirpCount
+    }
+
+    mentions, err := cfg.DB.GetMentionsForChirps(ctx, chirpIDs)
+    if err != nil {
+        return nil, err
+    }
+    mentionsByChirp := make(map[uuid.UUID][]MentionEntity, len(mentions))
+    for _, row := range mentions {
+        mentionsByChirp[row.ChirpID] = append(mentionsByChirp[row.ChirpID], MentionEntity{
+            UserID:   row.UserID,
+            Username: row.Username.String,
+            Start:    int(row.StartOffset),
+            End:      int(row.EndOffset),
+        })
+    }
+
+    var likedByViewer map[uuid.UUID]bool
+    if viewerID.Valid {
+        likedIDs, err := cfg.DB.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+            UserID:   viewerID.UUID,
+            ChirpIds: chirpIDs,
+        })
+        if err != nil {
+            return nil, err
+        }
+        likedByViewer = make(map[uuid.UUID]bool, len(likedIDs))
+        for _, chirpID := range likedIDs {
+            likedByViewer[chirpID] = true
+        }
+    }
+
+    for _, chirp := range chirps {
+        chirpResponse := newChirpResponse(chirp)
+        chirpResponse.ReplyCount = replyCountByChirp[chirp.ID]
+        chirpResponse.LikeCount = likeCountByChirp[chirp.ID]
+        chirpResponse.RechirpCount = rechirpCountByChirp[chirp.ID]
+        if chirpMentions, ok := mentionsByChirp[chirp.ID]; ok {
+            chirpResponse.Entities.Mentions = chirpMentions
+        }
+        if viewerID.Valid {
+            liked := likedByViewer[chirp.ID]
+            chirpResponse.LikedByMe = &liked
+        }
+        chirpResponses = append(chirpResponses, chirpResponse)
+    }
+    return chirpResponses, nil
+}
+
+// Helper function to respond with a single chirp and its counters
+func (cfg *apiConfig) respondWithChirp(w http.ResponseWriter, r *http.Request, code int, chirp database.Chirp) {
+    chirpResponses, err := cfg.chirpResponses(r.Context(), []database.Chirp{chirp}, cfg.optionalUserIDFromRequest(r))
+    if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+        return
+    }
+    respondWithJSON(w, code, chirpResponses[0])
+}
+
+type ChirpPageResponse struct {
+    Chirps     []ChirpResponse `json:"chirps"`
+    NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Helper function to extract and validate the caller's token. Access tokens
+// from a login can do everything; personal access tokens must have been
+// granted scope.
+func (cfg *apiConfig) validateJWTFromRequest(r *http.Request, scope string) (uuid.UUID, error) {
+    userID, _, err := cfg.authenticateRequest(r, scope)
+    return userID, err
+}
+
+// Helper function like validateJWTFromRequest for handlers that also need
+// the claims of an access token from a login. The claims are nil for
+// personal access tokens.
+func (cfg *apiConfig) authenticateRequest(r *http.Request, scope string) (uuid.UUID, *auth.Claims, error) {
+    token, err := bearerTokenFromRequest(r)
+    if err != nil {
+        return uuid.UUID{}, nil, err
+    }
+
+    if auth.IsPersonalAccessToken(token) {
+        userID, err := cfg.validatePersonalAccessToken(r.Context(), token, scope)
+        return userID, nil, err
+    }
+
+    claims, err := cfg.parseAccessToken(r.Context(), token)
+    if err != nil {
+        return uuid.UUID{}, nil, err
+    }
+
+    userID, err := uuid.Parse(claims.Subject)
+    if err != nil {
+        return uuid.UUID{}, nil, err
+    }
+    return userID, claims, nil
+}
+
+// Helper function to validate an access token from a login. Besides the
+// signature and expiry, the session the token was issued for must still be
+// live, so revoking a session locks out its access tokens straight away.
+func (cfg *apiConfig) parseAccessToken(ctx context.Context, token string) (*auth.Claims, error) {
+    claims, err := auth.ParseJWT(token, cfg.keyring)
+    if err != nil {
+        return nil, err
+    }
+
+    userID, err := uuid.Parse(claims.Subject)
+    if err != nil {
+        return nil, err
+    }
+    sessionID, err := uuid.Parse(claims.SessionID)
+    if err != nil {
+        return nil, ErrRevokedSession
+    }
+
+    active, err := cfg.DB.IsSessionActive(ctx, database.IsSessionActiveParams{
+        FamilyID: sessionID,
+        UserID:   userID,
+    })
+    if err != nil {
+        return nil, err
+    }
+    if !active {
+        return nil, ErrRevokedSession
+    }
+    return claims, nil
+}
+
+// Helper function to look up a personal access token and check its scopes
+func (cfg *apiConfig) validatePersonalAccessToken(ctx context.Context, token, scope string) (uuid.UUID, error) {
+    pat, err := cfg.DB.GetPersonalAccessTokenByHash(ctx, auth.HashPersonalAccessToken(token))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return uuid.UUID{}, ErrUnknownAccessToken
+        }
+        return uuid.UUID{}, err
+    }
+
+    if !auth.HasScope(pat.Scopes, scope) {
+        return uuid.UUID{}, ErrMissingScope
+    }
+
+    // Failing to record the last use shouldn't fail the request
+    if err := cfg.DB.TouchPersonalAccessToken(ctx, pat.ID); err != nil {
+        log.Printf("Couldn't record use of personal access token %s: %v", pat.ID, err)
+    }
+
+    return pat.UserID, nil
+}
+
+// Helper function for handlers that need more of the access token than the
+// user ID, such as the session it belongs to. Only access tokens from a login
+// are accepted, which keeps session and token management out of reach of
+// personal access tokens.
+func (cfg *apiConfig) claimsFromRequest(r *http.Request) (*auth.Claims, error) {
+    token, err := bearerTokenFromRequest(r)
+    if err != nil {
+        return nil, err
+    }
+    
+    return cfg.parseAccessToken(r.Context(), token)
+}
+
+// Helper function for endpoints that only accept access tokens from a login
+func (cfg *apiConfig) sessionUserIDFromRequest(r *http.Request) (uuid.UUID, error) {
+    claims, err := cfg.claimsFromRequest(r)
+    if err != nil {
+        return uuid.UUID{}, err
+    }
+
+    return uuid.Parse(claims.Subject)
+}
+
+// Helper function to extract the bearer token from the Authorization header
+func bearerTokenFromRequest(r *http.Request) (string, error) {
     authHeader := r.Header.Get("Authorization")
     if authHeader == "" {
-        return uuid.UUID{}, ErrMissingAuthHeader
+        return "", ErrMissingAuthHeader
     }
     
     parts := strings.SplitN(authHeader, " ", 2)
     if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-        return uuid.UUID{}, ErrInvalidAuthHeaderFormat
+        return "", ErrInvalidAuthHeaderFormat
     }
     
-    userID, err := auth.ValidateJWT(parts[1], cfg.secret)
+    return parts[1], nil
+}
+
+// Helper function to identify the caller on endpoints that work without
+// authentication. Missing or invalid tokens yield an unset ID.
+func (cfg *apiConfig) optionalUserIDFromRequest(r *http.Request) uuid.NullUUID {
+    userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsRead)
     if err != nil {
-        return uuid.UUID{}, err
+        return uuid.NullUUID{}
     }
-    
-    return userID, nil
+    return uuid.NullUUID{UUID: userID, Valid: true}
+}
+
+// chirpRemoved reports whether a chirp was deleted by its author or hidden by
+// a moderator. Removed chirps only show up as placeholders in threads.
+func chirpRemoved(chirp database.Chirp) bool {
+    return chirp.DeletedAt.Valid || chirp.HiddenAt.Valid
 }
 
 // Helper function to extract chirp ID from request path
@@ -60,13 +313,127 @@ func getChirpIDFromPath(r *http.Request) (uuid.UUID, error) {
 var (
     ErrMissingAuthHeader      = errors.New("missing authorization header")
     ErrInvalidAuthHeaderFormat = errors.New("invalid authorization header format")
+    ErrUnknownAccessToken     = errors.New("personal access token is unknown or expired")
+    ErrMissingScope           = errors.New("token is missing the required scope")
+    ErrRevokedSession         = errors.New("session has been logged out")
     ErrMissingChirpID         = errors.New("chirp ID is required")
 )
 
+// Helper function to report a failed validateJWTFromRequest
+func respondWithAuthError(w http.ResponseWriter, err error) {
+    switch err {
+    case ErrMissingAuthHeader:
+        respondWithError(w, http.StatusUnauthorized, "Missing authorization header", nil)
+    case ErrInvalidAuthHeaderFormat:
+        respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format", nil)
+    case ErrUnknownAccessToken:
+        respondWithError(w, http.StatusUnauthorized, "Invalid token", nil)
+    case ErrRevokedSession:
+        respondWithError(w, http.StatusUnauthorized, "Session has been logged out", nil)
+    case ErrMissingScope:
+        respondWithError(w, http.StatusForbidden, "Token is missing the required scope", nil)
+    default:
+        respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+    }
+}
+
+// Helper function to report a failed getChirpIDFromPath
+func respondWithChirpIDError(w http.ResponseWriter, err error) {
+    switch err {
+    case ErrMissingChirpID:
+        respondWithError(w, http.StatusNotFound, "Chirp ID is required", nil)
+    default:
+        respondWithError(w, http.StatusBadRequest, "Invalid chirp ID format", err)
+    }
+}
+
+// Chirp length on the free plan. Chirpy Red allows longer ones, see
+// entitlementsFor.
+const maxChirpLength = 140
+
+// moderateChirpBody runs body through the moderation filter. Masked terms are
+// replaced in the returned body; when a term rejects the chirp it writes the
+// error response itself and returns false.
+func (cfg *apiConfig) moderateChirpBody(w http.ResponseWriter, body string) (moderation.Result, bool) {
+    result := cfg.moderation.Check(body)
+    if result.Rejected {
+        respondWithError(w, http.StatusBadRequest, "Chirp contains prohibited content", nil)
+        return result, false
+    }
+    return result, true
+}
+
+// saveModerationFlags opens a report for each flagged term found in a chirp
+// so it shows up in the moderators' review queue.
+func saveModerationFlags(ctx context.Context, q *database.Queries, chirpID uuid.UUID, result moderation.Result) error {
+    seen := map[string]bool{}
+    for _, match := range result.Matches {
+        if match.Term.Action != moderation.ActionFlag || seen[match.Term.Term] {
+            continue
+        }
+        seen[match.Term.Term] = true
+
+        _, err := q.CreateReport(ctx, database.CreateReportParams{
+            ChirpID: chirpID,
+            Reason:  reportReasonFlaggedTerm,
+            Details: match.Term.Term,
+        })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// createChirp stores a chirp that has passed moderation, along with its
+// hashtags, mentions and any flags raised by the filter, queues the
+// chirp.created webhook and pushes the chirp to streams.
+func createChirp(ctx context.Context, q *database.Queries, userID uuid.UUID, inReplyTo uuid.NullUUID, moderated moderation.Result) (database.Chirp, error) {
+    chirp, err := q.CreateChirp(ctx, database.CreateChirpParams{
+        Body: moderated.Text,
+        UserID: uuid.NullUUID{
+            UUID:  userID,
+            Valid: true,
+        },
+        InReplyTo: inReplyTo,
+    })
+    if err != nil {
+        return database.Chirp{}, err
+    }
+
+    // Index hashtags and mentions alongside the chirp
+    if err := saveChirpEntities(ctx, q, chirp); err != nil {
+        return database.Chirp{}, err
+    }
+
+    if err := saveModerationFlags(ctx, q, chirp.ID, moderated); err != nil {
+        return database.Chirp{}, err
+    }
+
+    err = enqueueWebhookEvent(ctx, q, webhookEventChirpCreated, webhookChirpData{
+        ID:        chirp.ID,
+        Body:      chirp.Body,
+        UserID:    chirp.UserID.UUID,
+        InReplyTo: chirp.InReplyTo,
+        CreatedAt: chirp.CreatedAt,
+    })
+    if err != nil {
+        return database.Chirp{}, err
+    }
+
+    if err := publishChirpEvent(ctx, q, streamEventChirpCreated, chirp); err != nil {
+        return database.Chirp{}, err
+    }
+    return chirp, nil
+}
+
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
     type parameters struct {
         Body string `json:"body"`
         UserID uuid.UUID `json:"user_id"`
+        InReplyTo uuid.NullUUID `json:"in_reply_to"`
+        // Publishes the chirp later instead of now
+        PublishAt *time.Time `json:"publish_at"`
     }
     
     decoder := json.NewDecoder(r.Body)
@@ -77,66 +444,67 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
         return
     }
 
-    const maxChirpLength = 140
-    if len(params.Body) > maxChirpLength {
-        respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+    // Use helper function to validate JWT
+    userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+    if err != nil {
+        respondWithAuthError(w, err)
         return
     }
 
-    // Use helper function to validate JWT
-    userID, err := cfg.validateJWTFromRequest(r)
-    if err != nil {
-        switch err {
-        case ErrMissingAuthHeader:
-            respondWithError(w, http.StatusUnauthorized, "Missing authorization header", nil)
-        case ErrInvalidAuthHeaderFormat:
-            respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format", nil)
-        default:
-            respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
-        }
+    user, ok := cfg.requireActiveUser(w, r, userID)
+    if !ok {
         return
     }
 
-    // Process text to find profane words (case insensitive)
-    cleanedBody := params.Body
-    lowerText := strings.ToLower(params.Body)
-    
-    profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-    for _, profaneWord := range profaneWords {
-        // Find all instances of the profane word (case insensitive)
-        index := strings.Index(lowerText, profaneWord)
-        for index != -1 {
-            // Replace in the original text while preserving case
-            cleanedBody = cleanedBody[:index] + "****" + cleanedBody[index+len(profaneWord):]
-            // Also update the lowercase text for further searches
-            lowerText = lowerText[:index] + "****" + lowerText[index+len(profaneWord):]
-            // Find the next instance
-            index = strings.Index(lowerText, profaneWord)
+    plan := entitlementsFor(user)
+    if !plan.requireChirpLength(w, params.Body) {
+        return
+    }
+    if params.PublishAt != nil && !plan.require(w, featureScheduleChirps) {
+        return
+    }
+
+    // Replies can only be made to chirps that still exist
+    if params.InReplyTo.Valid {
+        parent, err := cfg.DB.GetChirpbyId(r.Context(), params.InReplyTo.UUID)
+        if err != nil || chirpRemoved(parent) {
+            respondWithError(w, http.StatusNotFound, "Chirp being replied to doesn't exist", err)
+            return
         }
     }
 
-    // Create the chirp in the database
-    chirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-        Body: cleanedBody,
-        UserID: uuid.NullUUID{
-        UUID:  userID,  // Use the ID from the token
-        Valid: true,
-    },
-    })
+    // Mask or reject banned terms before storing the chirp
+    moderated, ok := cfg.moderateChirpBody(w, params.Body)
+    if !ok {
+        return
+    }
+
+    if params.PublishAt != nil {
+        cfg.scheduleChirp(w, r, userID, params.Body, params.InReplyTo, *params.PublishAt)
+        return
+    }
 
+    tx, err := cfg.db.BeginTx(r.Context(), nil)
     if err != nil {
         respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
         return
     }
+    defer tx.Rollback()
+
+    // Create the chirp in the database
+    chirp, err := createChirp(r.Context(), cfg.DB.WithTx(tx), userID, params.InReplyTo, moderated)
+    if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+        return
+    }
+
+    if err := tx.Commit(); err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+        return
+    }
     
     // Respond with the created chirp
-    respondWithJSON(w, http.StatusCreated, ChirpResponse{
-        ID:        chirp.ID,
-        CreatedAt: chirp.CreatedAt,
-        UpdatedAt: chirp.UpdatedAt,
-        Body:      chirp.Body,
-        UserID:    chirp.UserID.UUID,
-    })
+    cfg.respondWithChirp(w, r, http.StatusCreated, chirp)
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
@@ -148,9 +516,17 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
     if sortDir != "desc" && sortDir != "asc" {
         sortDir = "asc" // Default to ascending if invalid or missing
     }
+
+    limit, cursor, err := parsePageParams(r)
+    if err != nil {
+        respondWithPageParamsError(w, err)
+        return
+    }
+
+    // Fetch one extra row so we know whether another page follows
+    pageLimit := int32(limit + 1)
     
-    var chirps []database.Chirp
-    var err error
+    var items []feedItem
     
     if authorIDStr != "" {
         // Parse author ID
@@ -160,54 +536,73 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
             return
         }
         
-        // Get chirps filtered by author
-        chirps, err = cfg.DB.GetChirpsByAuthor(r.Context(), uuid.NullUUID{
-            UUID:  authorID,
-            Valid: true,
-        })
+        // Get chirps and rechirps by the author
+        if sortDir == "asc" {
+            rows, err := cfg.DB.GetAuthorFeedPageAsc(r.Context(), database.GetAuthorFeedPageAscParams{
+                UserID:          authorID,
+                CursorCreatedAt: cursor.nullCreatedAt(),
+                CursorID:        cursor.nullID(),
+                PageLimit:       pageLimit,
+            })
+            if err != nil {
+                respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+                return
+            }
+            for _, row := range rows {
+                items = append(items, feedItem(row))
+            }
+        } else {
+            rows, err := cfg.DB.GetAuthorFeedPageDesc(r.Context(), database.GetAuthorFeedPageDescParams{
+                UserID:          authorID,
+                CursorCreatedAt: cursor.nullCreatedAt(),
+                CursorID:        cursor.nullID(),
+                PageLimit:       pageLimit,
+            })
+            if err != nil {
+                respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+                return
+            }
+            for _, row := range rows {
+                items = append(items, feedItem(row))
+            }
+        }
     } else {
-        // Get all chirps
-        chirps, err = cfg.DB.GetChirps(r.Context())
+        var chirps []database.Chirp
+        if sortDir == "asc" {
+            chirps, err = cfg.DB.GetChirpsPageAsc(r.Context(), database.GetChirpsPageAscParams{
+                CursorCreatedAt: cursor.nullCreatedAt(),
+                CursorID:        cursor.nullID(),
+                PageLimit:       pageLimit,
+            })
+        } else {
+            chirps, err = cfg.DB.GetChirpsPageDesc(r.Context(), database.GetChirpsPageDescParams{
+                CursorCreatedAt: cursor.nullCreatedAt(),
+                CursorID:        cursor.nullID(),
+                PageLimit:       pageLimit,
+            })
+        }
+        if err != nil {
+            respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+            return
+        }
+        items = chirpFeedItems(chirps)
     }
     
+    // Convert database chirps to response chirps
+    page, err := cfg.feedPage(r.Context(), items, limit, cfg.optionalUserIDFromRequest(r))
     if err != nil {
         respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
         return
     }
     
-    // Sort chirps in memory based on the sort parameter
-    sort.Slice(chirps, func(i, j int) bool {
-        if sortDir == "asc" {
-            return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
-        }
-        return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-    })
-    
-    // Convert database chirps to response chirps
-    chirpResponses := []ChirpResponse{}
-    for _, chirp := range chirps {
-        chirpResponses = append(chirpResponses, ChirpResponse{
-            ID:        chirp.ID,
-            CreatedAt: chirp.CreatedAt,
-            UpdatedAt: chirp.UpdatedAt,
-            Body:      chirp.Body,
-            UserID:    chirp.UserID.UUID,
-        })
-    }
-    
-    respondWithJSON(w, http.StatusOK, chirpResponses)
+    respondWithJSON(w, http.StatusOK, page)
 }
 
 func (cfg *apiConfig) handlerGetChirpbyId(w http.ResponseWriter, r *http.Request) {
     // Use helper function to get chirpID
     chirpID, err := getChirpIDFromPath(r)
     if err != nil {
-        switch err {
-        case ErrMissingChirpID:
-            respondWithError(w, http.StatusNotFound, "Chirp ID is required", nil)
-        default:
-            respondWithError(w, http.StatusBadRequest, "Invalid chirp ID format", err)
-        }
+        respondWithChirpIDError(w, err)
         return
     }
 
@@ -216,46 +611,41 @@ func (cfg *apiConfig) handlerGetChirpbyId(w http.ResponseWriter, r *http.Request
         respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
         return
     }
+    if chirpRemoved(chirp) {
+        respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+        return
+    }
 
-    respondWithJSON(w, http.StatusOK, ChirpResponse{
-        ID:        chirp.ID,
-        CreatedAt: chirp.CreatedAt,
-        UpdatedAt: chirp.UpdatedAt,
-        Body:      chirp.Body,
-        UserID:    chirp.UserID.UUID,
-    })
+    cfg.respondWithChirp(w, r, http.StatusOK, chirp)
 }
 
 func (cfg *apiConfig) handlerDeleteChirpbyId(w http.ResponseWriter, r *http.Request) {
     // Use helper function to validate JWT
-    userID, err := cfg.validateJWTFromRequest(r)
-    if err != nil {
-        switch err {
-        case ErrMissingAuthHeader:
-            respondWithError(w, http.StatusUnauthorized, "Missing authorization header", nil)
-        case ErrInvalidAuthHeaderFormat:
-            respondWithError(w, http.StatusUnauthorized, "Invalid authorization header format", nil)
-        default:
-            respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
-        }
+    userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+    if err != nil {
+        respondWithAuthError(w, err)
         return
     }
 
     // Use helper function to get chirpID
     chirpID, err := getChirpIDFromPath(r)
     if err != nil {
-        switch err {
-        case ErrMissingChirpID:
-            respondWithError(w, http.StatusNotFound, "Chirp ID is required", nil)
-        default:
-            respondWithError(w, http.StatusBadRequest, "Invalid chirp ID format", err)
-        }
+        respondWithChirpIDError(w, err)
         return
     }
 
-    // check if the chirp belongs to the user
-    chirp, err := cfg.DB.GetChirpbyId(r.Context(), chirpID)
+    tx, err := cfg.db.BeginTx(r.Context(), nil)
     if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+        return
+    }
+    defer tx.Rollback()
+    qtx := cfg.DB.WithTx(tx)
+
+    // check if the chirp belongs to the user, locking it so no reply
+    // sneaks in between counting replies and deleting
+    chirp, err := qtx.GetChirpbyIdForUpdate(r.Context(), chirpID)
+    if err != nil || chirp.DeletedAt.Valid {
         respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
         return
     }
@@ -265,11 +655,77 @@ func (cfg *apiConfig) handlerDeleteChirpbyId(w http.ResponseWriter, r *http.Requ
         return
     }
 
-    err = cfg.DB.DeleteChirpbyId(r.Context(), chirpID)
+    err = deleteChirp(r.Context(), qtx, chirp)
     if err != nil {
         respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
         return
     }
 
+    if err := tx.Commit(); err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+        return
+    }
+
     respondWithJSON(w, http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
+
+// Helper function to remove a chirp, queue the chirp.deleted webhook and
+// tell streams.
+func deleteChirp(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+    if err := removeChirp(ctx, q, chirp); err != nil {
+        return err
+    }
+    return announceChirpDeleted(ctx, q, chirp)
+}
+
+// Helper function for moderators deleting a chirp. The chirp is always
+// tombstoned rather than deleted outright, so the reports on it and how they
+// were resolved stay on record.
+func moderatorDeleteChirp(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+    if err := tombstoneChirp(ctx, q, chirp); err != nil {
+        return err
+    }
+    return announceChirpDeleted(ctx, q, chirp)
+}
+
+// Helper function to queue the chirp.deleted webhook and tell streams.
+func announceChirpDeleted(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+    err := enqueueWebhookEvent(ctx, q, webhookEventChirpDeleted, webhookChirpDeletedData{
+        ID:     chirp.ID,
+        UserID: chirp.UserID.UUID,
+    })
+    if err != nil {
+        return err
+    }
+    return publishChirpDeleted(ctx, q, chirp)
+}
+
+// Chirps that have replies are replaced by a tombstone so their threads stay
+// intact; the rest are deleted outright.
+func removeChirp(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+    replyCount, err := q.CountChirpReplies(ctx, uuid.NullUUID{UUID: chirp.ID, Valid: true})
+    if err != nil {
+        return err
+    }
+
+    if replyCount == 0 {
+        return q.DeleteChirpbyId(ctx, chirp.ID)
+    }
+    return tombstoneChirp(ctx, q, chirp)
+}
+
+// Helper function to blank a chirp, keeping its row, and drop everything
+// that would still reveal what it said.
+func tombstoneChirp(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+    if _, err := q.TombstoneChirp(ctx, chirp.ID); err != nil {
+        return err
+    }
+    if err := q.DeleteChirpHashtags(ctx, chirp.ID); err != nil {
+        return err
+    }
+    if err := q.DeleteChirpMentions(ctx, chirp.ID); err != nil {
+        return err
+    }
+    // Earlier versions would otherwise still be readable
+    return q.DeleteChirpRevisions(ctx, chirp.ID)
+}
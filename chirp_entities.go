@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/entities"
+)
+
+// ChirpEntities lets clients render links without parsing bodies themselves.
+// Offsets count Unicode code points.
+type ChirpEntities struct {
+	Hashtags []HashtagEntity `json:"hashtags"`
+	Mentions []MentionEntity `json:"mentions"`
+}
+
+type HashtagEntity struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+type MentionEntity struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Start    int       `json:"start"`
+	End      int       `json:"end"`
+}
+
+// newChirpEntities fills in the hashtags of body. Mentions need a lookup and
+// are added by chirpResponses.
+func newChirpEntities(body string) ChirpEntities {
+	hashtags := []HashtagEntity{}
+	for _, hashtag := range entities.Hashtags(body) {
+		hashtags = append(hashtags, HashtagEntity{
+			Tag:   hashtag.Text,
+			Start: hashtag.Start,
+			End:   hashtag.End,
+		})
+	}
+	return ChirpEntities{
+		Hashtags: hashtags,
+		Mentions: []MentionEntity{},
+	}
+}
+
+// saveChirpEntities replaces the stored hashtags and mentions of a chirp with
+// the ones in its current body. Mentions of unknown usernames are dropped.
+func saveChirpEntities(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	if err := q.DeleteChirpHashtags(ctx, chirp.ID); err != nil {
+		return err
+	}
+	if err := q.DeleteChirpMentions(ctx, chirp.ID); err != nil {
+		return err
+	}
+
+	seenTags := map[string]bool{}
+	for _, hashtag := range entities.Hashtags(chirp.Body) {
+		if seenTags[hashtag.Text] {
+			continue
+		}
+		seenTags[hashtag.Text] = true
+
+		tag, err := q.UpsertHashtag(ctx, hashtag.Text)
+		if err != nil {
+			return err
+		}
+		err = q.AddChirpHashtag(ctx, database.AddChirpHashtagParams{
+			ChirpID:   chirp.ID,
+			HashtagID: tag.ID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	mentions := entities.Mentions(chirp.Body)
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	usernames := make([]string, 0, len(mentions))
+	for _, mention := range mentions {
+		usernames = append(usernames, mention.Text)
+	}
+	users, err := q.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return err
+	}
+	userIDs := make(map[string]uuid.UUID, len(users))
+	for _, user := range users {
+		userIDs[user.Username.String] = user.ID
+	}
+
+	for _, mention := range mentions {
+		userID, ok := userIDs[mention.Text]
+		if !ok {
+			continue
+		}
+		err := q.CreateChirpMention(ctx, database.CreateChirpMentionParams{
+			ChirpID:     chirp.ID,
+			UserID:      userID,
+			StartOffset: int32(mention.Start),
+			EndOffset:   int32(mention.End),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// feature is something only some plans include.
+type feature string
+
+const (
+	featureEditChirps     feature = "edit_chirps"
+	featureScheduleChirps feature = "schedule_chirps"
+)
+
+// entitlements describe what a user's plan lets them do. Handlers get them
+// from entitlementsFor instead of looking at is_chirpy_red themselves, so
+// what each plan includes is decided in this file only.
+type entitlements struct {
+	plan                string
+	maxChirpLength      int
+	rateLimitMultiplier int
+	features            map[feature]bool
+}
+
+const (
+	planFree = "free"
+	planRed  = "chirpy_red"
+)
+
+const maxRedChirpLength = 1000
+
+var (
+	freeEntitlements = entitlements{
+		plan:                planFree,
+		maxChirpLength:      maxChirpLength,
+		rateLimitMultiplier: 1,
+	}
+	redEntitlements = entitlements{
+		plan:                planRed,
+		maxChirpLength:      maxRedChirpLength,
+		rateLimitMultiplier: 5,
+		features: map[feature]bool{
+			featureEditChirps:     true,
+			featureScheduleChirps: true,
+		},
+	}
+)
+
+func entitlementsFor(user database.User) entitlements {
+	if user.IsChirpyRed {
+		return redEntitlements
+	}
+	return freeEntitlements
+}
+
+func (e entitlements) allows(f feature) bool {
+	return e.features[f]
+}
+
+// require writes a 403 and returns false when the plan doesn't include f.
+func (e entitlements) require(w http.ResponseWriter, f feature) bool {
+	if !e.allows(f) {
+		respondWithError(w, http.StatusForbidden, "This feature requires Chirpy Red", nil)
+		return false
+	}
+	return true
+}
+
+// requireChirpLength writes a 400 and returns false when body is longer
+// than the plan allows.
+func (e entitlements) requireChirpLength(w http.ResponseWriter, body string) bool {
+	if len(body) > e.maxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return false
+	}
+	return true
+}
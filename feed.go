@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// feedItem is one entry of a feed: a chirp, either posted directly or
+// rechirped by RechirpedBy. The activity fields order the feed and build
+// its cursors, since a rechirp sorts by when it was rechirped.
+type feedItem struct {
+	Chirp       database.Chirp
+	ActivityID  uuid.UUID
+	ActivityAt  time.Time
+	RechirpedBy uuid.NullUUID
+}
+
+func chirpFeedItems(chirps []database.Chirp) []feedItem {
+	items := make([]feedItem, 0, len(chirps))
+	for _, chirp := range chirps {
+		items = append(items, feedItem{
+			Chirp:      chirp,
+			ActivityID: chirp.ID,
+			ActivityAt: chirp.CreatedAt,
+		})
+	}
+	return items
+}
+
+// feedPage builds a page of chirps from items fetched with one extra row,
+// which only signals that another page follows.
+func (cfg *apiConfig) feedPage(ctx context.Context, items []feedItem, limit int, viewerID uuid.NullUUID) (ChirpPageResponse, error) {
+	nextCursor := ""
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[limit-1]
+		nextCursor = encodeCursor(last.ActivityAt, last.ActivityID)
+	}
+
+	chirps := make([]database.Chirp, 0, len(items))
+	for _, item := range items {
+		chirps = append(chirps, item.Chirp)
+	}
+
+	chirpResponses, err := cfg.chirpResponses(ctx, chirps, viewerID)
+	if err != nil {
+		return ChirpPageResponse{}, err
+	}
+
+	for i, item := range items {
+		if item.RechirpedBy.Valid {
+			chirpResponses[i].RechirpedBy = &RechirpResponse{
+				UserID:      item.RechirpedBy.UUID,
+				RechirpedAt: item.ActivityAt,
+			}
+		}
+	}
+
+	return ChirpPageResponse{
+		Chirps:     chirpResponses,
+		NextCursor: nextCursor,
+	}, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+type ChirpRevisionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	ChirpID    uuid.UUID `json:"chirp_id"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, ok := cfg.requireActiveUser(w, r, userID)
+	if !ok {
+		return
+	}
+	plan := entitlementsFor(user)
+	if !plan.require(w, featureEditChirps) {
+		return
+	}
+
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Body == "" {
+		respondWithError(w, http.StatusBadRequest, "Chirp body is required", nil)
+		return
+	}
+	if !plan.requireChirpLength(w, params.Body) {
+		return
+	}
+
+	moderated, ok := cfg.moderateChirpBody(w, params.Body)
+	if !ok {
+		return
+	}
+	cleanedBody := moderated.Text
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the row so concurrent edits can't lose a revision
+	chirp, err := qtx.GetChirpbyIdForUpdate(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	if chirpRemoved(chirp) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+		return
+	}
+
+	if chirp.UserID.UUID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not authorized to edit this chirp", nil)
+		return
+	}
+
+	// Nothing changed, so there is no revision to record
+	if chirp.Body == cleanedBody {
+		cfg.respondWithChirp(w, r, http.StatusOK, chirp)
+		return
+	}
+
+	_, err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+		ChirpID:   chirp.ID,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chirp revision", err)
+		return
+	}
+
+	updated, err := qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirp.ID,
+		Body: cleanedBody,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	if err := saveChirpEntities(r.Context(), qtx, updated); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp entities", err)
+		return
+	}
+
+	if err := saveModerationFlags(r.Context(), qtx, updated.ID, moderated); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't flag chirp", err)
+		return
+	}
+
+	if err := publishChirpEvent(r.Context(), qtx, streamEventChirpEdited, updated); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	cfg.respondWithChirp(w, r, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerGetChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirpbyId(r.Context(), chirpID)
+	if err != nil || chirpRemoved(chirp) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+
+	revisions, err := cfg.DB.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp revisions", err)
+		return
+	}
+
+	revisionResponses := []ChirpRevisionResponse{}
+	for _, revision := range revisions {
+		revisionResponses = append(revisionResponses, ChirpRevisionResponse{
+			ID:         revision.ID,
+			ChirpID:    revision.ChirpID,
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, revisionResponses)
+}
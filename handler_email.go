@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/jobs"
+	"github.com/vanzei/goserver/internal/mailer"
+)
+
+// Purposes of the single-use tokens sent by email
+const (
+	emailTokenVerify = "verify_email"
+	emailTokenReset  = "reset_password"
+	emailTokenChange = "change_email"
+)
+
+// Tells the current address that the account's address is being changed.
+// Unlike the token purposes it mails no link.
+const emailChangeNotice = "change_email_notice"
+
+const (
+	verificationTokenTTL = 24 * time.Hour
+	resetTokenTTL        = time.Hour
+	// How long to keep trying to hand a message to the mail server
+	sendMailTimeout = 30 * time.Second
+)
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	token, err := qtx.UseEmailToken(r.Context(), database.UseEmailTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   emailTokenVerify,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	user, err := qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "The email address has changed since this token was sent", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handlerConfirmEmailChange switches an account to the address a
+// change_email token was sent to.
+func (cfg *apiConfig) handlerConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	token, err := qtx.UseEmailToken(r.Context(), database.UseEmailTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   emailTokenChange,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+		return
+	}
+
+	user, err := qtx.ChangeUserEmail(r.Context(), database.ChangeUserEmailParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil {
+		if isUniqueViolation(err, "users_email_key") {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+		return
+	}
+
+	// Tokens mailed to the old address shouldn't outlive it
+	for _, purpose := range []string{emailTokenVerify, emailTokenReset} {
+		err := qtx.InvalidateEmailTokens(r.Context(), database.InvalidateEmailTokensParams{
+			UserID:  user.ID,
+			Purpose: purpose,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handlerResendVerification mails a new verification token, for when the
+// first one got lost or expired.
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	if err := mailVerificationToken(r.Context(), cfg.DB, user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerForgotPassword mails a password reset token. It responds the same
+// way whether or not the address belongs to an account, so it can't be used
+// to find out who has one.
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(r.Context(), params.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	if err == nil {
+		err := enqueueMail(r.Context(), cfg.DB, emailJob{
+			Purpose: emailTokenReset,
+			UserID:  user.ID,
+			To:      user.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send reset email", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerResetPassword sets a new password with a token from
+// handlerForgotPassword and logs out every session.
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	token, err := qtx.UseEmailToken(r.Context(), database.UseEmailTokenParams{
+		TokenHash: auth.HashToken(params.Token),
+		Purpose:   emailTokenReset,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	user, err := qtx.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	// Rolling back leaves the token unused, so it can be tried again
+	if !validatePasswordPolicy(w, params.Password, user.Email, user.Username.String) {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	if _, err := qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             token.UserID,
+		HashedPassword: hashedPassword,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	// Whoever knew the old password mustn't stay logged in
+	err = qtx.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+		UserID: token.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	if err := qtx.DeleteUserMFAChallenges(r.Context(), token.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete MFA challenges", err)
+		return
+	}
+	// The owner shouldn't stay locked out by someone else's guesses
+	if _, err := qtx.ResetFailedLogins(r.Context(), token.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+		return
+	}
+
+	// Receiving the token proves the address works
+	_, err = qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueEmailToken creates a single-use token to mail for payload, replacing
+// unused tokens with the same purpose from earlier requests. It returns
+// false without a token if a later request has been issued one already, so
+// a retried or delayed job can't cancel it.
+func (cfg *apiConfig) issueEmailToken(ctx context.Context, payload emailJob, ttl time.Duration) (string, bool, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	if err := qtx.LockEmailTokens(ctx, payload.UserID); err != nil {
+		return "", false, err
+	}
+	superseded, err := qtx.HasLaterEmailTokenRequest(ctx, database.HasLaterEmailTokenRequestParams{
+		UserID:      payload.UserID,
+		Purpose:     payload.Purpose,
+		RequestedAt: payload.RequestedAt,
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if superseded {
+		return "", false, nil
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	err = qtx.InvalidateEmailTokens(ctx, database.InvalidateEmailTokensParams{
+		UserID:  payload.UserID,
+		Purpose: payload.Purpose,
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = qtx.CreateEmailToken(ctx, database.CreateEmailTokenParams{
+		UserID:      payload.UserID,
+		Purpose:     payload.Purpose,
+		TokenHash:   auth.HashToken(token),
+		Email:       payload.To,
+		ExpiresAt:   time.Now().UTC().Add(ttl),
+		RequestedAt: payload.RequestedAt,
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// mailEmailChange mails a confirmation link to the new address, and lets the
+// current address know about the change in case it wasn't the owner.
+func mailEmailChange(ctx context.Context, q *database.Queries, user database.User, newEmail string) error {
+	err := enqueueMail(ctx, q, emailJob{
+		Purpose: emailTokenChange,
+		UserID:  user.ID,
+		To:      newEmail,
+	})
+	if err != nil {
+		return err
+	}
+	return enqueueMail(ctx, q, emailJob{
+		Purpose:  emailChangeNotice,
+		UserID:   user.ID,
+		To:       user.Email,
+		NewEmail: newEmail,
+	})
+}
+
+// mailVerificationToken mails user a token to verify their address with.
+func mailVerificationToken(ctx context.Context, q *database.Queries, user database.User) error {
+	return enqueueMail(ctx, q, emailJob{
+		Purpose: emailTokenVerify,
+		UserID:  user.ID,
+		To:      user.Email,
+	})
+}
+
+// emailJob is the payload of an email.send job. The token a mail links to is
+// only issued when the job runs, so tokens are never stored in the queue.
+type emailJob struct {
+	// One of the emailToken purposes, or emailChangeNotice
+	Purpose string    `json:"purpose"`
+	UserID  uuid.UUID `json:"user_id"`
+	// The address to mail, which the token is issued for
+	To string `json:"to"`
+	// The address being changed to, for emailChangeNotice
+	NewEmail string `json:"new_email,omitempty"`
+	// When the mail was asked for. Of several requests for the same kind of
+	// token, the latest one's is the token that works.
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// enqueueMail queues a mail to be sent by a background job. Requests don't
+// wait on the mail server, and response times don't reveal whether a
+// message was sent. Pass the queries of the transaction making the change
+// the mail is about, so the mail only goes out if it commits.
+func enqueueMail(ctx context.Context, q *database.Queries, job emailJob) error {
+	job.RequestedAt = time.Now().UTC()
+	return jobs.Enqueue(ctx, q, jobKindSendEmail, job)
+}
+
+// handleSendEmailJob issues the token for a mail queued by enqueueMail and
+// sends it. A retry issues a new token, which replaces the old one. Mails
+// that no longer fit the account, or whose token has been replaced by a
+// later request, are dropped.
+func (cfg *apiConfig) handleSendEmailJob(ctx context.Context, job jobs.Job) error {
+	var payload emailJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+
+	// Nothing to send once the account is gone
+	user, err := cfg.DB.GetUserByID(ctx, payload.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if !emailStillWanted(user, payload) {
+		return nil
+	}
+
+	msg, ok, err := cfg.composeEmail(ctx, payload)
+	if err != nil || !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendMailTimeout)
+	defer cancel()
+	if err := cfg.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("couldn't send %q to %s: %w", msg.Subject, msg.To, err)
+	}
+	return nil
+}
+
+// emailStillWanted reports whether the mail for payload is still of use to
+// user as they are now. The address may have changed or been verified since
+// the mail was asked for.
+func emailStillWanted(user database.User, payload emailJob) bool {
+	switch payload.Purpose {
+	case emailTokenVerify:
+		return user.Email == payload.To && !user.EmailVerifiedAt.Valid
+	case emailTokenReset:
+		return user.Email == payload.To
+	case emailTokenChange:
+		return user.Email != payload.To
+	default:
+		return true
+	}
+}
+
+// composeEmail writes the mail for payload, issuing its token if it has one.
+// It returns false if a later request has been issued the token instead.
+func (cfg *apiConfig) composeEmail(ctx context.Context, payload emailJob) (mailer.Message, bool, error) {
+	msg := mailer.Message{To: payload.To}
+
+	switch payload.Purpose {
+	case emailTokenVerify:
+		token, ok, err := cfg.issueEmailToken(ctx, payload, verificationTokenTTL)
+		if err != nil || !ok {
+			return mailer.Message{}, false, err
+		}
+		msg.Subject = "Verify your Chirpy email address"
+		msg.Body = fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"To verify your email address, open this link within 24 hours:\n%s\n",
+			cfg.emailLink("verify-email", token))
+	case emailTokenReset:
+		token, ok, err := cfg.issueEmailToken(ctx, payload, resetTokenTTL)
+		if err != nil || !ok {
+			return mailer.Message{}, false, err
+		}
+		msg.Subject = "Reset your Chirpy password"
+		msg.Body = fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, open this link within an hour:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			cfg.emailLink("reset-password", token))
+	case emailTokenChange:
+		token, ok, err := cfg.issueEmailToken(ctx, payload, verificationTokenTTL)
+		if err != nil || !ok {
+			return mailer.Message{}, false, err
+		}
+		msg.Subject = "Confirm your new Chirpy email address"
+		msg.Body = fmt.Sprintf("To start using this address for your Chirpy account, open this link within 24 hours:\n%s\n",
+			cfg.emailLink("confirm-email", token))
+	case emailChangeNotice:
+		msg.Subject = "Your Chirpy email address is being changed"
+		msg.Body = fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s.\n\n"+
+			"If it wasn't you, change your password and log out your other sessions.\n", payload.NewEmail)
+	default:
+		return mailer.Message{}, false, fmt.Errorf("unknown email purpose %q", payload.Purpose)
+	}
+	return msg, true, nil
+}
+
+// emailLink returns the link to the page of the web app that handles token.
+func (cfg *apiConfig) emailLink(page, token string) string {
+	return cfg.publicURL + "/app/" + page + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+type FollowResponse struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowPageResponse struct {
+	Users      []FollowResponse `json:"users"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, err := cfg.validateJWTFromRequest(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	if _, ok := cfg.requireActiveUser(w, r, followerID); !ok {
+		return
+	}
+
+	followeeID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	if followerID == followeeID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+		return
+	}
+
+	if _, err := cfg.DB.GetUserByID(r.Context(), followeeID); err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+		return
+	}
+
+	// Following someone twice is a no-op
+	err = cfg.DB.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, err := cfg.validateJWTFromRequest(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followeeID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	err = cfg.DB.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	rows, err := cfg.DB.GetFollowersPage(r.Context(), database.GetFollowersPageParams{
+		UserID:          userID,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get followers", err)
+		return
+	}
+
+	follows := make([]FollowResponse, 0, len(rows))
+	for _, row := range rows {
+		follows = append(follows, FollowResponse{
+			UserID:     row.UserID,
+			FollowedAt: row.FollowedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, newFollowPage(follows, limit))
+}
+
+func (cfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	rows, err := cfg.DB.GetFollowingPage(r.Context(), database.GetFollowingPageParams{
+		UserID:          userID,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get followed users", err)
+		return
+	}
+
+	follows := make([]FollowResponse, 0, len(rows))
+	for _, row := range rows {
+		follows = append(follows, FollowResponse{
+			UserID:     row.UserID,
+			FollowedAt: row.FollowedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, newFollowPage(follows, limit))
+}
+
+// newFollowPage trims the extra row fetched to detect a following page.
+func newFollowPage(follows []FollowResponse, limit int) FollowPageResponse {
+	page := FollowPageResponse{Users: follows}
+	if len(follows) > limit {
+		page.Users = follows[:limit]
+		last := page.Users[limit-1]
+		page.NextCursor = encodeCursor(last.FollowedAt, last.UserID)
+	}
+	return page
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	userID, chirpID, ok := cfg.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// Liking a chirp twice is a no-op
+	err := cfg.DB.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	err = cfg.DB.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	userID, chirpID, ok := cfg.reactionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// Rechirping a chirp twice is a no-op
+	err := cfg.DB.CreateRechirp(r.Context(), database.CreateRechirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp chirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	err = cfg.DB.DeleteRechirp(r.Context(), database.DeleteRechirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't undo rechirp", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reactionTarget authenticates the caller and checks that the chirp in the
+// path can still be liked or rechirped. It writes the error response itself.
+func (cfg *apiConfig) reactionTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+	if _, ok := cfg.requireActiveUser(w, r, userID); !ok {
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	chirp, err := cfg.DB.GetChirpbyId(r.Context(), chirpID)
+	if err != nil || chirpRemoved(chirp) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	return userID, chirpID, true
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/moderation"
+)
+
+// Other instances pick up term changes on their next refresh.
+const moderationRefreshInterval = time.Minute
+
+var ErrMissingTermID = errors.New("missing term ID")
+
+type ModerationTermResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Term      string    `json:"term"`
+	MatchMode string    `json:"match_mode"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ModerationActionResponse struct {
+	ID             uuid.UUID     `json:"id"`
+	Action         string        `json:"action"`
+	ModeratorID    uuid.NullUUID `json:"moderator_id"`
+	ReportID       uuid.NullUUID `json:"report_id"`
+	ChirpID        uuid.NullUUID `json:"chirp_id"`
+	ChirpBody      *string       `json:"chirp_body,omitempty"`
+	UserID         uuid.NullUUID `json:"user_id"`
+	Note           string        `json:"note"`
+	SuspendedUntil *time.Time    `json:"suspended_until,omitempty"`
+	Role           *string       `json:"role,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+type ModerationActionPageResponse struct {
+	Actions    []ModerationActionResponse `json:"actions"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+}
+
+func newModerationTermResponse(term database.ModerationTerm) ModerationTermResponse {
+	return ModerationTermResponse{
+		ID:        term.ID,
+		Term:      term.Term,
+		MatchMode: term.MatchMode,
+		Action:    term.Action,
+		CreatedAt: term.CreatedAt,
+		UpdatedAt: term.UpdatedAt,
+	}
+}
+
+// loadModerationTerms replaces the terms of the in-memory filter with the
+// ones stored in the database.
+func (cfg *apiConfig) loadModerationTerms(ctx context.Context) error {
+	rows, err := cfg.DB.ListModerationTerms(ctx)
+	if err != nil {
+		return err
+	}
+
+	terms := make([]moderation.Term, 0, len(rows))
+	for _, row := range rows {
+		terms = append(terms, moderation.Term{
+			Term:   row.Term,
+			Mode:   moderation.MatchMode(row.MatchMode),
+			Action: moderation.Action(row.Action),
+		})
+	}
+	cfg.moderation.SetTerms(terms)
+	return nil
+}
+
+// refreshModerationTerms reloads the filter until ctx is done.
+func (cfg *apiConfig) refreshModerationTerms(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.loadModerationTerms(ctx); err != nil {
+				log.Printf("Couldn't refresh moderation terms: %s", err)
+			}
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerListModerationTerms(w http.ResponseWriter, r *http.Request) {
+	terms, err := cfg.DB.ListModerationTerms(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation terms", err)
+		return
+	}
+
+	response := make([]ModerationTermResponse, 0, len(terms))
+	for _, term := range terms {
+		response = append(response, newModerationTermResponse(term))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerCreateModerationTerm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Term      string `json:"term"`
+		MatchMode string `json:"match_mode"`
+		Action    string `json:"action"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.MatchMode == "" {
+		params.MatchMode = string(moderation.MatchSubstring)
+	}
+	if params.Action == "" {
+		params.Action = string(moderation.ActionMask)
+	}
+
+	term, ok := parseModerationTerm(w, params.Term)
+	if !ok {
+		return
+	}
+	if _, err := moderation.ParseMatchMode(params.MatchMode); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Match mode must be whole_word or substring", err)
+		return
+	}
+	if _, err := moderation.ParseAction(params.Action); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Action must be mask, reject or flag", err)
+		return
+	}
+
+	created, err := cfg.DB.CreateModerationTerm(r.Context(), database.CreateModerationTermParams{
+		Term:      term,
+		MatchMode: params.MatchMode,
+		Action:    params.Action,
+	})
+	if err != nil {
+		if isUniqueViolation(err, "moderation_terms_term_key") {
+			respondWithError(w, http.StatusConflict, "Term already exists", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create moderation term", err)
+		return
+	}
+
+	if err := cfg.loadModerationTerms(r.Context()); err != nil {
+		log.Printf("Couldn't reload moderation terms: %s", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, newModerationTermResponse(created))
+}
+
+func (cfg *apiConfig) handlerUpdateModerationTerm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Term      *string `json:"term"`
+		MatchMode *string `json:"match_mode"`
+		Action    *string `json:"action"`
+	}
+
+	termID, err := getTermIDFromPath(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid term ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	update := database.UpdateModerationTermParams{ID: termID}
+	if params.Term != nil {
+		term, ok := parseModerationTerm(w, *params.Term)
+		if !ok {
+			return
+		}
+		update.Term = sql.NullString{String: term, Valid: true}
+	}
+	if params.MatchMode != nil {
+		if _, err := moderation.ParseMatchMode(*params.MatchMode); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Match mode must be whole_word or substring", err)
+			return
+		}
+		update.MatchMode = sql.NullString{String: *params.MatchMode, Valid: true}
+	}
+	if params.Action != nil {
+		if _, err := moderation.ParseAction(*params.Action); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Action must be mask, reject or flag", err)
+			return
+		}
+		update.Action = sql.NullString{String: *params.Action, Valid: true}
+	}
+
+	updated, err := cfg.DB.UpdateModerationTerm(r.Context(), update)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Couldn't get moderation term", nil)
+			return
+		}
+		if isUniqueViolation(err, "moderation_terms_term_key") {
+			respondWithError(w, http.StatusConflict, "Term already exists", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update moderation term", err)
+		return
+	}
+
+	if err := cfg.loadModerationTerms(r.Context()); err != nil {
+		log.Printf("Couldn't reload moderation terms: %s", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, newModerationTermResponse(updated))
+}
+
+func (cfg *apiConfig) handlerDeleteModerationTerm(w http.ResponseWriter, r *http.Request) {
+	termID, err := getTermIDFromPath(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid term ID", err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteModerationTerm(r.Context(), termID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete moderation term", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't get moderation term", nil)
+		return
+	}
+
+	if err := cfg.loadModerationTerms(r.Context()); err != nil {
+		log.Printf("Couldn't reload moderation terms: %s", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetModerationActions(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	params := database.GetModerationActionsPageParams{
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	}
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID format", err)
+			return
+		}
+		params.UserID = uuid.NullUUID{UUID: userID, Valid: true}
+	}
+	if chirpIDStr := r.URL.Query().Get("chirp_id"); chirpIDStr != "" {
+		chirpID, err := uuid.Parse(chirpIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid chirp ID format", err)
+			return
+		}
+		params.ChirpID = uuid.NullUUID{UUID: chirpID, Valid: true}
+	}
+
+	actions, err := cfg.DB.GetModerationActionsPage(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation actions", err)
+		return
+	}
+
+	page := ModerationActionPageResponse{Actions: []ModerationActionResponse{}}
+	if len(actions) > limit {
+		actions = actions[:limit]
+		last := actions[len(actions)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	for _, action := range actions {
+		response := ModerationActionResponse{
+			ID:          action.ID,
+			Action:      action.Action,
+			ModeratorID: action.ModeratorID,
+			ReportID:    action.ReportID,
+			ChirpID:     action.ChirpID,
+			UserID:      action.UserID,
+			Note:        action.Note,
+			CreatedAt:   action.CreatedAt,
+		}
+		if action.ChirpBody.Valid {
+			response.ChirpBody = &action.ChirpBody.String
+		}
+		if action.SuspendedUntil.Valid {
+			response.SuspendedUntil = &action.SuspendedUntil.Time
+		}
+		if action.Role.Valid {
+			response.Role = &action.Role.String
+		}
+		page.Actions = append(page.Actions, response)
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// handlerUnsuspendUser lifts a suspension before it runs out.
+func (cfg *apiConfig) handlerUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Note string `json:"note"`
+	}
+
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	// The note is optional, so an empty body is fine
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lift suspension", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	_, err = qtx.SetUserSuspendedUntil(r.Context(), database.SetUserSuspendedUntilParams{
+		ID: userID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lift suspension", err)
+		return
+	}
+
+	_, err = qtx.CreateModerationAction(r.Context(), database.CreateModerationActionParams{
+		Action:      "unsuspend",
+		ModeratorID: staffUserID(r),
+		UserID:      uuid.NullUUID{UUID: userID, Valid: true},
+		Note:        params.Note,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record moderation action", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lift suspension", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireActiveUser checks that a user has verified their email address and
+// isn't suspended before they post or interact with others. It writes the
+// error response itself and returns the user when they may go ahead.
+func (cfg *apiConfig) requireActiveUser(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.User, bool) {
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusUnauthorized, "User no longer exists", nil)
+			return database.User{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+
+	if !user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+		return database.User{}, false
+	}
+
+	if user.SuspendedUntil.Valid && user.SuspendedUntil.Time.After(time.Now().UTC()) {
+		respondWithError(w, http.StatusForbidden, "Account is suspended until "+user.SuspendedUntil.Time.Format(time.RFC3339), nil)
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// parseModerationTerm trims and lowercases a term. It writes the error
+// response itself when nothing matchable is left.
+func parseModerationTerm(w http.ResponseWriter, term string) (string, bool) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if !moderation.ValidTerm(term) {
+		respondWithError(w, http.StatusBadRequest, "Term must contain letters or digits", nil)
+		return "", false
+	}
+	return term, true
+}
+
+func getTermIDFromPath(r *http.Request) (uuid.UUID, error) {
+	termIDStr := r.PathValue("termID")
+	if termIDStr == "" {
+		return uuid.UUID{}, ErrMissingTermID
+	}
+	return uuid.Parse(termIDStr)
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
 )
 
+// handlerRefresh trades a refresh token for a new access token and a new
+// refresh token. The presented token is superseded; if it is ever presented
+// again, someone else has a copy, so its whole family is revoked.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	// Get the refresh token from Authorization header
@@ -20,8 +25,17 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Invalid authorization header", err)
 		return
 	}
-	// Look up the token in the database
-	user, err := cfg.DB.GetUserFromRefreshToken(r.Context(), refreshToken)
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't refresh token", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the token so two refreshes can't both rotate it
+	row, err := qtx.GetRefreshTokenForUpdate(r.Context(), refreshToken)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token", nil)
@@ -30,21 +44,71 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.secret,
-		time.Hour,
-	)
+	stored := row.RefreshToken
+
+	if stored.SupersededAt.Valid {
+		if err := qtx.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh tokens", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke refresh tokens", err)
+			return
+		}
+		log.Printf("Refresh token reuse detected for user %s, revoked token family %s", stored.UserID, stored.FamilyID)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used, please log in again", nil)
+		return
+	}
+	if stored.RevokedAt.Valid || row.Expired {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token", nil)
+		return
+	}
+
+	user, err := qtx.GetUserByID(r.Context(), stored.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate refresh token", err)
+		return
+	}
+	_, err = qtx.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		Token:     newRefreshToken,
+		UserAgent: r.UserAgent(),
+		IpAddress: cfg.clientIP(r),
+		ParentID:  stored.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store refresh token", err)
+		return
+	}
+	if err := qtx.SupersedeRefreshToken(r.Context(), stored.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store refresh token", err)
+		return
+	}
+
+	accessToken, err := cfg.makeAccessToken(user, stored.FamilyID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't refresh token", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// handlerRevoke logs out of the session the refresh token belongs to, which
+// revokes every token rotated from the same login.
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header.Get("Authorization"))
 	if err != nil {
@@ -52,11 +116,16 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = cfg.DB.RevokeRefreshToken(r.Context(), refreshToken)
+	revoked, err := cfg.DB.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
 
+	if err := cfg.DB.RevokeRefreshTokenFamily(r.Context(), revoked.FamilyID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+const (
+	maxReportDetailsLength = 500
+	defaultSuspensionDays  = 7
+	maxSuspensionDays      = 365
+)
+
+// reportReasonFlaggedTerm marks reports opened by the moderation filter.
+// Users can't pick it themselves.
+const reportReasonFlaggedTerm = "flagged_term"
+
+var reportReasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"sexual":         true,
+	"misinformation": true,
+	"other":          true,
+}
+
+const (
+	reportStatusOpen     = "open"
+	reportStatusResolved = "resolved"
+)
+
+// Decisions a moderator can take on a report, and separately on its author.
+const (
+	resolutionHide    = "hide"
+	resolutionDelete  = "delete"
+	resolutionDismiss = "dismiss"
+
+	authorActionWarn    = "warn"
+	authorActionSuspend = "suspend"
+)
+
+var ErrMissingReportID = errors.New("missing report ID")
+
+type ReportResponse struct {
+	ID         uuid.UUID     `json:"id"`
+	ChirpID    uuid.UUID     `json:"chirp_id"`
+	ReporterID uuid.NullUUID `json:"reporter_id"`
+	Reason     string        `json:"reason"`
+	Details    string        `json:"details"`
+	Status     string        `json:"status"`
+	Resolution string        `json:"resolution,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// QueuedReportResponse is a report as moderators see it, with the chirp it
+// is about.
+type QueuedReportResponse struct {
+	ReportResponse
+	Chirp ReportedChirpResponse `json:"chirp"`
+}
+
+type ReportedChirpResponse struct {
+	AuthorID uuid.NullUUID `json:"author_id"`
+	Body     string        `json:"body"`
+	Hidden   bool          `json:"hidden"`
+	Deleted  bool          `json:"deleted"`
+}
+
+type ReportPageResponse struct {
+	Reports    []QueuedReportResponse `json:"reports"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+func newReportResponse(report database.Report) ReportResponse {
+	response := ReportResponse{
+		ID:         report.ID,
+		ChirpID:    report.ChirpID,
+		ReporterID: report.ReporterID,
+		Reason:     report.Reason,
+		Details:    report.Details,
+		Status:     report.Status,
+		Resolution: report.Resolution.String,
+		CreatedAt:  report.CreatedAt,
+	}
+	if report.ResolvedAt.Valid {
+		response.ResolvedAt = &report.ResolvedAt.Time
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	if _, ok := cfg.requireActiveUser(w, r, userID); !ok {
+		return
+	}
+
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !reportReasons[params.Reason] {
+		respondWithError(w, http.StatusBadRequest, "Reason must be one of spam, harassment, hate, violence, sexual, misinformation or other", nil)
+		return
+	}
+	if len(params.Details) > maxReportDetailsLength {
+		respondWithError(w, http.StatusBadRequest, "Report details are too long", nil)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirpbyId(r.Context(), chirpID)
+	if err != nil || chirpRemoved(chirp) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if chirp.UserID.UUID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own chirp", nil)
+		return
+	}
+
+	report, err := cfg.DB.CreateReport(r.Context(), database.CreateReportParams{
+		ChirpID:    chirpID,
+		ReporterID: uuid.NullUUID{UUID: userID, Valid: true},
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if err != nil {
+		if isUniqueViolation(err, "reports_open_reporter_idx") {
+			respondWithError(w, http.StatusConflict, "You have already reported this chirp", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't report chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newReportResponse(report))
+}
+
+func (cfg *apiConfig) handlerGetReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = reportStatusOpen
+	}
+	if status != reportStatusOpen && status != reportStatusResolved {
+		respondWithError(w, http.StatusBadRequest, "Status must be open or resolved", nil)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	// Oldest reports first, so the queue is worked through in order
+	rows, err := cfg.DB.GetReportsPage(r.Context(), database.GetReportsPageParams{
+		Status:          status,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+		return
+	}
+
+	page := ReportPageResponse{Reports: []QueuedReportResponse{}}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[len(rows)-1].Report
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	for _, row := range rows {
+		page.Reports = append(page.Reports, QueuedReportResponse{
+			ReportResponse: newReportResponse(row.Report),
+			Chirp: ReportedChirpResponse{
+				AuthorID: row.AuthorID,
+				Body:     row.ChirpBody,
+				Hidden:   row.ChirpHiddenAt.Valid,
+				Deleted:  row.ChirpDeletedAt.Valid,
+			},
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// handlerResolveReport applies a moderator's decision to the reported chirp
+// and closes every open report on it. The author can be warned or suspended
+// in the same step. Each part of the decision goes into the audit trail.
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Resolution   string `json:"resolution"`
+		AuthorAction string `json:"author_action"`
+		SuspendDays  int    `json:"suspend_days"`
+		Note         string `json:"note"`
+	}
+
+	reportID, err := getReportIDFromPath(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	switch params.Resolution {
+	case resolutionHide, resolutionDelete, resolutionDismiss:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Resolution must be hide, delete or dismiss", nil)
+		return
+	}
+	switch params.AuthorAction {
+	case "", authorActionWarn:
+	case authorActionSuspend:
+		if params.SuspendDays == 0 {
+			params.SuspendDays = defaultSuspensionDays
+		}
+		if params.SuspendDays < 1 || params.SuspendDays > maxSuspensionDays {
+			respondWithError(w, http.StatusBadRequest, "Suspensions must last between 1 and 365 days", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "Author action must be warn or suspend", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve report", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	report, err := qtx.GetReportByID(r.Context(), reportID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Couldn't get report", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get report", err)
+		return
+	}
+
+	// Lock the chirp so two moderators can't resolve its reports at once
+	chirp, err := qtx.GetChirpbyIdForUpdate(r.Context(), report.ChirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	// Read the report again now that nobody else can be resolving it
+	report, err = qtx.GetReportByID(r.Context(), reportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get report", err)
+		return
+	}
+	if report.Status != reportStatusOpen {
+		respondWithError(w, http.StatusConflict, "Report is already resolved", nil)
+		return
+	}
+
+	resolved, err := qtx.ResolveChirpReports(r.Context(), database.ResolveChirpReportsParams{
+		ChirpID:    chirp.ID,
+		Resolution: sql.NullString{String: params.Resolution, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve report", err)
+		return
+	}
+	for _, resolvedReport := range resolved {
+		if resolvedReport.ID == reportID {
+			report = resolvedReport
+		}
+	}
+
+	_, err = qtx.CreateModerationAction(r.Context(), database.CreateModerationActionParams{
+		Action:      params.Resolution,
+		ModeratorID: staffUserID(r),
+		ReportID:    uuid.NullUUID{UUID: report.ID, Valid: true},
+		ChirpID:     uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		ChirpBody:   sql.NullString{String: chirp.Body, Valid: true},
+		UserID:      chirp.UserID,
+		Note:        params.Note,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record moderation action", err)
+		return
+	}
+
+	switch params.Resolution {
+	case resolutionHide:
+		if !chirp.HiddenAt.Valid {
+			if _, err := qtx.HideChirp(r.Context(), chirp.ID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't hide chirp", err)
+				return
+			}
+			// Streams treat hidden chirps as deleted
+			if err := publishChirpDeleted(r.Context(), qtx, chirp); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't hide chirp", err)
+				return
+			}
+		}
+	case resolutionDelete:
+		if !chirp.DeletedAt.Valid {
+			if err := moderatorDeleteChirp(r.Context(), qtx, chirp); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+				return
+			}
+		}
+	}
+
+	if params.AuthorAction != "" && chirp.UserID.Valid {
+		action := database.CreateModerationActionParams{
+			Action:      params.AuthorAction,
+			ModeratorID: staffUserID(r),
+			ReportID:    uuid.NullUUID{UUID: report.ID, Valid: true},
+			ChirpID:     uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			UserID:      chirp.UserID,
+			Note:        params.Note,
+		}
+		if params.AuthorAction == authorActionSuspend {
+			until := time.Now().UTC().AddDate(0, 0, params.SuspendDays)
+			action.SuspendedUntil = sql.NullTime{Time: until, Valid: true}
+
+			_, err := qtx.SetUserSuspendedUntil(r.Context(), database.SetUserSuspendedUntilParams{
+				ID:             chirp.UserID.UUID,
+				SuspendedUntil: action.SuspendedUntil,
+			})
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't suspend author", err)
+				return
+			}
+		}
+
+		if _, err := qtx.CreateModerationAction(r.Context(), action); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record moderation action", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newReportResponse(report))
+}
+
+func getReportIDFromPath(r *http.Request) (uuid.UUID, error) {
+	reportIDStr := r.PathValue("reportID")
+	if reportIDStr == "" {
+		return uuid.UUID{}, ErrMissingReportID
+	}
+	return uuid.Parse(reportIDStr)
+}
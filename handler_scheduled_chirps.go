@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/jobs"
+	"github.com/vanzei/goserver/internal/moderation"
+)
+
+var ErrMissingScheduledChirpID = errors.New("missing scheduled chirp ID")
+
+const (
+	// How many chirps a user can have waiting at once
+	maxScheduledChirps = 100
+	// How far ahead a chirp can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// How often due chirps are published
+	scheduledChirpPublishInterval = 15 * time.Second
+)
+
+// ScheduledChirpResponse describes a chirp waiting to be published. Failed
+// ones say why they couldn't be.
+type ScheduledChirpResponse struct {
+	ID            uuid.UUID     `json:"id"`
+	Body          string        `json:"body"`
+	UserID        uuid.UUID     `json:"user_id"`
+	InReplyTo     uuid.NullUUID `json:"in_reply_to"`
+	PublishAt     time.Time     `json:"publish_at"`
+	Status        string        `json:"status"`
+	FailureReason string        `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+func newScheduledChirpResponse(scheduled database.ScheduledChirp) ScheduledChirpResponse {
+	return ScheduledChirpResponse{
+		ID:            scheduled.ID,
+		Body:          scheduled.Body,
+		UserID:        scheduled.UserID,
+		InReplyTo:     scheduled.InReplyTo,
+		PublishAt:     scheduled.PublishAt,
+		Status:        scheduled.Status,
+		FailureReason: scheduled.FailureReason,
+		CreatedAt:     scheduled.CreatedAt,
+	}
+}
+
+// scheduleChirp stores a chirp to be published at publishAt. The caller has
+// already checked the user may schedule chirps and that body is acceptable.
+func (cfg *apiConfig) scheduleChirp(w http.ResponseWriter, r *http.Request, userID uuid.UUID, body string, inReplyTo uuid.NullUUID, publishAt time.Time) {
+	now := time.Now().UTC()
+	publishAt = publishAt.UTC()
+	if !publishAt.After(now) {
+		respondWithError(w, http.StatusBadRequest, "publish_at must be in the future", nil)
+		return
+	}
+	if publishAt.After(now.Add(maxScheduleAhead)) {
+		respondWithError(w, http.StatusBadRequest, "publish_at must be within a year", nil)
+		return
+	}
+
+	count, err := cfg.DB.CountScheduledChirps(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule chirp", err)
+		return
+	}
+	if count >= maxScheduledChirps {
+		respondWithError(w, http.StatusConflict, "Too many scheduled chirps", nil)
+		return
+	}
+
+	scheduled, err := cfg.DB.CreateScheduledChirp(r.Context(), database.CreateScheduledChirpParams{
+		UserID:    userID,
+		Body:      body,
+		InReplyTo: inReplyTo,
+		PublishAt: publishAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, newScheduledChirpResponse(scheduled))
+}
+
+func (cfg *apiConfig) handlerGetScheduledChirps(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	scheduled, err := cfg.DB.GetScheduledChirps(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled chirps", err)
+		return
+	}
+
+	response := make([]ScheduledChirpResponse, 0, len(scheduled))
+	for _, chirp := range scheduled {
+		response = append(response, newScheduledChirpResponse(chirp))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerDeleteScheduledChirp cancels a chirp that hasn't been published
+// yet, or clears one that failed.
+func (cfg *apiConfig) handlerDeleteScheduledChirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	scheduledIDStr := r.PathValue("scheduledChirpID")
+	if scheduledIDStr == "" {
+		respondWithError(w, http.StatusNotFound, "Scheduled chirp ID is required", ErrMissingScheduledChirpID)
+		return
+	}
+	scheduledID, err := uuid.Parse(scheduledIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scheduled chirp ID format", err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteScheduledChirp(r.Context(), database.DeleteScheduledChirpParams{
+		ID:     scheduledID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete scheduled chirp", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Scheduled chirp not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePublishScheduledChirpsJob publishes every chirp that has fallen
+// due.
+func (cfg *apiConfig) handlePublishScheduledChirpsJob(ctx context.Context, job jobs.Job) error {
+	for {
+		published, err := cfg.publishNextScheduledChirp(ctx)
+		if err != nil || !published {
+			return err
+		}
+	}
+}
+
+// publishNextScheduledChirp publishes the chirp that has been due the
+// longest, or marks it failed if it can no longer be published. It returns
+// false when nothing is due.
+func (cfg *apiConfig) publishNextScheduledChirp(ctx context.Context) (bool, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	scheduled, err := qtx.ClaimDueScheduledChirp(ctx, time.Now().UTC())
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	moderated, reason, err := cfg.checkScheduledChirp(ctx, qtx, scheduled)
+	if err != nil {
+		return false, err
+	}
+
+	if reason != "" {
+		err = qtx.FailScheduledChirp(ctx, database.FailScheduledChirpParams{
+			ID:            scheduled.ID,
+			FailureReason: reason,
+		})
+	} else {
+		_, err = createChirp(ctx, qtx, scheduled.UserID, scheduled.InReplyTo, moderated)
+		if err == nil {
+			err = qtx.DeletePublishedScheduledChirp(ctx, scheduled.ID)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// checkScheduledChirp repeats the checks made when the chirp was scheduled,
+// since the author, their plan, the chirp replied to or the moderation terms
+// may have changed since. It returns why the chirp can't be published, if it
+// can't.
+func (cfg *apiConfig) checkScheduledChirp(ctx context.Context, q *database.Queries, scheduled database.ScheduledChirp) (moderation.Result, string, error) {
+	user, err := q.GetUserByID(ctx, scheduled.UserID)
+	if err != nil {
+		return moderation.Result{}, "", err
+	}
+	if user.SuspendedUntil.Valid && user.SuspendedUntil.Time.After(time.Now().UTC()) {
+		return moderation.Result{}, "Account is suspended", nil
+	}
+
+	plan := entitlementsFor(user)
+	if !plan.allows(featureScheduleChirps) {
+		return moderation.Result{}, "Scheduling chirps requires Chirpy Red", nil
+	}
+	if len(scheduled.Body) > plan.maxChirpLength {
+		return moderation.Result{}, "Chirp is too long", nil
+	}
+
+	// in_reply_to has no foreign key, so a parent deleted since the chirp
+	// was scheduled shows up here rather than turning it into a new thread
+	if scheduled.InReplyTo.Valid {
+		parent, err := q.GetChirpbyId(ctx, scheduled.InReplyTo.UUID)
+		if err == sql.ErrNoRows || (err == nil && chirpRemoved(parent)) {
+			return moderation.Result{}, "Chirp being replied to doesn't exist", nil
+		}
+		if err != nil {
+			return moderation.Result{}, "", err
+		}
+	}
+
+	moderated := cfg.moderation.Check(scheduled.Body)
+	if moderated.Rejected {
+		return moderation.Result{}, "Chirp contains prohibited content", nil
+	}
+	return moderated, "", nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+const (
+	maxSearchQueryLength = 256
+	// Ranked results can't be paged by key, so deep offsets get expensive.
+	maxSearchOffset = 1000
+)
+
+// Private-use characters SearchChirps places around matched terms.
+const (
+	snippetMatchStart = "\ue000"
+	snippetMatchStop  = "\ue001"
+)
+
+type SearchResultResponse struct {
+	ChirpResponse
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchPageResponse struct {
+	Results    []SearchResultResponse `json:"results"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondWithError(w, http.StatusBadRequest, "Search query is required", nil)
+		return
+	}
+	if len(query) > maxSearchQueryLength {
+		respondWithError(w, http.StatusBadRequest, "Search query is too long", nil)
+		return
+	}
+
+	limit, offset, err := parseOffsetPageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+	if offset > maxSearchOffset {
+		respondWithError(w, http.StatusBadRequest, "Search results are too deep, refine your query", nil)
+		return
+	}
+
+	params := database.SearchChirpsParams{
+		Query:      query,
+		PageLimit:  int32(limit + 1),
+		PageOffset: int32(offset),
+	}
+
+	if authorIDStr := r.URL.Query().Get("author_id"); authorIDStr != "" {
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID format", err)
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+
+	params.Since, err = parseTimeParam(r, "since")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid since, expected an RFC 3339 timestamp", err)
+		return
+	}
+	params.Until, err = parseTimeParam(r, "until")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid until, expected an RFC 3339 timestamp", err)
+		return
+	}
+
+	rows, err := cfg.DB.SearchChirps(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	nextCursor := ""
+	if len(rows) > limit {
+		rows = rows[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+	}
+
+	chirps := make([]database.Chirp, 0, len(rows))
+	for _, row := range rows {
+		chirps = append(chirps, row.Chirp)
+	}
+	chirpResponses, err := cfg.chirpResponses(r.Context(), chirps, cfg.optionalUserIDFromRequest(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	results := make([]SearchResultResponse, 0, len(rows))
+	for i, row := range rows {
+		results = append(results, SearchResultResponse{
+			ChirpResponse: chirpResponses[i],
+			Rank:          row.Rank,
+			Snippet:       highlightSnippet(row.Snippet),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, SearchPageResponse{
+		Results:    results,
+		NextCursor: nextCursor,
+	})
+}
+
+// highlightSnippet HTML-escapes a headline produced by SearchChirps and turns
+// its match markers into <mark> tags.
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetMatchStart, "<mark>")
+	return strings.ReplaceAll(escaped, snippetMatchStop, "</mark>")
+}
+
+// parseTimeParam reads an optional RFC 3339 query parameter.
+func parseTimeParam(r *http.Request, name string) (sql.NullTime, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+var ErrMissingSessionID = errors.New("missing session ID")
+
+// SessionResponse describes one login, identified by its refresh token
+// family. IP and user agent are those of the last refresh.
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func (cfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.claimsFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	sessions, err := cfg.DB.GetActiveSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.FamilyID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IpAddress,
+			CreatedAt:  session.StartedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.FamilyID.String() == claims.SessionID,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	sessionIDStr := r.PathValue("sessionID")
+	if sessionIDStr == "" {
+		respondWithError(w, http.StatusNotFound, "Session ID is required", ErrMissingSessionID)
+		return
+	}
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID format", err)
+		return
+	}
+
+	revoked, err := cfg.DB.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerRevokeOtherSessions logs out everywhere except the session making
+// the request.
+func (cfg *apiConfig) handlerRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.claimsFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	err = cfg.DB.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+		UserID:       userID,
+		KeepFamilyID: sessionIDFromClaims(claims.SessionID),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionIDFromClaims parses the sid claim. Tokens without one don't belong
+// to any session.
+func sessionIDFromClaims(sid string) uuid.NullUUID {
+	sessionID, err := uuid.Parse(sid)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: sessionID, Valid: true}
+}
+
+// clientIP returns the address of the client making the request. Behind a
+// reverse proxy, set TRUST_PROXY=true to read it from X-Forwarded-For.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/entities"
+	"github.com/vanzei/goserver/internal/jobs"
+	"github.com/vanzei/goserver/internal/stream"
+	"github.com/vanzei/goserver/internal/websocket"
+)
+
+// Events pushed by GET /api/stream
+const (
+	streamEventChirpCreated = "chirp.created"
+	streamEventChirpEdited  = "chirp.edited"
+	streamEventChirpDeleted = "chirp.deleted"
+	// Sent instead of a replay when the missed events are gone; the client
+	// should reload with GET /api/chirps
+	streamEventReset = "reset"
+)
+
+const (
+	// How often idle streams send a keepalive
+	streamHeartbeatInterval = 30 * time.Second
+	// A client that doesn't take a write within this is dropped
+	streamWriteTimeout = 10 * time.Second
+	// How long SSE clients wait before reconnecting, in milliseconds
+	streamRetryMillis = 3000
+	// Events are kept this long for clients to resume from
+	streamEventRetention = 24 * time.Hour
+	// How often old events are deleted
+	streamPruneInterval = time.Hour
+	// WebSocket clients only send control frames
+	streamReadLimit = 1024
+)
+
+// StreamChirpResponse is a chirp as pushed by GET /api/stream. Counters
+// change too often to push, so they're left out.
+type StreamChirpResponse struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	InReplyTo uuid.NullUUID `json:"in_reply_to"`
+	Entities  ChirpEntities `json:"entities"`
+}
+
+type StreamChirpDeletedResponse struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// StreamMessage is one WebSocket message. SSE sends the same fields as the
+// event's id, event and data lines.
+type StreamMessage struct {
+	ID   int64           `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// publishChirpEvent pushes a new or edited chirp to streams. Call it with
+// the queries of the transaction making the change, last before commit.
+func publishChirpEvent(ctx context.Context, q *database.Queries, eventType string, chirp database.Chirp) error {
+	chirpEntities := newChirpEntities(chirp.Body)
+	mentions, err := q.GetMentionsForChirps(ctx, []uuid.UUID{chirp.ID})
+	if err != nil {
+		return err
+	}
+	for _, row := range mentions {
+		chirpEntities.Mentions = append(chirpEntities.Mentions, MentionEntity{
+			UserID:   row.UserID,
+			Username: row.Username.String,
+			Start:    int(row.StartOffset),
+			End:      int(row.EndOffset),
+		})
+	}
+
+	data, err := json.Marshal(StreamChirpResponse{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID.UUID,
+		InReplyTo: chirp.InReplyTo,
+		Entities:  chirpEntities,
+	})
+	if err != nil {
+		return err
+	}
+	return publishStreamEvent(ctx, q, eventType, chirp, data)
+}
+
+// publishChirpDeleted tells streams a chirp was deleted or hidden, and
+// redacts its earlier events so they can't be replayed.
+func publishChirpDeleted(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	if err := stream.Redact(ctx, q, chirp.ID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(StreamChirpDeletedResponse{
+		ID:     chirp.ID,
+		UserID: chirp.UserID.UUID,
+	})
+	if err != nil {
+		return err
+	}
+	return publishStreamEvent(ctx, q, streamEventChirpDeleted, chirp, data)
+}
+
+func publishStreamEvent(ctx context.Context, q *database.Queries, eventType string, chirp database.Chirp, data []byte) error {
+	// Hashtags of the body as it was, so hashtag streams hear of deletions
+	var hashtags []string
+	for _, hashtag := range entities.Hashtags(chirp.Body) {
+		hashtags = append(hashtags, hashtag.Text)
+	}
+	return stream.Publish(ctx, q, stream.Event{
+		Type:     eventType,
+		ChirpID:  chirp.ID,
+		UserID:   chirp.UserID.UUID,
+		Hashtags: hashtags,
+		Data:     data,
+	})
+}
+
+// handlerStream pushes chirp changes as they happen, over Server-Sent Events
+// or, if the request asks to upgrade, a WebSocket.
+func (cfg *apiConfig) handlerStream(w http.ResponseWriter, r *http.Request) {
+	filter, ok := cfg.streamFilterFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects; WebSocket clients
+	// can't set headers, so it can also go in the query
+	lastEventIDStr := r.Header.Get("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = r.URL.Query().Get("last_event_id")
+	}
+
+	// Subscribe before replaying so nothing falls in between; events in
+	// both are skipped the second time
+	sub := cfg.stream.Subscribe(filter)
+	defer sub.Close()
+
+	var replay []stream.Event
+	reset := false
+	if lastEventIDStr != "" {
+		lastEventID, err := strconv.ParseInt(lastEventIDStr, 10, 64)
+		if err != nil || lastEventID < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid last event ID", err)
+			return
+		}
+		replay, err = cfg.stream.Replay(r.Context(), filter, lastEventID)
+		if errors.Is(err, stream.ErrMissedEvents) {
+			reset = true
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't replay events", err)
+			return
+		}
+	}
+
+	if websocket.IsUpgrade(r) {
+		serveWebSocketStream(w, r, sub, replay, reset)
+		return
+	}
+	serveSSEStream(w, r, sub, replay, reset)
+}
+
+// streamFilterFromRequest reads the filters of a stream: author_id for one
+// user's chirps, following=true for the caller's and the users they follow,
+// and hashtag. A hashtag can be combined with either of the others.
+func (cfg *apiConfig) streamFilterFromRequest(w http.ResponseWriter, r *http.Request) (stream.Filter, bool) {
+	query := r.URL.Query()
+	filter := stream.Filter{
+		// Tags are stored lowercase and without the leading #
+		Hashtag: strings.ToLower(strings.TrimPrefix(query.Get("hashtag"), "#")),
+	}
+
+	authorIDStr := query.Get("author_id")
+	following := query.Get("following") == "true"
+	if authorIDStr != "" && following {
+		respondWithError(w, http.StatusBadRequest, "Use either author_id or following, not both", nil)
+		return stream.Filter{}, false
+	}
+
+	if authorIDStr != "" {
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID format", err)
+			return stream.Filter{}, false
+		}
+		filter.Authors = map[uuid.UUID]bool{authorID: true}
+	}
+
+	if following {
+		userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsRead)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return stream.Filter{}, false
+		}
+		followeeIDs, err := cfg.DB.GetFolloweeIDs(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get followed users", err)
+			return stream.Filter{}, false
+		}
+		// Like the timeline, the caller's own chirps are included
+		filter.Authors = map[uuid.UUID]bool{userID: true}
+		for _, id := range followeeIDs {
+			filter.Authors[id] = true
+		}
+	}
+
+	return filter, true
+}
+
+// streamWriter sends events over one of the stream transports.
+type streamWriter interface {
+	writeEvent(e stream.Event) error
+	writeReset() error
+	writeHeartbeat() error
+}
+
+// runStream sends the replayed events and then live ones until ctx is done
+// or the subscription ends. Live events already replayed are skipped.
+func runStream(ctx context.Context, sw streamWriter, sub *stream.Subscription, replay []stream.Event, reset bool) error {
+	if reset {
+		if err := sw.writeReset(); err != nil {
+			return err
+		}
+	}
+
+	var lastID int64
+	for _, e := range replay {
+		if err := sw.writeEvent(e); err != nil {
+			return err
+		}
+		lastID = e.ID
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.Done():
+			return sub.Err()
+		case e := <-sub.Events():
+			if e.ID <= lastID {
+				continue
+			}
+			if err := sw.writeEvent(e); err != nil {
+				return err
+			}
+			lastID = e.ID
+		case <-heartbeat.C:
+			if err := sw.writeHeartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sseWriter writes Server-Sent Events.
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s sseWriter) write(format string, args ...any) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Event data is JSON without newlines, so it fits on one data line
+func (s sseWriter) writeEvent(e stream.Event) error {
+	return s.write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+func (s sseWriter) writeReset() error {
+	return s.write("event: %s\ndata: {}\n\n", streamEventReset)
+}
+
+func (s sseWriter) writeHeartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func serveSSEStream(w http.ResponseWriter, r *http.Request, sub *stream.Subscription, replay []stream.Event, reset bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	sw := sseWriter{w: w, rc: http.NewResponseController(w)}
+	if err := sw.write("retry: %d\n\n", streamRetryMillis); err != nil {
+		return
+	}
+
+	// A dropped stream just ends; the client reconnects with Last-Event-ID
+	err := runStream(r.Context(), sw, sub, replay, reset)
+	if err != nil && !errors.Is(err, stream.ErrSlowConsumer) && !errors.Is(err, stream.ErrClosed) {
+		log.Printf("Stream ended: %v", err)
+	}
+}
+
+// webSocketWriter writes StreamMessages as text messages.
+type webSocketWriter struct {
+	conn *websocket.Conn
+}
+
+func (s webSocketWriter) write(msg StreamMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	return s.conn.WriteText(data)
+}
+
+func (s webSocketWriter) writeEvent(e stream.Event) error {
+	return s.write(StreamMessage{ID: e.ID, Type: e.Type, Data: e.Data})
+}
+
+func (s webSocketWriter) writeReset() error {
+	return s.write(StreamMessage{Type: streamEventReset, Data: json.RawMessage("{}")})
+}
+
+func (s webSocketWriter) writeHeartbeat() error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	return s.conn.Ping()
+}
+
+func serveWebSocketStream(w http.ResponseWriter, r *http.Request, sub *stream.Subscription, replay []stream.Event, reset bool) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(streamReadLimit)
+
+	// The request's context isn't cancelled when a hijacked connection
+	// drops, so watch for the client leaving by reading from it. Clients
+	// aren't expected to send anything but control frames.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = runStream(ctx, webSocketWriter{conn: conn}, sub, replay, reset)
+	switch {
+	case err == nil:
+		conn.Close(websocket.CloseNormal, "")
+	case errors.Is(err, stream.ErrSlowConsumer):
+		conn.Close(websocket.CloseTryAgainLater, "Fell behind, reconnect with last_event_id")
+	case errors.Is(err, stream.ErrClosed):
+		conn.Close(websocket.CloseGoingAway, "Server is shutting down")
+	default:
+		conn.Close(websocket.CloseInternalError, "")
+	}
+}
+
+// handlePruneStreamEventsJob deletes events too old to resume from.
+func (cfg *apiConfig) handlePruneStreamEventsJob(ctx context.Context, job jobs.Job) error {
+	_, err := cfg.DB.DeleteStreamEventsBefore(ctx, time.Now().UTC().Add(-streamEventRetention))
+	return err
+}
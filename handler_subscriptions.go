@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/jobs"
+)
+
+// Polka events that change a Chirpy Red subscription
+const (
+	polkaEventUpgraded      = "user.upgraded"
+	polkaEventRenewed       = "user.renewed"
+	polkaEventPaymentFailed = "user.payment_failed"
+	polkaEventCanceled      = "user.canceled"
+	polkaEventDowngraded    = "user.downgraded"
+)
+
+const (
+	subscriptionActive   = "active"
+	subscriptionPastDue  = "past_due"
+	subscriptionCanceled = "canceled"
+	subscriptionExpired  = "expired"
+)
+
+const (
+	// Used when Polka doesn't say when the paid period ends
+	subscriptionPeriod = 30 * 24 * time.Hour
+	// How long users keep Chirpy Red after their period ends or a payment
+	// fails, while Polka retries
+	subscriptionGracePeriod = 7 * 24 * time.Hour
+	// How often lapsed subscriptions are expired
+	subscriptionExpiryInterval = time.Minute
+)
+
+var ErrUnknownSubscriptionEvent = errors.New("unknown subscription event")
+
+func isSubscriptionEvent(event string) bool {
+	switch event {
+	case polkaEventUpgraded, polkaEventRenewed, polkaEventPaymentFailed, polkaEventCanceled, polkaEventDowngraded:
+		return true
+	}
+	return false
+}
+
+// SubscriptionResponse describes a user's Chirpy Red subscription. Users
+// who never subscribed get the free plan with status "none".
+type SubscriptionResponse struct {
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	IsChirpyRed      bool       `json:"is_chirpy_red"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end,omitempty"`
+	AccessUntil      *time.Time `json:"access_until,omitempty"`
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+}
+
+func (cfg *apiConfig) handlerGetSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return
+	}
+
+	response := SubscriptionResponse{
+		Plan:        entitlementsFor(user).plan,
+		Status:      "none",
+		IsChirpyRed: user.IsChirpyRed,
+	}
+
+	subscription, err := cfg.DB.GetSubscription(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get subscription", err)
+		return
+	}
+	if err == nil {
+		response.Status = subscription.Status
+		response.CurrentPeriodEnd = &subscription.CurrentPeriodEnd
+		response.AccessUntil = &subscription.AccessUntil
+		response.UpdatedAt = &subscription.UpdatedAt
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// applySubscriptionEvent records a Polka event against a user's
+// subscription and sets is_chirpy_red to match. periodEnd is the end of the
+// paid period if Polka sent one. Users who gain Chirpy Red trigger the
+// user.upgraded webhook. It returns sql.ErrNoRows for unknown users
+// and ErrUnknownSubscriptionEvent for events it doesn't handle.
+func applySubscriptionEvent(ctx context.Context, q *database.Queries, userID uuid.UUID, event string, periodEnd time.Time) error {
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	current, err := q.GetSubscriptionForUpdate(ctx, userID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	now := time.Now().UTC()
+	next := database.UpsertSubscriptionParams{
+		UserID:           userID,
+		Status:           current.Status,
+		CurrentPeriodEnd: current.CurrentPeriodEnd,
+		AccessUntil:      current.AccessUntil,
+	}
+
+	switch event {
+	case polkaEventUpgraded, polkaEventRenewed:
+		if periodEnd.IsZero() {
+			// Renewals extend the period that's running
+			start := now
+			if event == polkaEventRenewed && exists && current.CurrentPeriodEnd.After(now) {
+				start = current.CurrentPeriodEnd
+			}
+			periodEnd = start.Add(subscriptionPeriod)
+		}
+		next.Status = subscriptionActive
+		next.CurrentPeriodEnd = periodEnd.UTC()
+		next.AccessUntil = next.CurrentPeriodEnd.Add(subscriptionGracePeriod)
+	case polkaEventPaymentFailed:
+		// Nothing to do for users who aren't subscribed
+		if !exists || current.Status == subscriptionExpired {
+			return nil
+		}
+		next.Status = subscriptionPastDue
+		next.AccessUntil = latest(current.CurrentPeriodEnd, now).Add(subscriptionGracePeriod)
+	case polkaEventCanceled:
+		if !exists || current.Status == subscriptionExpired {
+			return nil
+		}
+		// Canceled subscriptions run to the end of the paid period, with
+		// no grace period
+		next.Status = subscriptionCanceled
+		next.AccessUntil = current.CurrentPeriodEnd
+	case polkaEventDowngraded:
+		next.Status = subscriptionExpired
+		if !exists {
+			next.CurrentPeriodEnd = now
+		}
+		next.AccessUntil = now
+	default:
+		return ErrUnknownSubscriptionEvent
+	}
+
+	if _, err := q.UpsertSubscription(ctx, next); err != nil {
+		return err
+	}
+
+	// Lapsed subscriptions are switched off by the expiry job, so users
+	// keep Chirpy Red until access_until
+	red := next.Status != subscriptionExpired
+	updated, err := q.UpdateUserChirpyRed(ctx, database.UpdateUserChirpyRedParams{
+		ID:          userID,
+		IsChirpyRed: red,
+	})
+	if err != nil {
+		return err
+	}
+
+	if red && !user.IsChirpyRed {
+		return enqueueWebhookEvent(ctx, q, webhookEventUserUpgraded, newWebhookUserData(updated))
+	}
+	return nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// handleExpireSubscriptionsJob takes Chirpy Red away from users whose
+// subscription has run out.
+func (cfg *apiConfig) handleExpireSubscriptionsJob(ctx context.Context, job jobs.Job) error {
+	expired, err := cfg.DB.ExpireSubscriptions(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, userID := range expired {
+		log.Printf("Chirpy Red expired for user %s", userID)
+	}
+	return nil
+}
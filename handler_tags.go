@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vanzei/goserver/internal/database"
+)
+
+func (cfg *apiConfig) handlerGetTagChirps(w http.ResponseWriter, r *http.Request) {
+	// Tags are stored lowercase and without the leading #
+	tag := strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	if tag == "" {
+		respondWithError(w, http.StatusNotFound, "Tag is required", nil)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpsByHashtagPage(r.Context(), database.GetChirpsByHashtagPageParams{
+		Tag:             tag,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		return
+	}
+
+	page, err := cfg.feedPage(r.Context(), chirpFeedItems(chirps), limit, cfg.optionalUserIDFromRequest(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+func (cfg *apiConfig) handlerGetUserMentions(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromPath(r)
+	if err != nil {
+		respondWithUserIDError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	chirps, err := cfg.DB.GetMentionsPage(r.Context(), database.GetMentionsPageParams{
+		UserID:          userID,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get mentions", err)
+		return
+	}
+
+	page, err := cfg.feedPage(r.Context(), chirpFeedItems(chirps), limit, cfg.optionalUserIDFromRequest(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get mentions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// maxThreadSize caps how many chirps a single thread response may contain.
+const maxThreadSize = 1000
+
+type ThreadNode struct {
+	ChirpResponse
+	Deleted bool          `json:"deleted,omitempty"`
+	Hidden  bool          `json:"hidden,omitempty"`
+	Replies []*ThreadNode `json:"replies"`
+}
+
+func (cfg *apiConfig) handlerGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := getChirpIDFromPath(r)
+	if err != nil {
+		respondWithChirpIDError(w, err)
+		return
+	}
+
+	// Any chirp in a conversation shows the whole conversation
+	rootID, err := cfg.DB.GetThreadRootID(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpThread(r.Context(), database.GetChirpThreadParams{
+		RootID:    rootID,
+		MaxChirps: maxThreadSize,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+
+	chirpResponses, err := cfg.chirpResponses(r.Context(), chirps, cfg.optionalUserIDFromRequest(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread", err)
+		return
+	}
+
+	root := buildThread(rootID, chirps, chirpResponses)
+	if root == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, root)
+}
+
+// buildThread nests chirps under the chirp they reply to. Chirps must be in
+// creation order so that every parent is seen before its replies, which also
+// keeps each list of replies in creation order.
+func buildThread(rootID uuid.UUID, chirps []database.Chirp, chirpResponses []ChirpResponse) *ThreadNode {
+	nodes := make(map[uuid.UUID]*ThreadNode, len(chirps))
+	for i, chirp := range chirps {
+		node := &ThreadNode{
+			ChirpResponse: chirpResponses[i],
+			Deleted:       chirp.DeletedAt.Valid,
+			Hidden:        chirp.HiddenAt.Valid,
+			Replies:       []*ThreadNode{},
+		}
+		// Hidden chirps keep their body for moderators but not for readers
+		if node.Hidden {
+			node.Body = ""
+			node.Entities = newChirpEntities("")
+		}
+		nodes[chirp.ID] = node
+
+		if chirp.ID == rootID {
+			continue
+		}
+		if parent, ok := nodes[chirp.InReplyTo.UUID]; ok {
+			parent.Replies = append(parent.Replies, node)
+		}
+	}
+	return nodes[rootID]
+}
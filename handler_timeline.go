@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// handlerGetTimeline returns the caller's home feed: chirps and rechirps by
+// the caller and everyone they follow, newest first.
+func (cfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.validateJWTFromRequest(r, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	rows, err := cfg.DB.GetTimelinePage(r.Context(), database.GetTimelinePageParams{
+		UserID:          userID,
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline", err)
+		return
+	}
+
+	items := make([]feedItem, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, feedItem(row))
+	}
+
+	page, err := cfg.feedPage(r.Context(), items, limit, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
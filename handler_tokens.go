@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+const (
+	defaultTokenLifetimeDays = 30
+	maxTokenLifetimeDays     = 365
+	maxTokenNameLength       = 100
+	// How much of a token is kept in the clear to tell tokens apart
+	tokenPrefixLength = len(auth.PersonalAccessTokenPrefix) + 8
+)
+
+var ErrMissingTokenID = errors.New("missing token ID")
+
+// PersonalAccessTokenResponse describes a personal access token. The token
+// itself is only included in the response that creates it.
+type PersonalAccessTokenResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Token       string     `json:"token,omitempty"`
+	TokenPrefix string     `json:"token_prefix"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newPersonalAccessTokenResponse(pat database.PersonalAccessToken) PersonalAccessTokenResponse {
+	response := PersonalAccessTokenResponse{
+		ID:          pat.ID,
+		Name:        pat.Name,
+		TokenPrefix: pat.TokenPrefix,
+		Scopes:      pat.Scopes,
+		ExpiresAt:   pat.ExpiresAt,
+		CreatedAt:   pat.CreatedAt,
+	}
+	if pat.LastUsedAt.Valid {
+		response.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlerCreateToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, http.StatusBadRequest, "Token name is required and must be at most 100 characters", nil)
+		return
+	}
+
+	scopes, ok := parseScopes(params.Scopes)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Scopes must be one or more of chirps:read, chirps:write, profile:write", nil)
+		return
+	}
+
+	days := defaultTokenLifetimeDays
+	if params.ExpiresInDays != nil {
+		days = *params.ExpiresInDays
+	}
+	if days < 1 || days > maxTokenLifetimeDays {
+		respondWithError(w, http.StatusBadRequest, "Tokens must expire in 1 to 365 days", nil)
+		return
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+
+	pat, err := cfg.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:      userID,
+		Name:        params.Name,
+		TokenHash:   auth.HashPersonalAccessToken(token),
+		TokenPrefix: token[:tokenPrefixLength],
+		Scopes:      scopes,
+		ExpiresAt:   time.Now().UTC().AddDate(0, 0, days),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+
+	response := newPersonalAccessTokenResponse(pat)
+	response.Token = token
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerGetTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tokens, err := cfg.DB.GetPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tokens", err)
+		return
+	}
+
+	response := make([]PersonalAccessTokenResponse, 0, len(tokens))
+	for _, pat := range tokens {
+		response = append(response, newPersonalAccessTokenResponse(pat))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerDeleteToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tokenIDStr := r.PathValue("tokenID")
+	if tokenIDStr == "" {
+		respondWithError(w, http.StatusNotFound, "Token ID is required", ErrMissingTokenID)
+		return
+	}
+	tokenID, err := uuid.Parse(tokenIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID format", err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeletePersonalAccessToken(r.Context(), database.DeletePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete token", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Token not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseScopes validates requested scopes and drops duplicates.
+func parseScopes(requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return nil, false
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !auth.ValidScope(scope) {
+			return nil, false
+		}
+		if !auth.HasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, true
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+const (
+	totpIssuer = "Chirpy"
+	// Recovery codes handed out when two-factor authentication is enabled
+	recoveryCodeCount = 10
+	// Wrong codes allowed per MFA challenge before the user has to log in
+	// with their password again
+	maxMFAAttempts = 5
+)
+
+// MFAChallengeResponse is returned by a password login when the user has
+// two-factor authentication enabled. The token is exchanged for access and
+// refresh tokens at /api/login/mfa along with a code.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+
+	// Enrolling again before verifying replaces the pending secret
+	user, err := cfg.DB.SetPendingTOTPSecret(r.Context(), database.SetPendingTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start enrollment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+func (cfg *apiConfig) handlerVerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	ok, err := checkTOTPCode(r.Context(), qtx, user, params.Code)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	if _, err := qtx.EnableTOTP(r.Context(), userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), qtx, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerDisableTOTP turns two-factor authentication off. It takes the
+// password as well as a code, so a stolen access token isn't enough.
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userID, err := cfg.sessionUserIDFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+	if !auth.CheckPasswordHash(params.Password, user.HashedPassword) {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", nil)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	ok, err := checkSecondFactor(r.Context(), qtx, user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	if err := qtx.DisableTOTP(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	if err := qtx.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete recovery codes", err)
+		return
+	}
+	if err := qtx.DeleteUserMFAChallenges(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete MFA challenges", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginMFA finishes a login started with a password by checking a
+// TOTP code or a recovery code against the MFA challenge.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	challenge, err := cfg.DB.GetMFAChallengeByHash(r.Context(), auth.HashToken(params.MFAToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get MFA challenge", err)
+		return
+	}
+
+	// As with passwords, the account stays locked while the code is checked
+	// so parallel guesses can't get past the lockout
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	user, err := qtx.GetUserByIDForLogin(r.Context(), challenge.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	userID := uuid.NullUUID{UUID: user.ID, Valid: true}
+
+	// Wrong codes count as failed logins, so new challenges don't give an
+	// attacker with the password unlimited guesses
+	if retryAfter := accountLoginRetryAfter(user); retryAfter > 0 {
+		cfg.recordLoginEvent(r, userID, user.Email, loginThrottled)
+		respondWithLoginThrottled(w, retryAfter)
+		return
+	}
+
+	ok, err := checkSecondFactor(r.Context(), qtx, user, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		cfg.recordLoginEvent(r, userID, user.Email, loginBadMFACode)
+		if err := recordFailedLogin(r.Context(), qtx, user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		attempts, err := qtx.RecordMFAChallengeAttempt(r.Context(), challenge.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record attempt", err)
+			return
+		}
+		if attempts >= maxMFAAttempts {
+			if _, err := qtx.DeleteMFAChallenge(r.Context(), challenge.ID); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't delete MFA challenge", err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	// Deleting the challenge is what claims it, so it can't be used twice
+	deleted, err := qtx.DeleteMFAChallenge(r.Context(), challenge.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete MFA challenge", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token", nil)
+		return
+	}
+
+	if _, err := qtx.ResetFailedLogins(r.Context(), user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finish login", err)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+// respondWithMFAChallenge starts the second step of a login for a user with
+// two-factor authentication.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, user database.User) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate MFA token", err)
+		return
+	}
+
+	if err := cfg.DB.DeleteExpiredMFAChallenges(r.Context(), user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete MFA challenges", err)
+		return
+	}
+
+	challenge, err := cfg.DB.CreateMFAChallenge(r.Context(), database.CreateMFAChallengeParams{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   challenge.ExpiresAt,
+	})
+}
+
+// checkSecondFactor reports whether code is a valid TOTP code, or
+// recoveryCode an unused recovery code, for user. Either is used up by a
+// successful check.
+func checkSecondFactor(ctx context.Context, q *database.Queries, user database.User, code, recoveryCode string) (bool, error) {
+	if !user.TotpEnabledAt.Valid {
+		return false, nil
+	}
+
+	switch {
+	case code != "":
+		return checkTOTPCode(ctx, q, user, code)
+	case recoveryCode != "":
+		used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		return used == 1, err
+	default:
+		return false, nil
+	}
+}
+
+// checkTOTPCode reports whether code is valid for user's secret and hasn't
+// been accepted before.
+func checkTOTPCode(ctx context.Context, q *database.Queries, user database.User, code string) (bool, error) {
+	step, ok := auth.ValidateTOTPCode(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		ID:   user.ID,
+		Step: sql.NullInt64{Int64: step, Valid: true},
+	})
+	return used == 1, err
+}
+
+// replaceRecoveryCodes generates a fresh set of recovery codes for a user,
+// discarding any old ones. Only hashes are stored, so this is the only time
+// the codes are available.
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err := q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
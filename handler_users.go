@@ -1,14 +1,19 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
 	"time"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/vanzei/goserver/internal/auth"
     "github.com/vanzei/goserver/internal/database" 
+	"github.com/vanzei/goserver/internal/entities"
 )
 
 type User struct {
@@ -25,8 +30,26 @@ type UserResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Email     string    `json:"email"`
+	Username  string    `json:"username,omitempty"`
 	Token	 string    `json:"token,omitempty"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role      string    `json:"role"`
+	EmailVerified bool `json:"email_verified"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+}
+
+func newUserResponse(user database.User) UserResponse {
+	return UserResponse{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		Username:    user.Username.String,
+		IsChirpyRed: user.IsChirpyRed,
+		Role:        user.Role,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		TwoFactorEnabled: user.TotpEnabledAt.Valid,
+	}
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +57,7 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 	var req struct {
 		Email string `json:"email"`
 		Password string `json:"password"`
+		Username string `json:"username"`
 	}
 
 	type response struct {
@@ -45,15 +69,19 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	username, ok := parseUsername(req.Username)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid username", nil)
+		return
+	}
+
 	// Validate the email format
 	if !isValidEmail(req.Email) {
 		respondWithError(w, http.StatusBadRequest, "Invalid email format", nil)
 		return
 	}
 
-	// Validate the email format
-	if !isValidPassword(req.Password) {
-		respondWithError(w, http.StatusBadRequest, "Invalid password", nil)
+	if !validatePasswordPolicy(w, req.Password, req.Email, username.String) {
 		return
 	}
 	// Hash the password
@@ -65,115 +93,326 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 
 	
 
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
 	// Create a new user in the database
-	user, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
+	user, err := qtx.CreateUser(r.Context(), database.CreateUserParams{
         Email:          req.Email,
         HashedPassword: hashedPassword,
+        Username:       username,
     })
 	if err != nil {
+		if isUniqueViolation(err, "users_username_key") {
+			respondWithError(w, http.StatusConflict, "Username is already taken", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+		return
+	}
+
+	// New accounts are limited until the address is verified
+	if err := mailVerificationToken(r.Context(), qtx, user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	if err := enqueueWebhookEvent(r.Context(), qtx, webhookEventUserCreated, newWebhookUserData(user)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusCreated, response{
-		UserResponse: UserResponse{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		UserResponse: newUserResponse(user),
 	})
 }
 
 
 
+// userUpdate lists the account fields to change; nil fields are left alone.
+type userUpdate struct {
+	Email           *string `json:"email"`
+	Username        *string `json:"username"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+// handlerModifyUser applies a partial update to the caller's account. A new
+// password or email address needs the current password, and a new email
+// address only takes over once it has been confirmed.
 func (cfg *apiConfig) handlerModifyUser(w http.ResponseWriter, r *http.Request) {
-    // Get token from Authorization header instead of body
-    authHeader := r.Header.Get("Authorization")
-    tokenString, err := auth.GetBearerToken(authHeader)
-    if err != nil {
-        respondWithError(w, http.StatusUnauthorized, "Invalid authorization header", err)
-        return
-    }
-    
-    // Validate the token
-    userID, err := auth.ValidateJWT(tokenString, cfg.secret)
-    if err != nil {
-        respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
-        return
-    }
-    
-    // Parse the request body (without token field)
-    var req struct {
-        Email    string `json:"email"`
-        Password string `json:"password"`
-    }
-    
-    type response struct {
+	userID, claims, err := cfg.authenticateRequest(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var req userUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	current, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	cfg.updateUser(w, r, current, claims, req)
+}
+
+// handlerReplaceUser keeps the old PUT /api/users working for existing
+// clients: email and password are required on every request, and sending
+// the current ones changes nothing. Actual changes follow the same rules as
+// PATCH.
+func (cfg *apiConfig) handlerReplaceUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+
+	userID, claims, err := cfg.authenticateRequest(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var req userUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if req.Email == nil || req.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
+		return
+	}
+	// An empty username leaves the current one unchanged, as it always has
+	if req.Username != nil && *req.Username == "" {
+		req.Username = nil
+	}
+
+	current, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if auth.CheckPasswordHash(*req.Password, current.HashedPassword) {
+		req.Password = nil
+	}
+
+	cfg.updateUser(w, r, current, claims, req)
+}
+
+// updateUser applies req to the account of current, who the caller was
+// authenticated as. claims are nil for personal access tokens.
+func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request, current database.User, claims *auth.Claims, req userUpdate) {
+	type response struct {
 		UserResponse
+		PendingEmail string `json:"pending_email,omitempty"`
 	}
 
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
-        return
-    }
+	userID := current.ID
 
-    // Validate the email format
-    if !isValidEmail(req.Email) {
-        respondWithError(w, http.StatusBadRequest, "Invalid email format", nil)
-        return
-    }
+	// Personal access tokens have no claims and no session to keep
+	var sessionID uuid.NullUUID
+	if claims != nil {
+		sessionID = sessionIDFromClaims(claims.SessionID)
+	}
 
-    // Validate the email format
-    if !isValidPassword(req.Password) {
-        respondWithError(w, http.StatusBadRequest, "Invalid password", nil)
-        return
-    }
+	changePassword := req.Password != nil
+	changeEmail := req.Email != nil && *req.Email != current.Email
 
-    // Hash the password
-	hashedPassword, err := auth.HashPassword(req.Password)
-	if err != nil {		
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+	if changePassword || changeEmail {
+		// A leaked personal access token mustn't be enough to take over the account
+		if claims == nil {
+			respondWithError(w, http.StatusForbidden, "Email and password can only be changed after logging in", nil)
+			return
+		}
+		if !auth.CheckPasswordHash(req.CurrentPassword, current.HashedPassword) {
+			respondWithError(w, http.StatusForbidden, "Current password is incorrect", nil)
+			return
+		}
+	}
+
+	username := sql.NullString{}
+	if req.Username != nil {
+		var ok bool
+		username, ok = parseUsername(*req.Username)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Invalid username", nil)
+			return
+		}
+	}
+
+	hashedPassword := sql.NullString{}
+	if changePassword {
+		newUsername := current.Username.String
+		if username.Valid {
+			newUsername = username.String
+		}
+		if !validatePasswordPolicy(w, *req.Password, current.Email, newUsername) {
+			return
+		}
+		if auth.CheckPasswordHash(*req.Password, current.HashedPassword) {
+			respondWithError(w, http.StatusBadRequest, "New password must be different from the current one", nil)
+			return
+		}
+
+		hash, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		hashedPassword = sql.NullString{String: hash, Valid: true}
+	}
+
+	if changeEmail {
+		if !isValidEmail(*req.Email) {
+			respondWithError(w, http.StatusBadRequest, "Invalid email format", nil)
+			return
+		}
+		if _, err := cfg.DB.GetUserByEmail(r.Context(), *req.Email); err == nil {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+			return
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
-	// Update the user in the database
-	user, err := cfg.DB.UpdateUser(r.Context(), database.UpdateUserParams{
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	user, err := qtx.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
 		ID:             userID,
-		Email:          req.Email,
+		Username:       username,
 		HashedPassword: hashedPassword,
 	})
 	if err != nil {
+		if isUniqueViolation(err, "users_username_key") {
+			respondWithError(w, http.StatusConflict, "Username is already taken", nil)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, response{
-		UserResponse: UserResponse{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
-	})
 
-	
+	// A new password logs out every other device, in case the old one leaked
+	if changePassword {
+		err := qtx.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+			UserID:       userID,
+			KeepFamilyID: sessionID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+
+	// The current address stays in use until the new one is confirmed
+	if changeEmail {
+		if err := mailEmailChange(r.Context(), qtx, user, *req.Email); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send confirmation email", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	resp := response{
+		UserResponse: newUserResponse(user),
+	}
+	if changeEmail {
+		resp.PendingEmail = *req.Email
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
 }
 
 
 
+var ErrMissingUserID = errors.New("user ID is required")
+
+// Helper function to extract user ID from request path
+func getUserIDFromPath(r *http.Request) (uuid.UUID, error) {
+	userIDStr := r.PathValue("userID")
+	if userIDStr == "" {
+		return uuid.UUID{}, ErrMissingUserID
+	}
+
+	return uuid.Parse(userIDStr)
+}
+
+// Helper function to report a failed getUserIDFromPath
+func respondWithUserIDError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrMissingUserID:
+		respondWithError(w, http.StatusNotFound, "User ID is required", nil)
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID format", err)
+	}
+}
+
+// parseUsername normalizes an optional username. Usernames are what
+// @mentions resolve to, so they follow the same rules.
+func parseUsername(username string) (sql.NullString, bool) {
+	if username == "" {
+		return sql.NullString{}, true
+	}
+	username = strings.ToLower(username)
+	if !entities.ValidUsername(username) {
+		return sql.NullString{}, false
+	}
+	return sql.NullString{String: username, Valid: true}, true
+}
+
+// isUniqueViolation reports whether err was caused by the named unique
+// constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
 func isValidEmail(email string) bool {
-	// Simple email validation logic
-	if !strings.Contains(email, "@") {
+	// A bare address, without a display name; whether it receives mail is
+	// checked by verifying it
+	if len(email) > 254 {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
 		return false
 	}
-	return true
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".")
 }
 
-func isValidPassword(password string) bool {
-	// Simple password validation logic
-	if len(password) < 1 {
-		return false
+// validatePasswordPolicy checks a new password against the password policy.
+// It writes the error response itself.
+func validatePasswordPolicy(w http.ResponseWriter, password, email, username string) bool {
+	emailLocalPart, _, _ := strings.Cut(email, "@")
+	err := auth.ValidatePassword(password, emailLocalPart, username)
+	if err == nil {
+		return true
 	}
-	return true
-}
\ No newline at end of file
+
+	message := err.Error()
+	respondWithError(w, http.StatusBadRequest, strings.ToUpper(message[:1])+message[1:], nil)
+	return false
+}
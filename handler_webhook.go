@@ -1,71 +1,296 @@
 package main
 
 import (
+    "context"
+    "database/sql"
     "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
     "net/http"
-    "database/sql"
+    "time"
 
     "github.com/google/uuid"
 	"github.com/vanzei/goserver/internal/auth"
 	"github.com/vanzei/goserver/internal/database"
 )
 
-func (cfg *apiConfig) handlerWebhook(w http.ResponseWriter, r *http.Request) {
+const (
+    webhookSourcePolka = "polka"
+    // Signed requests older or newer than this are rejected as replays
+    webhookTimestampTolerance = 5 * time.Minute
+    maxWebhookBodyBytes = 1 << 20
+)
+
+const (
+    webhookEventPending   = "pending"
+    webhookEventProcessed = "processed"
+    webhookEventIgnored   = "ignored"
+    webhookEventFailed    = "failed"
+)
+
+var (
+    ErrMissingWebhookEventID = errors.New("missing webhook event ID")
+    ErrInvalidWebhookUserID  = errors.New("invalid user ID in webhook event")
+)
+
+type polkaEvent struct {
+    // Unique per event, and the same when Polka redelivers it
+    ID    string `json:"id"`
+    Event string `json:"event"`
+    Data  struct {
+        UserID string `json:"user_id"`
+        // End of the paid period, sent with upgrades and renewals
+        CurrentPeriodEnd time.Time `json:"current_period_end"`
+    } `json:"data"`
+}
+
+// WebhookEventResponse describes a stored webhook event for admins.
+type WebhookEventResponse struct {
+    ID          uuid.UUID       `json:"id"`
+    Source      string          `json:"source"`
+    EventID     string          `json:"event_id"`
+    EventType   string          `json:"event_type"`
+    Payload     json.RawMessage `json:"payload"`
+    Status      string          `json:"status"`
+    Error       string          `json:"error,omitempty"`
+    Deliveries  int32           `json:"deliveries"`
+    ProcessedAt *time.Time      `json:"processed_at"`
+    CreatedAt   time.Time       `json:"created_at"`
+    UpdatedAt   time.Time       `json:"updated_at"`
+}
 
-	// Validate API key
-    apiKey, err := auth.GetAPIKey(r.Header)
+type WebhookEventPageResponse struct {
+    Events     []WebhookEventResponse `json:"events"`
+    NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+func newWebhookEventResponse(event database.WebhookEvent) WebhookEventResponse {
+    response := WebhookEventResponse{
+        ID:         event.ID,
+        Source:     event.Source,
+        EventID:    event.EventID,
+        EventType:  event.EventType,
+        Payload:    json.RawMessage(event.Payload),
+        Status:     event.Status,
+        Error:      event.Error,
+        Deliveries: event.Deliveries,
+        CreatedAt:  event.CreatedAt,
+        UpdatedAt:  event.UpdatedAt,
+    }
+    if event.ProcessedAt.Valid {
+        response.ProcessedAt = &event.ProcessedAt.Time
+    }
+    return response
+}
+
+func (cfg *apiConfig) handlerWebhook(w http.ResponseWriter, r *http.Request) {
+    // The signature covers the exact bytes Polka sent
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
     if err != nil {
-        respondWithError(w, http.StatusUnauthorized, "Unauthorized", err)
+        respondWithError(w, http.StatusBadRequest, "Couldn't read request body", err)
         return
     }
-    
-    // Check if API key matches
-    if apiKey != cfg.polkaWebhookSecret {
-        respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+
+    err = auth.VerifyWebhookSignature(
+        cfg.polkaWebhookSecret,
+        r.Header.Get(auth.WebhookSignatureHeader),
+        r.Header.Get(auth.WebhookTimestampHeader),
+        body,
+        time.Now(),
+        webhookTimestampTolerance,
+    )
+    if err != nil {
+        respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", err)
         return
     }
 
-    // Parse the request body
-    var req struct {
-        Event string `json:"event"`
-        Data  struct {
-            UserID string `json:"user_id"`
-        } `json:"data"`
+    var event polkaEvent
+    if err := json.Unmarshal(body, &event); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Couldn't decode request body", err)
+        return
+    }
+    if event.ID == "" {
+        respondWithError(w, http.StatusBadRequest, "Event ID is required", nil)
+        return
     }
 
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        respondWithError(w, http.StatusBadRequest, "Couldn't decode request body", err)
+    stored, err := cfg.DB.RecordWebhookEvent(r.Context(), database.RecordWebhookEventParams{
+        Source:    webhookSourcePolka,
+        EventID:   event.ID,
+        EventType: event.Event,
+        Payload:   string(body),
+    })
+    if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't store webhook event", err)
         return
     }
 
-    // If event is not user.upgraded, return 204 immediately
-    if req.Event != "user.upgraded" {
-        w.WriteHeader(http.StatusNoContent)
+    if _, err := cfg.processPolkaEvent(r.Context(), stored.ID, false); err != nil {
+        respondWithPolkaEventError(w, err)
         return
     }
 
-    // Parse the user ID
-    userID, err := uuid.Parse(req.Data.UserID)
+    // Return 204 No Content on success
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// processPolkaEvent applies a stored Polka event. The event row stays locked
+// while it's applied, so an event Polka delivers twice at once is still only
+// applied once, and events already handled are skipped unless replay is set.
+// Failures are recorded on the event.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, id uuid.UUID, replay bool) (database.WebhookEvent, error) {
+    tx, err := cfg.db.BeginTx(ctx, nil)
+    if err != nil {
+        return database.WebhookEvent{}, err
+    }
+    defer tx.Rollback()
+    qtx := cfg.DB.WithTx(tx)
+
+    stored, err := qtx.GetWebhookEventForUpdate(ctx, id)
     if err != nil {
+        return database.WebhookEvent{}, err
+    }
+    if !replay && (stored.Status == webhookEventProcessed || stored.Status == webhookEventIgnored) {
+        return stored, nil
+    }
+
+    status, err := applyPolkaEvent(ctx, qtx, []byte(stored.Payload))
+    if err != nil {
+        // Release the row lock before recording the failure
+        tx.Rollback()
+        if _, markErr := cfg.DB.MarkWebhookEventFailed(ctx, database.MarkWebhookEventFailedParams{
+            ID:    id,
+            Error: err.Error(),
+        }); markErr != nil {
+            log.Printf("Couldn't record failure of webhook event %s: %v", id, markErr)
+        }
+        return stored, err
+    }
+
+    stored, err = qtx.MarkWebhookEventDone(ctx, database.MarkWebhookEventDoneParams{
+        ID:     id,
+        Status: status,
+    })
+    if err != nil {
+        return database.WebhookEvent{}, err
+    }
+    return stored, tx.Commit()
+}
+
+// applyPolkaEvent makes the changes a Polka event calls for and returns the
+// status to record for it.
+func applyPolkaEvent(ctx context.Context, q *database.Queries, payload []byte) (string, error) {
+    var event polkaEvent
+    if err := json.Unmarshal(payload, &event); err != nil {
+        return "", err
+    }
+
+    // Polka sends events we don't use; acknowledge them so they aren't
+    // retried
+    if !isSubscriptionEvent(event.Event) {
+        return webhookEventIgnored, nil
+    }
+
+    userID, err := uuid.Parse(event.Data.UserID)
+    if err != nil {
+        return "", fmt.Errorf("%w: %q", ErrInvalidWebhookUserID, event.Data.UserID)
+    }
+
+    // Update the user's subscription and Chirpy Red status
+    err = applySubscriptionEvent(ctx, q, userID, event.Event, event.Data.CurrentPeriodEnd)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return "", fmt.Errorf("user %s not found: %w", userID, err)
+        }
+        return "", err
+    }
+    return webhookEventProcessed, nil
+}
+
+func respondWithPolkaEventError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, sql.ErrNoRows):
+        respondWithError(w, http.StatusNotFound, "User not found", nil)
+    case errors.Is(err, ErrInvalidWebhookUserID):
         respondWithError(w, http.StatusBadRequest, "Invalid user ID format", err)
+    default:
+        respondWithError(w, http.StatusInternalServerError, "Couldn't process webhook event", err)
+    }
+}
+
+func (cfg *apiConfig) handlerGetWebhookEvents(w http.ResponseWriter, r *http.Request) {
+    status := r.URL.Query().Get("status")
+    switch status {
+    case "", webhookEventPending, webhookEventProcessed, webhookEventIgnored, webhookEventFailed:
+    default:
+        respondWithError(w, http.StatusBadRequest, "Status must be pending, processed, ignored or failed", nil)
+        return
+    }
+
+    limit, cursor, err := parsePageParams(r)
+    if err != nil {
+        respondWithPageParamsError(w, err)
         return
     }
 
-    // Update the user's Chirpy Red status
-    _, err = cfg.DB.UpdateUserChirpyRed(r.Context(), database.UpdateUserChirpyRedParams{
-        ID:          userID,
-        IsChirpyRed: true,
+    events, err := cfg.DB.GetWebhookEventsPage(r.Context(), database.GetWebhookEventsPageParams{
+        Status:          sql.NullString{String: status, Valid: status != ""},
+        CursorCreatedAt: cursor.nullCreatedAt(),
+        CursorID:        cursor.nullID(),
+        PageLimit:       int32(limit + 1),
     })
-    
     if err != nil {
-        if err == sql.ErrNoRows {
-            respondWithError(w, http.StatusNotFound, "User not found", nil)
-        } else {
-            respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+        respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook events", err)
+        return
+    }
+
+    page := WebhookEventPageResponse{Events: []WebhookEventResponse{}}
+    if len(events) > limit {
+        events = events[:limit]
+        last := events[len(events)-1]
+        page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+    }
+    for _, event := range events {
+        page.Events = append(page.Events, newWebhookEventResponse(event))
+    }
+
+    respondWithJSON(w, http.StatusOK, page)
+}
+
+// handlerReplayWebhookEvent applies a stored event again, whatever its
+// status, and returns the event with its new status.
+func (cfg *apiConfig) handlerReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+    eventIDStr := r.PathValue("eventID")
+    if eventIDStr == "" {
+        respondWithError(w, http.StatusNotFound, "Event ID is required", ErrMissingWebhookEventID)
+        return
+    }
+    eventID, err := uuid.Parse(eventIDStr)
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid event ID format", err)
+        return
+    }
+
+    if _, err := cfg.DB.GetWebhookEventByID(r.Context(), eventID); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            respondWithError(w, http.StatusNotFound, "Webhook event not found", nil)
+            return
         }
+        respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook event", err)
         return
     }
 
-    // Return 204 No Content on success
-    w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+    if _, err := cfg.processPolkaEvent(r.Context(), eventID, true); err != nil {
+        respondWithPolkaEventError(w, err)
+        return
+    }
+
+    event, err := cfg.DB.GetWebhookEventByID(r.Context(), eventID)
+    if err != nil {
+        respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook event", err)
+        return
+    }
+    respondWithJSON(w, http.StatusOK, newWebhookEventResponse(event))
+}
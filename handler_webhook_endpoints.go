@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+var (
+	ErrMissingWebhookID  = errors.New("missing webhook ID")
+	ErrMissingDeliveryID = errors.New("missing delivery ID")
+)
+
+// WebhookEndpointResponse describes a partner endpoint. The signing secret
+// is only included in the response that creates it.
+type WebhookEndpointResponse struct {
+	ID        uuid.UUID     `json:"id"`
+	URL       string        `json:"url"`
+	Events    []string      `json:"events"`
+	Secret    string        `json:"secret,omitempty"`
+	CreatedBy uuid.NullUUID `json:"created_by"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func newWebhookEndpointResponse(endpoint database.WebhookEndpoint) WebhookEndpointResponse {
+	return WebhookEndpointResponse{
+		ID:        endpoint.ID,
+		URL:       endpoint.Url,
+		Events:    endpoint.Events,
+		CreatedBy: endpoint.CreatedBy,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+// WebhookDeliveryResponse is one entry in an endpoint's delivery log.
+type WebhookDeliveryResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int32          `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type WebhookDeliveryPageResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+func newWebhookDeliveryResponse(delivery database.WebhookDelivery) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:        delivery.ID,
+		WebhookID: delivery.EndpointID,
+		EventID:   delivery.EventID,
+		EventType: delivery.EventType,
+		Payload:   json.RawMessage(delivery.Payload),
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		LastError: delivery.LastError,
+		CreatedAt: delivery.CreatedAt,
+	}
+	if delivery.Status == webhookDeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastStatusCode.Valid {
+		response.LastStatusCode = &delivery.LastStatusCode.Int32
+	}
+	if delivery.DeliveredAt.Valid {
+		response.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlerCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if !isValidWebhookURL(params.URL) {
+		respondWithError(w, http.StatusBadRequest, "URL must be an absolute http or https URL", nil)
+		return
+	}
+	events, ok := parseWebhookEvents(params.Events)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Events must list at least one of chirp.created, chirp.deleted, user.created, user.upgraded", nil)
+		return
+	}
+
+	secret, err := auth.MakeWebhookSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+		return
+	}
+
+	endpoint, err := cfg.DB.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		CreatedBy: staffUserID(r),
+		Url:       params.URL,
+		Secret:    secret,
+		Events:    events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+
+	response := newWebhookEndpointResponse(endpoint)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := cfg.DB.GetWebhookEndpoints(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+
+	response := make([]WebhookEndpointResponse, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		response = append(response, newWebhookEndpointResponse(endpoint))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerDeleteWebhook removes an endpoint along with its delivery log and
+// anything still waiting to be sent to it.
+func (cfg *apiConfig) handlerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteWebhookEndpoint(r.Context(), webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookID, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", webhookDeliveryPending, webhookDeliverySucceeded, webhookDeliveryDead:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Status must be pending, succeeded or dead", nil)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r)
+	if err != nil {
+		respondWithPageParamsError(w, err)
+		return
+	}
+
+	if _, err := cfg.DB.GetWebhookEndpointByID(r.Context(), webhookID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return
+	}
+
+	deliveries, err := cfg.DB.GetWebhookDeliveriesPage(r.Context(), database.GetWebhookDeliveriesPageParams{
+		EndpointID:      webhookID,
+		Status:          sql.NullString{String: status, Valid: status != ""},
+		CursorCreatedAt: cursor.nullCreatedAt(),
+		CursorID:        cursor.nullID(),
+		PageLimit:       int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook deliveries", err)
+		return
+	}
+
+	page := WebhookDeliveryPageResponse{Deliveries: []WebhookDeliveryResponse{}}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[len(deliveries)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	for _, delivery := range deliveries {
+		page.Deliveries = append(page.Deliveries, newWebhookDeliveryResponse(delivery))
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// handlerRetryWebhookDelivery puts a dead delivery back in the queue with a
+// fresh set of attempts.
+func (cfg *apiConfig) handlerRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	webhookID, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryIDStr := r.PathValue("deliveryID")
+	if deliveryIDStr == "" {
+		respondWithError(w, http.StatusNotFound, "Delivery ID is required", ErrMissingDeliveryID)
+		return
+	}
+	deliveryID, err := uuid.Parse(deliveryIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID format", err)
+		return
+	}
+
+	delivery, err := cfg.DB.RetryWebhookDelivery(r.Context(), database.RetryWebhookDeliveryParams{
+		ID:            deliveryID,
+		EndpointID:    webhookID,
+		NextAttemptAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Dead delivery not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retry delivery", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newWebhookDeliveryResponse(delivery))
+}
+
+func webhookIDFromPath(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	webhookIDStr := r.PathValue("webhookID")
+	if webhookIDStr == "" {
+		respondWithError(w, http.StatusNotFound, "Webhook ID is required", ErrMissingWebhookID)
+		return uuid.UUID{}, false
+	}
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID format", err)
+		return uuid.UUID{}, false
+	}
+	return webhookID, true
+}
+
+func isValidWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// parseWebhookEvents checks requested event types and drops duplicates.
+func parseWebhookEvents(requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return nil, false
+	}
+
+	events := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, event := range requested {
+		if !webhookEventTypes[event] {
+			return nil, false
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
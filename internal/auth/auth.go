@@ -1,8 +1,8 @@
 package auth
 
 import (
-	"net/http"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
@@ -26,31 +26,79 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt: jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
-		Subject:   userID.String(),
+// Roles a user can have, from least to most privileged. Each role can do
+// everything the ones before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the privileges of required.
+// Unknown roles grant nothing.
+func HasRole(role, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
 
+// Claims are the claims carried by an access token.
+type Claims struct {
+	Role string `json:"role"`
+	// SessionID identifies the login the token was issued for. The token
+	// stops being accepted once that session is revoked.
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// MakeJWT signs an access token with the keyring's current signing key.
+func MakeJWT(userID uuid.UUID, role string, sessionID uuid.UUID, keyring *Keyring, expiresIn time.Duration) (string, error) {
+	claims := Claims{
+		Role:      role,
+		SessionID: sessionID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(tokenSecret))
+	return keyring.sign(claims)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
+// ParseJWT validates an access token against the keyring and returns all of
+// its claims.
+func ParseJWT(tokenString string, keyring *Keyring) (*Claims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
+	token, err := parser.ParseWithClaims(tokenString, &Claims{}, keyring.verificationKey)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
-	
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		return uuid.Parse(claims.Subject)
+
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
 	}
-	
-	return uuid.Nil, fmt.Errorf("invalid token")
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+func ValidateJWT(tokenString string, keyring *Keyring) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, keyring)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(claims.Subject)
 }
 
 func GetBearerToken(authHeader string) (string, error) {
@@ -86,16 +134,10 @@ func MakeRefreshToken() (string, error) {
 	return encodedToken, nil
 }
 
-func GetAPIKey(headers http.Header) (string, error) {
-    authHeader := headers.Get("Authorization")
-    if authHeader == "" {
-        return "", fmt.Errorf("missing authorization header")
-    }
-    
-    parts := strings.SplitN(authHeader, " ", 2)
-    if len(parts) != 2 || strings.ToLower(parts[0]) != "apikey" {
-        return "", fmt.Errorf("invalid authorization header format")
-    }
-    
-    return parts[1], nil
-}
\ No newline at end of file
+// HashToken returns the hash a random token, such as an email or MFA token,
+// is stored and looked up by. The tokens are long and random, so a fast hash
+// is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -96,10 +96,10 @@ func TestMakeJWT(t *testing.T) {
     // Test 1: Valid JWT creation
     t.Run("Valid JWT creation", func(t *testing.T) {
         userID := uuid.New()
-        tokenSecret := "test-secret"
+        keyring := newTestKeyring(t)
         expiresIn := time.Hour * 24
         
-        token, err := MakeJWT(userID, tokenSecret, expiresIn)
+        token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, expiresIn)
         if err != nil {
             t.Fatalf("MakeJWT returned error: %v", err)
         }
@@ -114,28 +114,24 @@ func TestMakeJWT(t *testing.T) {
         }
     })
     
-    // Test 2: Empty secret
-    t.Run("Empty secret", func(t *testing.T) {
+    // Test 2: Empty keyring
+    t.Run("Empty keyring", func(t *testing.T) {
         userID := uuid.New()
-        emptySecret := ""
         expiresIn := time.Hour
         
-        token, err := MakeJWT(userID, emptySecret, expiresIn)
-        if err != nil {
-            t.Fatalf("MakeJWT with empty secret returned error: %v", err)
-        }
-        if token == "" {
-            t.Fatal("MakeJWT returned empty token")
+        _, err := MakeJWT(userID, RoleUser, uuid.New(), NewKeyring(), expiresIn)
+        if err == nil {
+            t.Fatal("MakeJWT should return error without a signing key")
         }
     })
     
     // Test 3: Zero expiration
     t.Run("Zero expiration", func(t *testing.T) {
         userID := uuid.New()
-        tokenSecret := "test-secret"
+        keyring := newTestKeyring(t)
         zeroExpiration := time.Duration(0)
         
-        token, err := MakeJWT(userID, tokenSecret, zeroExpiration)
+        token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, zeroExpiration)
         if err != nil {
             t.Fatalf("MakeJWT with zero expiration returned error: %v", err)
         }
@@ -149,15 +145,15 @@ func TestValidateJWT(t *testing.T) {
     // Test 1: Valid token validation
     t.Run("Valid token", func(t *testing.T) {
         userID := uuid.New()
-        tokenSecret := "test-secret"
+        keyring := newTestKeyring(t)
         expiresIn := time.Hour
         
-        token, err := MakeJWT(userID, tokenSecret, expiresIn)
+        token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, expiresIn)
         if err != nil {
             t.Fatalf("MakeJWT returned error: %v", err)
         }
         
-        extractedID, err := ValidateJWT(token, tokenSecret)
+        extractedID, err := ValidateJWT(token, keyring)
         if err != nil {
             t.Fatalf("ValidateJWT returned error: %v", err)
         }
@@ -170,39 +166,39 @@ func TestValidateJWT(t *testing.T) {
     // Test 2: Invalid token format
     t.Run("Invalid token format", func(t *testing.T) {
         invalidToken := "invalid.token.format"
-        tokenSecret := "test-secret"
+        keyring := newTestKeyring(t)
         
-        _, err := ValidateJWT(invalidToken, tokenSecret)
+        _, err := ValidateJWT(invalidToken, keyring)
         if err == nil {
             t.Fatal("ValidateJWT should return error for invalid token")
         }
     })
     
-    // Test 3: Wrong secret
-    t.Run("Wrong secret", func(t *testing.T) {
+    // Test 3: Wrong keyring
+    t.Run("Wrong keyring", func(t *testing.T) {
         userID := uuid.New()
-        tokenSecret := "correct-secret"
-        wrongSecret := "wrong-secret"
+        keyring := newTestKeyring(t)
+        otherKeyring := newTestKeyring(t)
         expiresIn := time.Hour
         
-        token, err := MakeJWT(userID, tokenSecret, expiresIn)
+        token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, expiresIn)
         if err != nil {
             t.Fatalf("MakeJWT returned error: %v", err)
         }
         
-        _, err = ValidateJWT(token, wrongSecret)
+        _, err = ValidateJWT(token, otherKeyring)
         if err == nil {
-            t.Fatal("ValidateJWT should return error for token with wrong secret")
+            t.Fatal("ValidateJWT should return error for token signed by another keyring")
         }
     })
     
     // Test 4: Expired token
     t.Run("Expired token", func(t *testing.T) {
         userID := uuid.New()
-        tokenSecret := "test-secret"
+        keyring := newTestKeyring(t)
         expiresIn := time.Millisecond // Very short duration
         
-        token, err := MakeJWT(userID, tokenSecret, expiresIn)
+        token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, expiresIn)
         if err != nil {
             t.Fatalf("MakeJWT returned error: %v", err)
         }
@@ -210,9 +206,93 @@ func TestValidateJWT(t *testing.T) {
         // Wait for token to expire
         time.Sleep(time.Millisecond * 5)
         
-        _, err = ValidateJWT(token, tokenSecret)
+        _, err = ValidateJWT(token, keyring)
         if err == nil {
             t.Fatal("ValidateJWT should return error for expired token")
         }
     })
-}
\ No newline at end of file
+}
+
+func TestHashToken(t *testing.T) {
+    // Email and MFA tokens are made like refresh tokens
+    token, err := MakeRefreshToken()
+    if err != nil {
+        t.Fatalf("MakeRefreshToken returned error: %v", err)
+    }
+    other, err := MakeRefreshToken()
+    if err != nil {
+        t.Fatalf("MakeRefreshToken returned error: %v", err)
+    }
+
+    hash := HashToken(token)
+    if hash == token {
+        t.Fatal("Hash should not be equal to the token")
+    }
+    if hash != HashToken(token) {
+        t.Fatal("Hashing the same token twice should give the same hash")
+    }
+    if hash == HashToken(other) {
+        t.Fatal("Different tokens should have different hashes")
+    }
+}
+
+func TestParseJWT(t *testing.T) {
+    t.Run("Role and session are carried in the claims", func(t *testing.T) {
+        userID := uuid.New()
+        sessionID := uuid.New()
+        keyring := newTestKeyring(t)
+
+        token, err := MakeJWT(userID, RoleModerator, sessionID, keyring, time.Hour)
+        if err != nil {
+            t.Fatalf("MakeJWT returned error: %v", err)
+        }
+
+        claims, err := ParseJWT(token, keyring)
+        if err != nil {
+            t.Fatalf("ParseJWT returned error: %v", err)
+        }
+        if claims.Role != RoleModerator {
+            t.Fatalf("Role = %q, want %q", claims.Role, RoleModerator)
+        }
+        if claims.Subject != userID.String() {
+            t.Fatalf("Subject = %q, want %q", claims.Subject, userID)
+        }
+        if claims.SessionID != sessionID.String() {
+            t.Fatalf("SessionID = %q, want %q", claims.SessionID, sessionID)
+        }
+    })
+
+    t.Run("Wrong keyring", func(t *testing.T) {
+        token, err := MakeJWT(uuid.New(), RoleAdmin, uuid.New(), newTestKeyring(t), time.Hour)
+        if err != nil {
+            t.Fatalf("MakeJWT returned error: %v", err)
+        }
+
+        if _, err := ParseJWT(token, newTestKeyring(t)); err == nil {
+            t.Fatal("ParseJWT should return error for token signed by another keyring")
+        }
+    })
+}
+
+func TestHasRole(t *testing.T) {
+    tests := []struct {
+        role     string
+        required string
+        want     bool
+    }{
+        {RoleUser, RoleUser, true},
+        {RoleUser, RoleModerator, false},
+        {RoleModerator, RoleModerator, true},
+        {RoleModerator, RoleAdmin, false},
+        {RoleAdmin, RoleModerator, true},
+        {RoleAdmin, RoleAdmin, true},
+        {"", RoleUser, false},
+        {"superuser", RoleUser, false},
+    }
+
+    for _, tt := range tests {
+        if got := HasRole(tt.role, tt.required); got != tt.want {
+            t.Errorf("HasRole(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+        }
+    }
+}
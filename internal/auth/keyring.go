@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signing algorithms a Keyring supports.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing keys.
+const minRSAKeyBits = 2048
+
+// Keyring holds the keys access tokens are signed and verified with. Tokens
+// name their key in the kid header, so keys can be rotated by adding a new
+// signing key while the old ones stay around to verify tokens that haven't
+// expired yet. Only public keys ever leave the keyring.
+type Keyring struct {
+	mu        sync.RWMutex
+	keys      map[string]*keyringKey
+	signingID string
+}
+
+type keyringKey struct {
+	id        string
+	algorithm string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]*keyringKey{}}
+}
+
+// AddPrivateKey adds an RSA or Ed25519 private key. The first private key
+// added becomes the signing key.
+func (k *Keyring) AddPrivateKey(id string, key crypto.Signer) error {
+	entry, err := newKeyringKey(id, key.Public())
+	if err != nil {
+		return err
+	}
+	entry.private = key
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	k.keys[id] = entry
+	if k.signingID == "" {
+		k.signingID = id
+	}
+	return nil
+}
+
+// AddPublicKey adds a key that can only verify tokens, such as a retired
+// signing key whose private half has been destroyed.
+func (k *Keyring) AddPublicKey(id string, key crypto.PublicKey) error {
+	entry, err := newKeyringKey(id, key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	k.keys[id] = entry
+	return nil
+}
+
+// SetSigningKey picks which private key signs new tokens.
+func (k *Keyring) SetSigningKey(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return fmt.Errorf("unknown key ID %q", id)
+	}
+	if key.private == nil {
+		return fmt.Errorf("key %q has no private key", id)
+	}
+	k.signingID = id
+	return nil
+}
+
+func newKeyringKey(id string, public crypto.PublicKey) (*keyringKey, error) {
+	if id == "" {
+		return nil, fmt.Errorf("key ID is required")
+	}
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key %q is shorter than %d bits", id, minRSAKeyBits)
+		}
+		return &keyringKey{id: id, algorithm: AlgorithmRS256, method: jwt.SigningMethodRS256, public: pub}, nil
+	case ed25519.PublicKey:
+		return &keyringKey{id: id, algorithm: AlgorithmEdDSA, method: jwt.SigningMethodEdDSA, public: pub}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key %q", public, id)
+	}
+}
+
+// sign signs claims with the current signing key.
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	key, ok := k.keys[k.signingID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("keyring has no signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// verificationKey is a jwt.Keyfunc. It looks the key up by kid and refuses
+// tokens whose algorithm isn't the one that key signs with, so a token can't
+// pick a weaker method, such as HS256 keyed with a public key, or none.
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	id, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no key ID")
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", id)
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), id)
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every public key in the keyring, ordered by key ID.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.algorithm}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
+
+// LoadKeyring reads every .pem file in dir, using the file name without its
+// extension as the key ID. Files may hold a PKCS #8 or PKCS #1 private key,
+// or a PKIX public key for verification only. signingID picks the signing
+// key; when empty, the private key whose ID sorts last is used, so naming
+// keys by date rotates them in order.
+func LoadKeyring(dir, signingID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keyring := NewKeyring()
+	lastPrivate := ""
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY", "RSA PRIVATE KEY":
+			key, err := parsePrivateKey(block)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if err := keyring.AddPrivateKey(id, key); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			lastPrivate = id
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if err := keyring.AddPublicKey(id, key); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+		}
+	}
+
+	if signingID == "" {
+		signingID = lastPrivate
+	}
+	if signingID == "" {
+		return nil, fmt.Errorf("no private keys in %s", dir)
+	}
+	if err := keyring.SetSigningKey(signingID); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// NewEphemeralKeyring returns a keyring with a freshly generated Ed25519
+// key. Tokens it signs stop verifying once the process exits, so it is only
+// meant for development and tests.
+func NewEphemeralKeyring() (*Keyring, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	keyring := NewKeyring()
+	if err := keyring.AddPrivateKey("dev-"+base64.RawURLEncoding.EncodeToString(id), private); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/pem"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v4"
+    "github.com/google/uuid"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+    t.Helper()
+    keyring, err := NewEphemeralKeyring()
+    if err != nil {
+        t.Fatalf("NewEphemeralKeyring returned error: %v", err)
+    }
+    return keyring
+}
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+    t.Helper()
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatalf("rsa.GenerateKey returned error: %v", err)
+    }
+    return key
+}
+
+func TestKeyringAlgorithms(t *testing.T) {
+    _, edKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatalf("ed25519.GenerateKey returned error: %v", err)
+    }
+
+    tests := []struct {
+        name string
+        alg  string
+        add  func(*Keyring) error
+    }{
+        {
+            name: "RS256",
+            alg:  AlgorithmRS256,
+            add: func(k *Keyring) error {
+                return k.AddPrivateKey("rsa-1", newTestRSAKey(t))
+            },
+        },
+        {
+            name: "EdDSA",
+            alg:  AlgorithmEdDSA,
+            add: func(k *Keyring) error {
+                return k.AddPrivateKey("ed-1", edKey)
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            keyring := NewKeyring()
+            if err := tt.add(keyring); err != nil {
+                t.Fatalf("AddPrivateKey returned error: %v", err)
+            }
+
+            userID := uuid.New()
+            token, err := MakeJWT(userID, RoleUser, uuid.New(), keyring, time.Hour)
+            if err != nil {
+                t.Fatalf("MakeJWT returned error: %v", err)
+            }
+
+            parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+            if err != nil {
+                t.Fatalf("ParseUnverified returned error: %v", err)
+            }
+            if parsed.Method.Alg() != tt.alg {
+                t.Fatalf("alg = %q, want %q", parsed.Method.Alg(), tt.alg)
+            }
+            if parsed.Header["kid"] == nil {
+                t.Fatal("token has no kid header")
+            }
+
+            got, err := ValidateJWT(token, keyring)
+            if err != nil {
+                t.Fatalf("ValidateJWT returned error: %v", err)
+            }
+            if got != userID {
+                t.Fatalf("ValidateJWT = %s, want %s", got, userID)
+            }
+        })
+    }
+}
+
+func TestKeyringRotation(t *testing.T) {
+    _, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+    _, newKey, _ := ed25519.GenerateKey(rand.Reader)
+
+    keyring := NewKeyring()
+    if err := keyring.AddPrivateKey("2024-01", oldKey); err != nil {
+        t.Fatalf("AddPrivateKey returned error: %v", err)
+    }
+    oldToken, err := MakeJWT(uuid.New(), RoleUser, uuid.New(), keyring, time.Hour)
+    if err != nil {
+        t.Fatalf("MakeJWT returned error: %v", err)
+    }
+
+    // Rotate: new tokens use the new key, old tokens still verify
+    if err := keyring.AddPrivateKey("2024-06", newKey); err != nil {
+        t.Fatalf("AddPrivateKey returned error: %v", err)
+    }
+    if err := keyring.SetSigningKey("2024-06"); err != nil {
+        t.Fatalf("SetSigningKey returned error: %v", err)
+    }
+    newToken, err := MakeJWT(uuid.New(), RoleUser, uuid.New(), keyring, time.Hour)
+    if err != nil {
+        t.Fatalf("MakeJWT returned error: %v", err)
+    }
+
+    parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+    if parsed.Header["kid"] != "2024-06" {
+        t.Fatalf("kid = %v, want 2024-06", parsed.Header["kid"])
+    }
+    for _, token := range []string{oldToken, newToken} {
+        if _, err := ValidateJWT(token, keyring); err != nil {
+            t.Fatalf("ValidateJWT returned error: %v", err)
+        }
+    }
+
+    // Retire the old key to verify-only, then drop it entirely
+    retired := NewKeyring()
+    retired.AddPrivateKey("2024-06", newKey)
+    retired.AddPublicKey("2024-01", oldKey.Public())
+    if _, err := ValidateJWT(oldToken, retired); err != nil {
+        t.Fatalf("ValidateJWT with a public key returned error: %v", err)
+    }
+    if err := retired.SetSigningKey("2024-01"); err == nil {
+        t.Fatal("SetSigningKey should refuse a public-only key")
+    }
+
+    dropped := NewKeyring()
+    dropped.AddPrivateKey("2024-06", newKey)
+    if _, err := ValidateJWT(oldToken, dropped); err == nil {
+        t.Fatal("ValidateJWT should reject a token signed by an unknown key")
+    }
+}
+
+func TestKeyringRejectsOtherSigningMethods(t *testing.T) {
+    rsaKey := newTestRSAKey(t)
+    keyring := NewKeyring()
+    if err := keyring.AddPrivateKey("rsa-1", rsaKey); err != nil {
+        t.Fatalf("AddPrivateKey returned error: %v", err)
+    }
+
+    claims := Claims{
+        Role: RoleAdmin,
+        RegisteredClaims: jwt.RegisteredClaims{
+            Subject:   uuid.New().String(),
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+        },
+    }
+
+    t.Run("HS256 keyed with the public key", func(t *testing.T) {
+        publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+        if err != nil {
+            t.Fatalf("MarshalPKIXPublicKey returned error: %v", err)
+        }
+        publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+        token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+        token.Header["kid"] = "rsa-1"
+        signed, err := token.SignedString(publicPEM)
+        if err != nil {
+            t.Fatalf("SignedString returned error: %v", err)
+        }
+
+        if _, err := ParseJWT(signed, keyring); err == nil {
+            t.Fatal("ParseJWT should reject an HS256 token")
+        }
+    })
+
+    t.Run("Unsigned", func(t *testing.T) {
+        token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+        token.Header["kid"] = "rsa-1"
+        signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+        if err != nil {
+            t.Fatalf("SignedString returned error: %v", err)
+        }
+
+        if _, err := ParseJWT(signed, keyring); err == nil {
+            t.Fatal("ParseJWT should reject an unsigned token")
+        }
+    })
+
+    t.Run("EdDSA token claiming an RSA key", func(t *testing.T) {
+        _, edKey, _ := ed25519.GenerateKey(rand.Reader)
+        token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+        token.Header["kid"] = "rsa-1"
+        signed, err := token.SignedString(edKey)
+        if err != nil {
+            t.Fatalf("SignedString returned error: %v", err)
+        }
+
+        if _, err := ParseJWT(signed, keyring); err == nil {
+            t.Fatal("ParseJWT should reject a token whose method doesn't match its key")
+        }
+    })
+
+    t.Run("Missing kid", func(t *testing.T) {
+        token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+        signed, err := token.SignedString(rsaKey)
+        if err != nil {
+            t.Fatalf("SignedString returned error: %v", err)
+        }
+
+        if _, err := ParseJWT(signed, keyring); err == nil {
+            t.Fatal("ParseJWT should reject a token without a kid")
+        }
+    })
+}
+
+func TestKeyringRejectsWeakKeys(t *testing.T) {
+    weak, err := rsa.GenerateKey(rand.Reader, 1024)
+    if err != nil {
+        t.Fatalf("rsa.GenerateKey returned error: %v", err)
+    }
+    if err := NewKeyring().AddPrivateKey("weak", weak); err == nil {
+        t.Fatal("AddPrivateKey should reject a 1024-bit RSA key")
+    }
+}
+
+func TestJWKS(t *testing.T) {
+    _, edKey, _ := ed25519.GenerateKey(rand.Reader)
+    keyring := NewKeyring()
+    keyring.AddPrivateKey("b-ed", edKey)
+    keyring.AddPrivateKey("a-rsa", newTestRSAKey(t))
+
+    set := keyring.JWKS()
+    if len(set.Keys) != 2 {
+        t.Fatalf("len(Keys) = %d, want 2", len(set.Keys))
+    }
+
+    rsaJWK, edJWK := set.Keys[0], set.Keys[1]
+    if rsaJWK.KeyID != "a-rsa" || rsaJWK.KeyType != "RSA" || rsaJWK.Algorithm != AlgorithmRS256 {
+        t.Fatalf("unexpected RSA key: %+v", rsaJWK)
+    }
+    if rsaJWK.N == "" || rsaJWK.E != "AQAB" {
+        t.Fatalf("unexpected RSA parameters: n=%q e=%q", rsaJWK.N, rsaJWK.E)
+    }
+    if edJWK.KeyID != "b-ed" || edJWK.KeyType != "OKP" || edJWK.Curve != "Ed25519" || edJWK.Algorithm != AlgorithmEdDSA {
+        t.Fatalf("unexpected Ed25519 key: %+v", edJWK)
+    }
+    if edJWK.X == "" {
+        t.Fatal("Ed25519 key has no x parameter")
+    }
+}
+
+func TestLoadKeyring(t *testing.T) {
+    dir := t.TempDir()
+
+    writeKey := func(name, blockType string, der []byte) {
+        t.Helper()
+        data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+        if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+            t.Fatalf("WriteFile returned error: %v", err)
+        }
+    }
+
+    rsaKey := newTestRSAKey(t)
+    writeKey("2024-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+    _, edKey, _ := ed25519.GenerateKey(rand.Reader)
+    edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+    if err != nil {
+        t.Fatalf("MarshalPKCS8PrivateKey returned error: %v", err)
+    }
+    writeKey("2024-06.pem", "PRIVATE KEY", edDER)
+
+    _, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+    oldDER, err := x509.MarshalPKIXPublicKey(oldKey.Public())
+    if err != nil {
+        t.Fatalf("MarshalPKIXPublicKey returned error: %v", err)
+    }
+    writeKey("2023-06.pem", "PUBLIC KEY", oldDER)
+
+    keyring, err := LoadKeyring(dir, "")
+    if err != nil {
+        t.Fatalf("LoadKeyring returned error: %v", err)
+    }
+    if got := len(keyring.JWKS().Keys); got != 3 {
+        t.Fatalf("len(Keys) = %d, want 3", got)
+    }
+
+    // The private key that sorts last signs by default
+    token, err := MakeJWT(uuid.New(), RoleUser, uuid.New(), keyring, time.Hour)
+    if err != nil {
+        t.Fatalf("MakeJWT returned error: %v", err)
+    }
+    parsed, _, _ := jwt.NewParser().ParseUnverified(token, &Claims{})
+    if parsed.Header["kid"] != "2024-06" {
+        t.Fatalf("kid = %v, want 2024-06", parsed.Header["kid"])
+    }
+
+    keyring, err = LoadKeyring(dir, "2024-01")
+    if err != nil {
+        t.Fatalf("LoadKeyring returned error: %v", err)
+    }
+    token, _ = MakeJWT(uuid.New(), RoleUser, uuid.New(), keyring, time.Hour)
+    parsed, _, _ = jwt.NewParser().ParseUnverified(token, &Claims{})
+    if parsed.Header["kid"] != "2024-01" {
+        t.Fatalf("kid = %v, want 2024-01", parsed.Header["kid"])
+    }
+
+    if _, err := LoadKeyring(dir, "2023-06"); err == nil {
+        t.Fatal("LoadKeyring should refuse to sign with a public-only key")
+    }
+    if _, err := LoadKeyring(t.TempDir(), ""); err == nil {
+        t.Fatal("LoadKeyring should fail without any private keys")
+    }
+}
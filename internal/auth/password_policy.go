@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	// bcrypt only looks at the first 72 bytes, so anything longer would be
+	// silently truncated.
+	MaxPasswordBytes = 72
+)
+
+var (
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong    = errors.New("password must be at most 72 bytes")
+	ErrPasswordTooCommon  = errors.New("password is too common")
+	ErrPasswordIsIdentity = errors.New("password must not contain your email or username")
+)
+
+// Passwords that show up at the top of every breach list. Length alone
+// would let most of these through.
+var commonPasswords = map[string]bool{
+	"password":     true,
+	"password1":    true,
+	"password12":   true,
+	"password123":  true,
+	"passw0rd":     true,
+	"12345678":     true,
+	"123456789":    true,
+	"1234567890":   true,
+	"87654321":     true,
+	"11111111":     true,
+	"00000000":     true,
+	"qwertyuiop":   true,
+	"qwerty123":    true,
+	"qwerty12":     true,
+	"1q2w3e4r":     true,
+	"1qaz2wsx":     true,
+	"abc12345":     true,
+	"abcd1234":     true,
+	"iloveyou":     true,
+	"sunshine":     true,
+	"princess":     true,
+	"football":     true,
+	"baseball":     true,
+	"welcome1":     true,
+	"letmein1":     true,
+	"trustno1":     true,
+	"superman":     true,
+	"chirpy123":    true,
+	"chirpychirpy": true,
+}
+
+// ValidatePassword checks password against the password policy. identities
+// are values the password mustn't contain, such as the user's username and
+// the local part of their email address; empty or very short ones are
+// ignored.
+func ValidatePassword(password string, identities ...string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > MaxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] || strings.Count(lower, lower[:1]) == len(lower) {
+		return ErrPasswordTooCommon
+	}
+
+	for _, identity := range identities {
+		identity = strings.ToLower(identity)
+		if len(identity) >= 3 && strings.Contains(lower, identity) {
+			return ErrPasswordIsIdentity
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+    tests := []struct {
+        name       string
+        password   string
+        identities []string
+        want       error
+    }{
+        {"Strong password", "correct horse battery", nil, nil},
+        {"Minimum length", "x7#kQ2!m", nil, nil},
+        {"Too short", "x7#kQ2!", nil, ErrPasswordTooShort},
+        {"Too short in characters", "ñññ", nil, ErrPasswordTooShort},
+        {"Too long", strings.Repeat("ab", 37), nil, ErrPasswordTooLong},
+        {"Common password", "Password123", nil, ErrPasswordTooCommon},
+        {"Repeated character", "aaaaaaaaaa", nil, ErrPasswordTooCommon},
+        {"Contains username", "xXAliceWonderXx", []string{"alicewonder"}, ErrPasswordIsIdentity},
+        {"Contains email local part", "bob.smith!2024", []string{"bob.smith"}, ErrPasswordIsIdentity},
+        {"Short identities are ignored", "ab-kitchen-sink", []string{"ab", ""}, nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := ValidatePassword(tt.password, tt.identities...); got != tt.want {
+                t.Fatalf("ValidatePassword(%q) = %v, want %v", tt.password, got, tt.want)
+            }
+        })
+    }
+}
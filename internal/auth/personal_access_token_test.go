@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken returned error: %v", err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Fatalf("Token %q doesn't have the %q prefix", token, PersonalAccessTokenPrefix)
+	}
+	if len(token) != len(PersonalAccessTokenPrefix)+64 {
+		t.Fatalf("Token has length %d, want %d", len(token), len(PersonalAccessTokenPrefix)+64)
+	}
+
+	other, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken returned error: %v", err)
+	}
+	if token == other {
+		t.Fatal("MakePersonalAccessToken returned the same token twice")
+	}
+}
+
+func TestHashPersonalAccessToken(t *testing.T) {
+	token := PersonalAccessTokenPrefix + "abc"
+
+	hash := HashPersonalAccessToken(token)
+	if hash == token {
+		t.Fatal("Hash should not be equal to the token")
+	}
+	if hash != HashPersonalAccessToken(token) {
+		t.Fatal("Hashing the same token twice should give the same hash")
+	}
+	if hash == HashPersonalAccessToken(token+"d") {
+		t.Fatal("Different tokens should have different hashes")
+	}
+}
+
+func TestIsPersonalAccessToken(t *testing.T) {
+	keyring := newTestKeyring(t)
+	jwt, err := MakeJWT(uuid.New(), RoleUser, uuid.New(), keyring, 0)
+	if err != nil {
+		t.Fatalf("MakeJWT returned error: %v", err)
+	}
+
+	if IsPersonalAccessToken(jwt) {
+		t.Fatal("A JWT shouldn't be taken for a personal access token")
+	}
+	if IsPersonalAccessToken("") {
+		t.Fatal("An empty token shouldn't be taken for a personal access token")
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	granted := []string{ScopeChirpsRead, ScopeProfileWrite}
+
+	if !HasScope(granted, ScopeChirpsRead) {
+		t.Fatal("HasScope should find a granted scope")
+	}
+	if HasScope(granted, ScopeChirpsWrite) {
+		t.Fatal("HasScope shouldn't find a scope that wasn't granted")
+	}
+	if HasScope(nil, ScopeChirpsRead) {
+		t.Fatal("No scopes should grant nothing")
+	}
+	if ValidScope("admin") {
+		t.Fatal("ValidScope should reject unknown scopes")
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "hash"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+    // Test vectors from RFC 6238 Appendix B: eight digit codes with a 30
+    // second period, each algorithm with its own seed.
+    seeds := map[string][]byte{
+        "SHA1":   []byte("12345678901234567890"),
+        "SHA256": []byte("12345678901234567890123456789012"),
+        "SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+    }
+    algorithms := map[string]func() hash.Hash{
+        "SHA1":   sha1.New,
+        "SHA256": sha256.New,
+        "SHA512": sha512.New,
+    }
+
+    tests := []struct {
+        unix      int64
+        algorithm string
+        want      string
+    }{
+        {59, "SHA1", "94287082"},
+        {59, "SHA256", "46119246"},
+        {59, "SHA512", "90693936"},
+        {1111111109, "SHA1", "07081804"},
+        {1111111109, "SHA256", "68084774"},
+        {1111111109, "SHA512", "25091201"},
+        {1111111111, "SHA1", "14050471"},
+        {1111111111, "SHA256", "67062674"},
+        {1111111111, "SHA512", "99943326"},
+        {1234567890, "SHA1", "89005924"},
+        {1234567890, "SHA256", "91819424"},
+        {1234567890, "SHA512", "93441116"},
+        {2000000000, "SHA1", "69279037"},
+        {2000000000, "SHA256", "90698825"},
+        {2000000000, "SHA512", "38618901"},
+        {20000000000, "SHA1", "65353130"},
+        {20000000000, "SHA256", "77737706"},
+        {20000000000, "SHA512", "47863826"},
+    }
+
+    for _, tt := range tests {
+        step := TOTPStep(time.Unix(tt.unix, 0))
+        got := hotp(seeds[tt.algorithm], uint64(step), 8, algorithms[tt.algorithm])
+        if got != tt.want {
+            t.Errorf("TOTP %s at %d = %s, want %s", tt.algorithm, tt.unix, got, tt.want)
+        }
+    }
+}
+
+func TestGenerateTOTPCode(t *testing.T) {
+    // The SHA1 seed from RFC 6238, base32 encoded, truncated to six digits
+    secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+    code, err := GenerateTOTPCode(secret, time.Unix(1111111109, 0))
+    if err != nil {
+        t.Fatalf("GenerateTOTPCode returned error: %v", err)
+    }
+    if code != "081804" {
+        t.Fatalf("GenerateTOTPCode = %s, want 081804", code)
+    }
+
+    if _, err := GenerateTOTPCode("not base32!", time.Now()); err == nil {
+        t.Fatal("GenerateTOTPCode should return error for an invalid secret")
+    }
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+    secret, err := GenerateTOTPSecret()
+    if err != nil {
+        t.Fatalf("GenerateTOTPSecret returned error: %v", err)
+    }
+    now := time.Unix(1700000000, 0)
+
+    code, err := GenerateTOTPCode(secret, now)
+    if err != nil {
+        t.Fatalf("GenerateTOTPCode returned error: %v", err)
+    }
+
+    t.Run("Current code", func(t *testing.T) {
+        step, ok := ValidateTOTPCode(secret, code, now)
+        if !ok {
+            t.Fatal("ValidateTOTPCode should accept the current code")
+        }
+        if step != TOTPStep(now) {
+            t.Fatalf("Step = %d, want %d", step, TOTPStep(now))
+        }
+    })
+
+    t.Run("Clock drift of one period", func(t *testing.T) {
+        if _, ok := ValidateTOTPCode(secret, code, now.Add(TOTPPeriod)); !ok {
+            t.Fatal("ValidateTOTPCode should accept a code from the previous period")
+        }
+        if _, ok := ValidateTOTPCode(secret, code, now.Add(-TOTPPeriod)); !ok {
+            t.Fatal("ValidateTOTPCode should accept a code from the next period")
+        }
+    })
+
+    t.Run("Old code", func(t *testing.T) {
+        if _, ok := ValidateTOTPCode(secret, code, now.Add(3*TOTPPeriod)); ok {
+            t.Fatal("ValidateTOTPCode should reject a code from three periods ago")
+        }
+    })
+
+    t.Run("Malformed code", func(t *testing.T) {
+        for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+            if _, ok := ValidateTOTPCode(secret, bad, now); ok {
+                t.Fatalf("ValidateTOTPCode should reject %q", bad)
+            }
+        }
+    })
+}
+
+func TestTOTPURI(t *testing.T) {
+    uri := TOTPURI("JBSWY3DPEHPK3PXP", "Chirpy", "alice@example.com")
+
+    parsed, err := url.Parse(uri)
+    if err != nil {
+        t.Fatalf("TOTPURI returned an invalid URL: %v", err)
+    }
+    if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
+        t.Fatalf("URI %q should start with otpauth://totp/", uri)
+    }
+    if parsed.Path != "/Chirpy:alice@example.com" {
+        t.Fatalf("Label = %q, want /Chirpy:alice@example.com", parsed.Path)
+    }
+    query := parsed.Query()
+    if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "Chirpy" {
+        t.Fatalf("URI %q is missing the secret or issuer", uri)
+    }
+}
+
+func TestRecoveryCodes(t *testing.T) {
+    codes, err := MakeRecoveryCodes(10)
+    if err != nil {
+        t.Fatalf("MakeRecoveryCodes returned error: %v", err)
+    }
+    if len(codes) != 10 {
+        t.Fatalf("Got %d codes, want 10", len(codes))
+    }
+
+    seen := map[string]bool{}
+    for _, code := range codes {
+        if seen[code] {
+            t.Fatalf("Code %q was generated twice", code)
+        }
+        seen[code] = true
+    }
+
+    code := codes[0]
+    loose := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+    if HashRecoveryCode(code) != HashRecoveryCode(loose) {
+        t.Fatal("HashRecoveryCode should ignore case, dashes and spaces")
+    }
+    if HashRecoveryCode(code) == HashRecoveryCode(codes[1]) {
+        t.Fatal("Different codes should have different hashes")
+    }
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+    secret := "polka-secret"
+    body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+    sentAt := time.Unix(1700000000, 0)
+    timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+    signature := SignWebhook(secret, sentAt, body)
+    tolerance := 5 * time.Minute
+
+    tests := []struct {
+        name      string
+        secret    string
+        signature string
+        timestamp string
+        body      []byte
+        now       time.Time
+        wantErr   error
+    }{
+        {"valid", secret, signature, timestamp, body, sentAt.Add(time.Minute), nil},
+        {"clock slightly behind", secret, signature, timestamp, body, sentAt.Add(-time.Minute), nil},
+        {"wrong secret", "other-secret", signature, timestamp, body, sentAt, ErrInvalidWebhookSignature},
+        {"modified body", secret, signature, timestamp, []byte(`{"id":"evt_1","event":"user.downgraded"}`), sentAt, ErrInvalidWebhookSignature},
+        {"modified timestamp", secret, signature, strconv.FormatInt(sentAt.Unix()+1, 10), body, sentAt, ErrInvalidWebhookSignature},
+        {"missing prefix", secret, signature[len("sha256="):], timestamp, body, sentAt, ErrInvalidWebhookSignature},
+        {"not hex", secret, "sha256=zz", timestamp, body, sentAt, ErrInvalidWebhookSignature},
+        {"missing signature", secret, "", timestamp, body, sentAt, ErrMissingWebhookSignature},
+        {"missing timestamp", secret, signature, "", body, sentAt, ErrMissingWebhookSignature},
+        {"bad timestamp", secret, signature, "yesterday", body, sentAt, ErrInvalidWebhookTimestamp},
+        {"stale", secret, signature, timestamp, body, sentAt.Add(tolerance + time.Second), ErrStaleWebhookTimestamp},
+        {"from the future", secret, signature, timestamp, body, sentAt.Add(-tolerance - time.Second), ErrStaleWebhookTimestamp},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := VerifyWebhookSignature(tt.secret, tt.signature, tt.timestamp, tt.body, tt.now, tolerance)
+            if err != tt.wantErr {
+                t.Fatalf("VerifyWebhookSignature() error = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestMakeWebhookSecret(t *testing.T) {
+    secret, err := MakeWebhookSecret()
+    if err != nil {
+        t.Fatalf("MakeWebhookSecret returned error: %v", err)
+    }
+    if !strings.HasPrefix(secret, WebhookSecretPrefix) {
+        t.Fatalf("Secret %q doesn't have the %q prefix", secret, WebhookSecretPrefix)
+    }
+
+    other, err := MakeWebhookSecret()
+    if err != nil {
+        t.Fatalf("MakeWebhookSecret returned error: %v", err)
+    }
+    if secret == other {
+        t.Fatal("MakeWebhookSecret returned the same secret twice")
+    }
+
+    // Secrets sign and verify like any other
+    now := time.Now()
+    body := []byte(`{"type":"chirp.created"}`)
+    signature := SignWebhook(secret, now, body)
+    if err := VerifyWebhookSignature(secret, signature, strconv.FormatInt(now.Unix(), 10), body, now, time.Minute); err != nil {
+        t.Fatalf("VerifyWebhookSignature returned error: %v", err)
+    }
+}
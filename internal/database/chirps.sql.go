@@ -7,29 +7,46 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const countChirpReplies = `-- name: CountChirpReplies :one
+SELECT COUNT(*) FROM chirps
+WHERE in_reply_to = $1
+`
+
+func (q *Queries) CountChirpReplies(ctx context.Context, inReplyTo uuid.NullUUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpReplies, inReplyTo)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.NullUUID
+	Body      string
+	UserID    uuid.NullUUID
+	InReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.InReplyTo)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +54,10 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
 	)
 	return i, err
 }
@@ -51,8 +72,216 @@ func (q *Queries) DeleteChirpbyId(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const getAuthorFeedPageAsc = `-- name: GetAuthorFeedPageAsc :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at, feed.activity_id, feed.activity_at, feed.rechirped_by
+FROM (
+    SELECT chirps.id AS activity_id, chirps.created_at AS activity_at, chirps.id AS chirp_id, NULL::uuid AS rechirped_by
+    FROM chirps
+    WHERE chirps.user_id = $1::uuid
+    UNION ALL
+    SELECT rechirps.id, rechirps.created_at, rechirps.chirp_id, rechirps.user_id
+    FROM rechirps
+    WHERE rechirps.user_id = $1::uuid
+) feed
+JOIN chirps ON chirps.id = feed.chirp_id
+WHERE chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    $2::timestamp IS NULL
+    OR (feed.activity_at, feed.activity_id) > ($2::timestamp, $3::uuid)
+)
+ORDER BY feed.activity_at ASC, feed.activity_id ASC
+LIMIT $4
+`
+
+type GetAuthorFeedPageAscParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetAuthorFeedPageAscRow struct {
+	Chirp       Chirp
+	ActivityID  uuid.UUID
+	ActivityAt  time.Time
+	RechirpedBy uuid.NullUUID
+}
+
+func (q *Queries) GetAuthorFeedPageAsc(ctx context.Context, arg GetAuthorFeedPageAscParams) ([]GetAuthorFeedPageAscRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAuthorFeedPageAsc,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAuthorFeedPageAscRow
+	for rows.Next() {
+		var i GetAuthorFeedPageAscRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.SearchVector,
+			&i.Chirp.HiddenAt,
+			&i.ActivityID,
+			&i.ActivityAt,
+			&i.RechirpedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuthorFeedPageDesc = `-- name: GetAuthorFeedPageDesc :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at, feed.activity_id, feed.activity_at, feed.rechirped_by
+FROM (
+    SELECT chirps.id AS activity_id, chirps.created_at AS activity_at, chirps.id AS chirp_id, NULL::uuid AS rechirped_by
+    FROM chirps
+    WHERE chirps.user_id = $1::uuid
+    UNION ALL
+    SELECT rechirps.id, rechirps.created_at, rechirps.chirp_id, rechirps.user_id
+    FROM rechirps
+    WHERE rechirps.user_id = $1::uuid
+) feed
+JOIN chirps ON chirps.id = feed.chirp_id
+WHERE chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    $2::timestamp IS NULL
+    OR (feed.activity_at, feed.activity_id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY feed.activity_at DESC, feed.activity_id DESC
+LIMIT $4
+`
+
+type GetAuthorFeedPageDescParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetAuthorFeedPageDescRow struct {
+	Chirp       Chirp
+	ActivityID  uuid.UUID
+	ActivityAt  time.Time
+	RechirpedBy uuid.NullUUID
+}
+
+func (q *Queries) GetAuthorFeedPageDesc(ctx context.Context, arg GetAuthorFeedPageDescParams) ([]GetAuthorFeedPageDescRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAuthorFeedPageDesc,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAuthorFeedPageDescRow
+	for rows.Next() {
+		var i GetAuthorFeedPageDescRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.SearchVector,
+			&i.Chirp.HiddenAt,
+			&i.ActivityID,
+			&i.ActivityAt,
+			&i.RechirpedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpThread = `-- name: GetChirpThread :many
+WITH RECURSIVE thread AS (
+    SELECT chirps.id
+    FROM chirps
+    WHERE chirps.id = $2
+    UNION ALL
+    SELECT reply.id
+    FROM chirps reply
+    JOIN thread ON reply.in_reply_to = thread.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at FROM chirps
+JOIN thread ON chirps.id = thread.id
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $1
+`
+
+type GetChirpThreadParams struct {
+	MaxChirps int32
+	RootID    uuid.UUID
+}
+
+func (q *Queries) GetChirpThread(ctx context.Context, arg GetChirpThreadParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpThread, arg.MaxChirps, arg.RootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getChirpbyId = `-- name: GetChirpbyId :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps   
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps   
 WHERE id = $1
 `
 
@@ -65,12 +294,39 @@ func (q *Queries) GetChirpbyId(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const getChirpbyIdForUpdate = `-- name: GetChirpbyIdForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetChirpbyIdForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpbyIdForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
 	)
 	return i, err
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps
 ORDER BY created_at ASC
 `
 
@@ -89,6 +345,10 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -104,7 +364,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps
 WHERE user_id = $1
 ORDER BY created_at ASC
 `
@@ -124,6 +384,10 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.NullUUID) (
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -137,3 +401,326 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.NullUUID) (
 	}
 	return items, nil
 }
+
+const getChirpsPageAsc = `-- name: GetChirpsPageAsc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps
+WHERE deleted_at IS NULL
+AND hidden_at IS NULL
+AND (
+    $1::timestamp IS NULL
+    OR (created_at, id) > ($1::timestamp, $2::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $3
+`
+
+type GetChirpsPageAscParams struct {
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsPageAsc(ctx context.Context, arg GetChirpsPageAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPageAsc, arg.CursorCreatedAt, arg.CursorID, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsPageDesc = `-- name: GetChirpsPageDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at FROM chirps
+WHERE deleted_at IS NULL
+AND hidden_at IS NULL
+AND (
+    $1::timestamp IS NULL
+    OR (created_at, id) < ($1::timestamp, $2::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $3
+`
+
+type GetChirpsPageDescParams struct {
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsPageDesc(ctx context.Context, arg GetChirpsPageDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPageDesc, arg.CursorCreatedAt, arg.CursorID, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReplyCounts = `-- name: GetReplyCounts :many
+SELECT in_reply_to, COUNT(*) AS reply_count FROM chirps
+WHERE in_reply_to = ANY($1::uuid[])
+AND deleted_at IS NULL
+AND hidden_at IS NULL
+GROUP BY in_reply_to
+`
+
+type GetReplyCountsRow struct {
+	InReplyTo  uuid.NullUUID
+	ReplyCount int64
+}
+
+func (q *Queries) GetReplyCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetReplyCountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getReplyCounts, pq.Array(chirpIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReplyCountsRow
+	for rows.Next() {
+		var i GetReplyCountsRow
+		if err := rows.Scan(&i.InReplyTo, &i.ReplyCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getThreadRootID = `-- name: GetThreadRootID :one
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.in_reply_to, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = $1
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+    FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT ancestors.id FROM ancestors
+ORDER BY ancestors.depth DESC
+LIMIT 1
+`
+
+func (q *Queries) GetThreadRootID(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getThreadRootID, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const hideChirp = `-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at
+`
+
+func (q *Queries) HideChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, hideChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+WITH search AS (
+    SELECT websearch_to_tsquery('english', $6::text) AS query
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at,
+    ts_rank_cd(chirps.search_vector, search.query)::real AS rank,
+    ts_headline(
+        'english',
+        chirps.body,
+        search.query,
+        -- U+E000/U+E001 mark matches; the server turns them into <mark> tags
+        -- after escaping the body, so chirp text can't inject markup
+        'StartSel=' || chr(57344) || ', StopSel=' || chr(57345)
+            || ', MaxFragments=2, MaxWords=20, MinWords=5'
+    )::text AS snippet
+FROM chirps, search
+WHERE chirps.search_vector @@ search.query
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND ($1::uuid IS NULL OR chirps.user_id = $1::uuid)
+AND ($2::timestamptz IS NULL OR chirps.created_at >= $2::timestamptz)
+AND ($3::timestamptz IS NULL OR chirps.created_at < $3::timestamptz)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT $5
+OFFSET $4
+`
+
+type SearchChirpsParams struct {
+	AuthorID   uuid.NullUUID
+	Since      sql.NullTime
+	Until      sql.NullTime
+	PageOffset int32
+	PageLimit  int32
+	Query      string
+}
+
+type SearchChirpsRow struct {
+	Chirp   Chirp
+	Rank    float32
+	Snippet string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageOffset,
+		arg.PageLimit,
+		arg.Query,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.SearchVector,
+			&i.Chirp.HiddenAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :one
+UPDATE chirps
+SET body = '',
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, tombstoneChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, search_vector, hidden_at
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.HiddenAt,
+	)
+	return i, err
+}
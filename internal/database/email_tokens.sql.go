@@ -0,0 +1,249 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: email_tokens.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const changeUserEmail = `-- name: ChangeUserEmail :one
+UPDATE users
+SET updated_at = NOW(),
+    email = $2,
+    email_verified_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, suspended_until, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, locked_until
+`
+
+type ChangeUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// The token proves the new address works, so it is verified right away.
+func (q *Queries) ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, changeUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Username,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const createEmailToken = `-- name: CreateEmailToken :one
+INSERT INTO email_tokens (user_id, purpose, token_hash, email, expires_at, requested_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at, requested_at
+`
+
+type CreateEmailTokenParams struct {
+	UserID      uuid.UUID
+	Purpose     string
+	TokenHash   string
+	Email       string
+	ExpiresAt   time.Time
+	RequestedAt time.Time
+}
+
+func (q *Queries) CreateEmailToken(ctx context.Context, arg CreateEmailTokenParams) (EmailToken, error) {
+	row := q.db.QueryRowContext(ctx, createEmailToken,
+		arg.UserID,
+		arg.Purpose,
+		arg.TokenHash,
+		arg.Email,
+		arg.ExpiresAt,
+		arg.RequestedAt,
+	)
+	var i EmailToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Purpose,
+		&i.TokenHash,
+		&i.Email,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.CreatedAt,
+		&i.RequestedAt,
+	)
+	return i, err
+}
+
+const hasLaterEmailTokenRequest = `-- name: HasLaterEmailTokenRequest :one
+SELECT EXISTS (
+    SELECT 1 FROM email_tokens
+    WHERE user_id = $1
+    AND purpose = $2
+    AND requested_at > $3::timestamp
+)
+`
+
+type HasLaterEmailTokenRequestParams struct {
+	UserID      uuid.UUID
+	Purpose     string
+	RequestedAt time.Time
+}
+
+func (q *Queries) HasLaterEmailTokenRequest(ctx context.Context, arg HasLaterEmailTokenRequestParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, hasLaterEmailTokenRequest, arg.UserID, arg.Purpose, arg.RequestedAt)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const invalidateEmailTokens = `-- name: InvalidateEmailTokens :exec
+UPDATE email_tokens
+SET used_at = NOW()
+WHERE user_id = $1
+AND purpose = $2
+AND used_at IS NULL
+`
+
+type InvalidateEmailTokensParams struct {
+	UserID  uuid.UUID
+	Purpose string
+}
+
+// A new token replaces any unused ones for the same purpose.
+func (q *Queries) InvalidateEmailTokens(ctx context.Context, arg InvalidateEmailTokensParams) error {
+	_, err := q.db.ExecContext(ctx, invalidateEmailTokens, arg.UserID, arg.Purpose)
+	return err
+}
+
+const lockEmailTokens = `-- name: LockEmailTokens :exec
+SELECT pg_advisory_xact_lock(hashtext('email_tokens'), hashtext($1::uuid::text))
+`
+
+// Held until the transaction ends, so tokens for a user are issued one at a
+// time.
+func (q *Queries) LockEmailTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockEmailTokens, userID)
+	return err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users
+SET updated_at = NOW(),
+    email_verified_at = COALESCE(email_verified_at, NOW())
+WHERE id = $1
+AND email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, suspended_until, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, locked_until
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// Only verifies the address the token was sent to, in case it has changed
+// since.
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Username,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :one
+UPDATE users
+SET updated_at = NOW(),
+    hashed_password = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, suspended_until, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, locked_until
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Username,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const useEmailToken = `-- name: UseEmailToken :one
+UPDATE email_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+AND purpose = $2
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at, requested_at
+`
+
+type UseEmailTokenParams struct {
+	TokenHash string
+	Purpose   string
+}
+
+func (q *Queries) UseEmailToken(ctx context.Context, arg UseEmailTokenParams) (EmailToken, error) {
+	row := q.db.QueryRowContext(ctx, useEmailToken, arg.TokenHash, arg.Purpose)
+	var i EmailToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Purpose,
+		&i.TokenHash,
+		&i.Email,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.CreatedAt,
+		&i.RequestedAt,
+	)
+	return i, err
+}
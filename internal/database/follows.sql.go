@@ -0,0 +1,261 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: follows.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const followUser = `-- name: FollowUser :exec
+INSERT INTO follows (follower_id, followee_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type FollowUserParams struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+func (q *Queries) FollowUser(ctx context.Context, arg FollowUserParams) error {
+	_, err := q.db.ExecContext(ctx, followUser, arg.FollowerID, arg.FolloweeID)
+	return err
+}
+
+const getFolloweeIDs = `-- name: GetFolloweeIDs :many
+SELECT followee_id FROM follows
+WHERE follower_id = $1
+`
+
+func (q *Queries) GetFolloweeIDs(ctx context.Context, followerID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, getFolloweeIDs, followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var followee_id uuid.UUID
+		if err := rows.Scan(&followee_id); err != nil {
+			return nil, err
+		}
+		items = append(items, followee_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFollowersPage = `-- name: GetFollowersPage :many
+SELECT follower_id AS user_id, created_at AS followed_at FROM follows
+WHERE followee_id = $1
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, follower_id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at DESC, follower_id DESC
+LIMIT $4
+`
+
+type GetFollowersPageParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetFollowersPageRow struct {
+	UserID     uuid.UUID
+	FollowedAt time.Time
+}
+
+func (q *Queries) GetFollowersPage(ctx context.Context, arg GetFollowersPageParams) ([]GetFollowersPageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowersPage,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFollowersPageRow
+	for rows.Next() {
+		var i GetFollowersPageRow
+		if err := rows.Scan(&i.UserID, &i.FollowedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFollowingPage = `-- name: GetFollowingPage :many
+SELECT followee_id AS user_id, created_at AS followed_at FROM follows
+WHERE follower_id = $1
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, followee_id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at DESC, followee_id DESC
+LIMIT $4
+`
+
+type GetFollowingPageParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetFollowingPageRow struct {
+	UserID     uuid.UUID
+	FollowedAt time.Time
+}
+
+func (q *Queries) GetFollowingPage(ctx context.Context, arg GetFollowingPageParams) ([]GetFollowingPageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowingPage,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFollowingPageRow
+	for rows.Next() {
+		var i GetFollowingPageRow
+		if err := rows.Scan(&i.UserID, &i.FollowedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTimelinePage = `-- name: GetTimelinePage :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at, feed.activity_id, feed.activity_at, feed.rechirped_by
+FROM (
+    SELECT chirps.id AS activity_id, chirps.created_at AS activity_at, chirps.id AS chirp_id, NULL::uuid AS rechirped_by
+    FROM chirps
+    WHERE chirps.user_id = $1::uuid
+    OR chirps.user_id IN (
+        SELECT followee_id FROM follows
+        WHERE follower_id = $1::uuid
+    )
+    UNION ALL
+    SELECT rechirps.id, rechirps.created_at, rechirps.chirp_id, rechirps.user_id
+    FROM rechirps
+    WHERE rechirps.user_id = $1::uuid
+    OR rechirps.user_id IN (
+        SELECT followee_id FROM follows
+        WHERE follower_id = $1::uuid
+    )
+) feed
+JOIN chirps ON chirps.id = feed.chirp_id
+WHERE chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    $2::timestamp IS NULL
+    OR (feed.activity_at, feed.activity_id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY feed.activity_at DESC, feed.activity_id DESC
+LIMIT $4
+`
+
+type GetTimelinePageParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetTimelinePageRow struct {
+	Chirp       Chirp
+	ActivityID  uuid.UUID
+	ActivityAt  time.Time
+	RechirpedBy uuid.NullUUID
+}
+
+func (q *Queries) GetTimelinePage(ctx context.Context, arg GetTimelinePageParams) ([]GetTimelinePageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTimelinePage,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTimelinePageRow
+	for rows.Next() {
+		var i GetTimelinePageRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.SearchVector,
+			&i.Chirp.HiddenAt,
+			&i.ActivityID,
+			&i.ActivityAt,
+			&i.RechirpedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const unfollowUser = `-- name: UnfollowUser :exec
+DELETE FROM follows
+WHERE follower_id = $1
+AND followee_id = $2
+`
+
+type UnfollowUserParams struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+func (q *Queries) UnfollowUser(ctx context.Context, arg UnfollowUserParams) error {
+	_, err := q.db.ExecContext(ctx, unfollowUser, arg.FollowerID, arg.FolloweeID)
+	return err
+}
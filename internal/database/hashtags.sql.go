@@ -0,0 +1,113 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: hashtags.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const addChirpHashtag = `-- name: AddChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, hashtag_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type AddChirpHashtagParams struct {
+	ChirpID   uuid.UUID
+	HashtagID uuid.UUID
+}
+
+func (q *Queries) AddChirpHashtag(ctx context.Context, arg AddChirpHashtagParams) error {
+	_, err := q.db.ExecContext(ctx, addChirpHashtag, arg.ChirpID, arg.HashtagID)
+	return err
+}
+
+const deleteChirpHashtags = `-- name: DeleteChirpHashtags :exec
+DELETE FROM chirp_hashtags
+WHERE chirp_id = $1
+`
+
+func (q *Queries) DeleteChirpHashtags(ctx context.Context, chirpID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteChirpHashtags, chirpID)
+	return err
+}
+
+const getChirpsByHashtagPage = `-- name: GetChirpsByHashtagPage :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.search_vector, chirps.hidden_at FROM chirps
+JOIN chirp_hashtags ON chirp_hashtags.chirp_id = chirps.id
+JOIN hashtags ON hashtags.id = chirp_hashtags.hashtag_id
+WHERE hashtags.tag = $1
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    $2::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
+`
+
+type GetChirpsByHashtagPageParams struct {
+	Tag             string
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsByHashtagPage(ctx context.Context, arg GetChirpsByHashtagPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByHashtagPage,
+		arg.Tag,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upsertHashtag = `-- name: UpsertHashtag :one
+INSERT INTO hashtags (tag)
+VALUES ($1)
+ON CONFLICT (tag) DO UPDATE SET tag = EXCLUDED.tag
+RETURNING id, tag
+`
+
+func (q *Queries) UpsertHashtag(ctx context.Context, tag string) (Hashtag, error) {
+	row := q.db.QueryRowContext(ctx, upsertHashtag, tag)
+	var i Hashtag
+	err := row.Scan(&i.ID, &i.Tag)
+	return i, err
+}
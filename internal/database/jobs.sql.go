@@ -0,0 +1,186 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: jobs.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const claimJob = `-- name: ClaimJob :one
+UPDATE jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_until = $1::timestamp,
+    updated_at = NOW()
+WHERE id = (
+    SELECT due.id FROM jobs AS due
+    WHERE due.kind = ANY($2::text[])
+    AND (
+        (due.status = 'pending' AND due.run_at <= $3::timestamp)
+        OR (due.status = 'running' AND due.locked_until <= $3::timestamp)
+    )
+    ORDER BY due.run_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, kind, payload, status, attempts, max_attempts, run_at, locked_until, last_error, unique_key, finished_at, created_at, updated_at
+`
+
+type ClaimJobParams struct {
+	LockedUntil time.Time
+	Kinds       []string
+	Now         time.Time
+}
+
+// Takes the job of one of kinds that has been due the longest, including
+// running jobs whose worker stopped renewing the lock.
+func (q *Queries) ClaimJob(ctx context.Context, arg ClaimJobParams) (Job, error) {
+	row := q.db.QueryRowContext(ctx, claimJob, arg.LockedUntil, pq.Array(arg.Kinds), arg.Now)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.RunAt,
+		&i.LockedUntil,
+		&i.LastError,
+		&i.UniqueKey,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const completeJob = `-- name: CompleteJob :exec
+UPDATE jobs
+SET status = 'succeeded',
+    payload = 'null',
+    locked_until = NULL,
+    last_error = '',
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+// Payloads can hold personal data, so they aren't kept once a job is done.
+func (q *Queries) CompleteJob(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, completeJob, id)
+	return err
+}
+
+const deleteFinishedJobs = `-- name: DeleteFinishedJobs :execrows
+DELETE FROM jobs
+WHERE (status = 'succeeded' AND finished_at < $1::timestamp)
+OR (status = 'failed' AND finished_at < $2::timestamp)
+`
+
+type DeleteFinishedJobsParams struct {
+	SucceededBefore time.Time
+	FailedBefore    time.Time
+}
+
+func (q *Queries) DeleteFinishedJobs(ctx context.Context, arg DeleteFinishedJobsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFinishedJobs, arg.SucceededBefore, arg.FailedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueJob = `-- name: EnqueueJob :exec
+INSERT INTO jobs (kind, payload, max_attempts, run_at, unique_key)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL DO NOTHING
+`
+
+type EnqueueJobParams struct {
+	Kind        string
+	Payload     json.RawMessage
+	MaxAttempts int32
+	RunAt       time.Time
+	UniqueKey   sql.NullString
+}
+
+// Jobs with a unique_key that's already taken are dropped.
+func (q *Queries) EnqueueJob(ctx context.Context, arg EnqueueJobParams) error {
+	_, err := q.db.ExecContext(ctx, enqueueJob,
+		arg.Kind,
+		arg.Payload,
+		arg.MaxAttempts,
+		arg.RunAt,
+		arg.UniqueKey,
+	)
+	return err
+}
+
+const extendJobLock = `-- name: ExtendJobLock :exec
+UPDATE jobs
+SET locked_until = $1::timestamp
+WHERE id = $2 AND status = 'running'
+`
+
+type ExtendJobLockParams struct {
+	LockedUntil time.Time
+	ID          uuid.UUID
+}
+
+// Called while a job runs, so it isn't taken for abandoned.
+func (q *Queries) ExtendJobLock(ctx context.Context, arg ExtendJobLockParams) error {
+	_, err := q.db.ExecContext(ctx, extendJobLock, arg.LockedUntil, arg.ID)
+	return err
+}
+
+const failJob = `-- name: FailJob :exec
+UPDATE jobs
+SET status = 'failed',
+    payload = 'null',
+    locked_until = NULL,
+    last_error = $1,
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type FailJobParams struct {
+	LastError string
+	ID        uuid.UUID
+}
+
+func (q *Queries) FailJob(ctx context.Context, arg FailJobParams) error {
+	_, err := q.db.ExecContext(ctx, failJob, arg.LastError, arg.ID)
+	return err
+}
+
+const retryJob = `-- name: RetryJob :exec
+UPDATE jobs
+SET status = 'pending',
+    run_at = $1,
+    locked_until = NULL,
+    last_error = $2,
+    updated_at = NOW()
+WHERE id = $3
+`
+
+type RetryJobParams struct {
+	RunAt     time.Time
+	LastError string
+	ID        uuid.UUID
+}
+
+func (q *Queries) RetryJob(ctx context.Context, arg RetryJobParams) error {
+	_, err := q.db.ExecContext(ctx, retryJob, arg.RunAt, arg.LastError, arg.ID)
+	return err
+}
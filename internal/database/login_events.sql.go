@@ -0,0 +1,174 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: login_events.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createLoginEvent = `-- name: CreateLoginEvent :exec
+INSERT INTO login_events (user_id, email, ip_address, user_agent, outcome)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateLoginEventParams struct {
+	UserID    uuid.NullUUID
+	Email     string
+	IpAddress string
+	UserAgent string
+	Outcome   string
+}
+
+func (q *Queries) CreateLoginEvent(ctx context.Context, arg CreateLoginEventParams) error {
+	_, err := q.db.ExecContext(ctx, createLoginEvent,
+		arg.UserID,
+		arg.Email,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.Outcome,
+	)
+	return err
+}
+
+const getLoginEventsPage = `-- name: GetLoginEventsPage :many
+SELECT id, user_id, email, ip_address, user_agent, outcome, created_at FROM login_events
+WHERE user_id = $1
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type GetLoginEventsPageParams struct {
+	UserID          uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetLoginEventsPage(ctx context.Context, arg GetLoginEventsPageParams) ([]LoginEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getLoginEventsPage,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LoginEvent
+	for rows.Next() {
+		var i LoginEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Email,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Outcome,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentLoginFailuresByIP = `-- name: GetRecentLoginFailuresByIP :one
+SELECT COUNT(*) AS failures,
+    COALESCE(EXTRACT(EPOCH FROM NOW() - MAX(created_at)), 0)::float8 AS seconds_since_last
+FROM login_events
+WHERE ip_address = $1
+AND outcome IN ('bad_password', 'unknown_user', 'bad_mfa_code')
+AND created_at > NOW() - make_interval(secs => $2::float8)
+`
+
+type GetRecentLoginFailuresByIPParams struct {
+	IpAddress     string
+	WindowSeconds float64
+}
+
+type GetRecentLoginFailuresByIPRow struct {
+	Failures         int64
+	SecondsSinceLast float64
+}
+
+// Times are measured by the database, which also set created_at.
+func (q *Queries) GetRecentLoginFailuresByIP(ctx context.Context, arg GetRecentLoginFailuresByIPParams) (GetRecentLoginFailuresByIPRow, error) {
+	row := q.db.QueryRowContext(ctx, getRecentLoginFailuresByIP, arg.IpAddress, arg.WindowSeconds)
+	var i GetRecentLoginFailuresByIPRow
+	err := row.Scan(&i.Failures, &i.SecondsSinceLast)
+	return i, err
+}
+
+const lockLoginAttemptsByIP = `-- name: LockLoginAttemptsByIP :exec
+SELECT pg_advisory_xact_lock(hashtext('login_attempts'), hashtext($1))
+`
+
+// Held until the transaction ends, so attempts from one address are checked
+// and recorded one at a time.
+func (q *Queries) LockLoginAttemptsByIP(ctx context.Context, ipAddress string) error {
+	_, err := q.db.ExecContext(ctx, lockLoginAttemptsByIP, ipAddress)
+	return err
+}
+
+const recordFailedLogin = `-- name: RecordFailedLogin :one
+UPDATE users
+SET failed_login_count = failed_login_count + 1
+WHERE id = $1
+RETURNING failed_login_count
+`
+
+func (q *Queries) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordFailedLogin, id)
+	var failed_login_count int32
+	err := row.Scan(&failed_login_count)
+	return failed_login_count, err
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :execrows
+UPDATE users
+SET failed_login_count = 0,
+    locked_until = NULL
+WHERE id = $1
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, resetFailedLogins, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserLockedUntil = `-- name: SetUserLockedUntil :exec
+UPDATE users
+SET locked_until = $2
+WHERE id = $1
+`
+
+type SetUserLockedUntilParams struct {
+	ID          uuid.UUID
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) SetUserLockedUntil(ctx context.Context, arg SetUserLockedUntilParams) error {
+	_, err := q.db.ExecContext(ctx, setUserLockedUntil, arg.ID, arg.LockedUntil)
+	return err
+}
@@ -6,34 +6,278 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Chirp struct {
-	ID        uuid.UUID
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.NullUUID
+	InReplyTo    uuid.NullUUID
+	DeletedAt    sql.NullTime
+	SearchVector interface{}
+	HiddenAt     sql.NullTime
+}
+
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID
+	HashtagID uuid.UUID
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+}
+
+type ChirpMention struct {
+	ChirpID     uuid.UUID
+	UserID      uuid.UUID
+	StartOffset int32
+	EndOffset   int32
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type EmailToken struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Purpose     string
+	TokenHash   string
+	Email       string
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+	CreatedAt   time.Time
+	RequestedAt time.Time
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Hashtag struct {
+	ID  uuid.UUID
+	Tag string
+}
+
+type Job struct {
+	ID          uuid.UUID
+	Kind        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int32
+	MaxAttempts int32
+	RunAt       time.Time
+	LockedUntil sql.NullTime
+	LastError   string
+	UniqueKey   sql.NullString
+	FinishedAt  sql.NullTime
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type LoginEvent struct {
+	ID        uuid.UUID
 	UserID    uuid.NullUUID
+	Email     string
+	IpAddress string
+	UserAgent string
+	Outcome   string
+	CreatedAt time.Time
 }
 
-type RefreshToken struct {
+type MfaChallenge struct {
 	ID        uuid.UUID
 	UserID    uuid.UUID
-	Token     string
+	TokenHash string
+	Attempts  int32
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type ModerationAction struct {
+	ID             uuid.UUID
+	Action         string
+	ModeratorID    uuid.NullUUID
+	ReportID       uuid.NullUUID
+	ChirpID        uuid.NullUUID
+	ChirpBody      sql.NullString
+	UserID         uuid.NullUUID
+	Note           string
+	SuspendedUntil sql.NullTime
+	CreatedAt      time.Time
+	Role           sql.NullString
+}
+
+type ModerationTerm struct {
+	ID        uuid.UUID
+	Term      string
+	MatchMode string
+	Action    string
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Name        string
+	TokenHash   string
+	TokenPrefix string
+	Scopes      []string
+	ExpiresAt   time.Time
+	LastUsedAt  sql.NullTime
+	CreatedAt   time.Time
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+type Rechirp struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+type RefreshToken struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	Token        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ExpiresAt    time.Time
+	RevokedAt    sql.NullTime
+	FamilyID     uuid.UUID
+	ParentID     uuid.NullUUID
+	SupersededAt sql.NullTime
+	UserAgent    string
+	IpAddress    string
+	LastUsedAt   time.Time
+}
+
+type Report struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	ReporterID uuid.NullUUID
+	Reason     string
+	Details    string
+	Status     string
+	Resolution sql.NullString
+	ResolvedAt sql.NullTime
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type ScheduledChirp struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Body          string
+	InReplyTo     uuid.NullUUID
+	PublishAt     time.Time
+	Status        string
+	FailureReason string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type StreamEvent struct {
+	ID        int64
+	Type      string
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	Hashtags  []string
+	Payload   string
+	CreatedAt time.Time
+}
+
+type Subscription struct {
+	UserID           uuid.UUID
+	Status           string
+	CurrentPeriodEnd time.Time
+	AccessUntil      time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 }
 
 type User struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Email            string
+	HashedPassword   string
+	IsChirpyRed      bool
+	Username         sql.NullString
+	SuspendedUntil   sql.NullTime
+	Role             string
+	TotpSecret       sql.NullString
+	TotpEnabledAt    sql.NullTime
+	TotpLastStep     sql.NullInt64
+	EmailVerifiedAt  sql.NullTime
+	FailedLoginCount int32
+	LockedUntil      sql.NullTime
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
+	EndpointID     uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastStatusCode sql.NullInt32
+	LastError      string
+	DeliveredAt    sql.NullTime
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+}
+
+type WebhookEndpoint struct {
+	ID        uuid.UUID
+	CreatedBy uuid.NullUUID
+	Url       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID
+	Source      string
+	EventID     string
+	EventType   string
+	Payload     string
+	Status      string
+	Error       string
+	Deliveries  int32
+	ProcessedAt sql.NullTime
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
@@ -0,0 +1,260 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: moderation.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createModerationAction = `-- name: CreateModerationAction :one
+INSERT INTO moderation_actions (action, moderator_id, report_id, chirp_id, chirp_body, user_id, note, suspended_until, role)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING id, action, moderator_id, report_id, chirp_id, chirp_body, user_id, note, suspended_until, created_at, role
+`
+
+type CreateModerationActionParams struct {
+	Action         string
+	ModeratorID    uuid.NullUUID
+	ReportID       uuid.NullUUID
+	ChirpID        uuid.NullUUID
+	ChirpBody      sql.NullString
+	UserID         uuid.NullUUID
+	Note           string
+	SuspendedUntil sql.NullTime
+	Role           sql.NullString
+}
+
+func (q *Queries) CreateModerationAction(ctx context.Context, arg CreateModerationActionParams) (ModerationAction, error) {
+	row := q.db.QueryRowContext(ctx, createModerationAction,
+		arg.Action,
+		arg.ModeratorID,
+		arg.ReportID,
+		arg.ChirpID,
+		arg.ChirpBody,
+		arg.UserID,
+		arg.Note,
+		arg.SuspendedUntil,
+		arg.Role,
+	)
+	var i ModerationAction
+	err := row.Scan(
+		&i.ID,
+		&i.Action,
+		&i.ModeratorID,
+		&i.ReportID,
+		&i.ChirpID,
+		&i.ChirpBody,
+		&i.UserID,
+		&i.Note,
+		&i.SuspendedUntil,
+		&i.CreatedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const createModerationTerm = `-- name: CreateModerationTerm :one
+INSERT INTO moderation_terms (term, match_mode, action)
+VALUES ($1, $2, $3)
+RETURNING id, term, match_mode, action, created_at, updated_at
+`
+
+type CreateModerationTermParams struct {
+	Term      string
+	MatchMode string
+	Action    string
+}
+
+func (q *Queries) CreateModerationTerm(ctx context.Context, arg CreateModerationTermParams) (ModerationTerm, error) {
+	row := q.db.QueryRowContext(ctx, createModerationTerm, arg.Term, arg.MatchMode, arg.Action)
+	var i ModerationTerm
+	err := row.Scan(
+		&i.ID,
+		&i.Term,
+		&i.MatchMode,
+		&i.Action,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteModerationTerm = `-- name: DeleteModerationTerm :execrows
+DELETE FROM moderation_terms
+WHERE id = $1
+`
+
+func (q *Queries) DeleteModerationTerm(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteModerationTerm, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getModerationActionsPage = `-- name: GetModerationActionsPage :many
+SELECT id, action, moderator_id, report_id, chirp_id, chirp_body, user_id, note, suspended_until, created_at, role FROM moderation_actions
+WHERE ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::uuid IS NULL OR chirp_id = $2::uuid)
+AND (
+    $3::timestamp IS NULL
+    OR (created_at, id) < ($3::timestamp, $4::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`
+
+type GetModerationActionsPageParams struct {
+	UserID          uuid.NullUUID
+	ChirpID         uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetModerationActionsPage(ctx context.Context, arg GetModerationActionsPageParams) ([]ModerationAction, error) {
+	rows, err := q.db.QueryContext(ctx, getModerationActionsPage,
+		arg.UserID,
+		arg.ChirpID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ModerationAction
+	for rows.Next() {
+		var i ModerationAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.ModeratorID,
+			&i.ReportID,
+			&i.ChirpID,
+			&i.ChirpBody,
+			&i.UserID,
+			&i.Note,
+			&i.SuspendedUntil,
+			&i.CreatedAt,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getModerationTerm = `-- name: GetModerationTerm :one
+SELECT id, term, match_mode, action, created_at, updated_at FROM moderation_terms
+WHERE id = $1
+`
+
+func (q *Queries) GetModerationTerm(ctx context.Context, id uuid.UUID) (ModerationTerm, error) {
+	row := q.db.QueryRowContext(ctx, getModerationTerm, id)
+	var i ModerationTerm
+	err := row.Scan(
+		&i.ID,
+		&i.Term,
+		&i.MatchMode,
+		&i.Action,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const listModerationTerms = `-- name: ListModerationTerms :many
+SELECT id, term, match_mode, action, created_at, updated_at FROM moderation_terms
+ORDER BY term ASC
+`
+
+func (q *Queries) ListModerationTerms(ctx context.Context) ([]ModerationTerm, error) {
+	rows, err := q.db.QueryContext(ctx, listModerationTerms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ModerationTerm
+	for rows.Next() {
+		var i ModerationTerm
+		if err := rows.Scan(
+			&i.ID,
+			&i.Term,
+			&i.MatchMode,
+			&i.Action,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateModerationTerm = `-- name: UpdateModerationTerm :one
+UPDATE moderation_terms
+SET term = COALESCE($1, term),
+    match_mode = COALESCE($2, match_mode),
+    action = COALESCE($3, action),
+    updated_at = NOW()
+WHERE id = $4
+RETURNING id, term, match_mode, action, created_at, updated_at
+`
+
+type UpdateModerationTermParams struct {
+	Term      sql.NullString
+	MatchMode sql.NullString
+	Action    sql.NullString
+	ID        uuid.UUID
+}
+
+func (q *Queries) UpdateModerationTerm(ctx context.Context, arg UpdateModerationTermParams) (ModerationTerm, error) {
+	row := q.db.QueryRowContext(ctx, updateModerationTerm,
+		arg.Term,
+		arg.MatchMode,
+		arg.Action,
+		arg.ID,
+	)
+	var i ModerationTerm
+	err := row.Scan(
+		&i.ID,
+		&i.Term,
+		&i.MatchMode,
+		&i.Action,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,76 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: rate_limits.sql
+
+package database
+
+import (
+	"context"
+)
+
+const deleteIdleRateLimitBuckets = `-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets
+WHERE updated_at < NOW() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteIdleRateLimitBuckets(ctx context.Context, idleSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteIdleRateLimitBuckets, idleSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getRateLimitTokens = `-- name: GetRateLimitTokens :one
+SELECT LEAST(
+    $1::float8,
+    tokens + EXTRACT(EPOCH FROM NOW() - updated_at) * $2::float8
+)::float8 AS tokens
+FROM rate_limit_buckets
+WHERE key = $3
+`
+
+type GetRateLimitTokensParams struct {
+	Capacity float64
+	Rate     float64
+	Key      string
+}
+
+func (q *Queries) GetRateLimitTokens(ctx context.Context, arg GetRateLimitTokensParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, getRateLimitTokens, arg.Capacity, arg.Rate, arg.Key)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS bucket (key, tokens, updated_at)
+VALUES ($1, $2::float8 - 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(
+        $2::float8,
+        bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at) * $3::float8
+    ) - 1,
+    updated_at = NOW()
+WHERE LEAST(
+    $2::float8,
+    bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at) * $3::float8
+) >= 1
+RETURNING tokens
+`
+
+type TakeRateLimitTokenParams struct {
+	Key      string
+	Capacity float64
+	Rate     float64
+}
+
+// Returns no row when the bucket doesn't have a whole token, which leaves it
+// untouched.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, takeRateLimitToken, arg.Key, arg.Capacity, arg.Rate)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
@@ -0,0 +1,79 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: rechirps.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createRechirp = `-- name: CreateRechirp :exec
+INSERT INTO rechirps (user_id, chirp_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type CreateRechirpParams struct {
+	UserID  uuid.UUID
+	ChirpID uuid.UUID
+}
+
+func (q *Queries) CreateRechirp(ctx context.Context, arg CreateRechirpParams) error {
+	_, err := q.db.ExecContext(ctx, createRechirp, arg.UserID, arg.ChirpID)
+	return err
+}
+
+const deleteRechirp = `-- name: DeleteRechirp :exec
+DELETE FROM rechirps
+WHERE user_id = $1
+AND chirp_id = $2
+`
+
+type DeleteRechirpParams struct {
+	UserID  uuid.UUID
+	ChirpID uuid.UUID
+}
+
+func (q *Queries) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) error {
+	_, err := q.db.ExecContext(ctx, deleteRechirp, arg.UserID, arg.ChirpID)
+	return err
+}
+
+const getRechirpCounts = `-- name: GetRechirpCounts :many
+SELECT chirp_id, COUNT(*) AS rechirp_count FROM rechirps
+WHERE chirp_id = ANY($1::uuid[])
+GROUP BY chirp_id
+`
+
+type GetRechirpCountsRow struct {
+	ChirpID      uuid.UUID
+	RechirpCount int64
+}
+
+func (q *Queries) GetRechirpCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetRechirpCountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRechirpCounts, pq.Array(chirpIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRechirpCountsRow
+	for rows.Next() {
+		var i GetRechirpCountsRow
+		if err := rows.Scan(&i.ChirpID, &i.RechirpCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -7,23 +7,31 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (user_id, token, expires_at)
-VALUES ($1, $2, NOW() + INTERVAL '60 days')
-RETURNING id, user_id, token, created_at, updated_at, expires_at, revoked_at
+INSERT INTO refresh_tokens (user_id, token, expires_at, family_id, user_agent, ip_address)
+VALUES ($1, $2, NOW() + INTERVAL '60 days', gen_random_uuid(), $3, $4)
+RETURNING id, user_id, token, created_at, updated_at, expires_at, revoked_at, family_id, parent_id, superseded_at, user_agent, ip_address, last_used_at
 `
 
 type CreateRefreshTokenParams struct {
-	UserID uuid.UUID
-	Token  string
+	UserID    uuid.UUID
+	Token     string
+	UserAgent string
+	IpAddress string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.UserID, arg.Token)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.UserID,
+		arg.Token,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.ID,
@@ -33,15 +41,111 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentID,
+		&i.SupersededAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const getActiveSessions = `-- name: GetActiveSessions :many
+SELECT refresh_tokens.family_id, refresh_tokens.user_agent, refresh_tokens.ip_address,
+    refresh_tokens.last_used_at, refresh_tokens.expires_at,
+    (
+        SELECT MIN(family.created_at) FROM refresh_tokens family
+        WHERE family.family_id = refresh_tokens.family_id
+    )::timestamp AS started_at
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = $1
+AND refresh_tokens.revoked_at IS NULL
+AND refresh_tokens.superseded_at IS NULL
+AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC
+`
+
+type GetActiveSessionsRow struct {
+	FamilyID   uuid.UUID
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	StartedAt  time.Time
+}
+
+// Only the newest token of a live family is neither revoked nor superseded.
+func (q *Queries) GetActiveSessions(ctx context.Context, userID uuid.UUID) ([]GetActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetActiveSessionsRow
+	for rows.Next() {
+		var i GetActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.StartedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshTokenForUpdate = `-- name: GetRefreshTokenForUpdate :one
+SELECT refresh_tokens.id, refresh_tokens.user_id, refresh_tokens.token, refresh_tokens.created_at, refresh_tokens.updated_at, refresh_tokens.expires_at, refresh_tokens.revoked_at, refresh_tokens.family_id, refresh_tokens.parent_id, refresh_tokens.superseded_at, refresh_tokens.user_agent, refresh_tokens.ip_address, refresh_tokens.last_used_at, (refresh_tokens.expires_at <= NOW())::boolean AS expired
+FROM refresh_tokens
+WHERE refresh_tokens.token = $1
+FOR UPDATE
+`
+
+type GetRefreshTokenForUpdateRow struct {
+	RefreshToken RefreshToken
+	Expired      bool
+}
+
+func (q *Queries) GetRefreshTokenForUpdate(ctx context.Context, token string) (GetRefreshTokenForUpdateRow, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenForUpdate, token)
+	var i GetRefreshTokenForUpdateRow
+	err := row.Scan(
+		&i.RefreshToken.ID,
+		&i.RefreshToken.UserID,
+		&i.RefreshToken.Token,
+		&i.RefreshToken.CreatedAt,
+		&i.RefreshToken.UpdatedAt,
+		&i.RefreshToken.ExpiresAt,
+		&i.RefreshToken.RevokedAt,
+		&i.RefreshToken.FamilyID,
+		&i.RefreshToken.ParentID,
+		&i.RefreshToken.SupersededAt,
+		&i.RefreshToken.UserAgent,
+		&i.RefreshToken.IpAddress,
+		&i.RefreshToken.LastUsedAt,
+		&i.Expired,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red FROM users
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red, users.username, users.suspended_until, users.role, users.totp_secret, users.totp_enabled_at, users.totp_last_step, users.email_verified_at, users.failed_login_count, users.locked_until FROM users
 JOIN refresh_tokens ON users.id = refresh_tokens.user_id
 WHERE refresh_tokens.token = $1
 AND revoked_at IS NULL
+AND superseded_at IS NULL
 AND expires_at > NOW()
 `
 
@@ -55,15 +159,68 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Us
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.SuspendedUntil,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
 	)
 	return i, err
 }
 
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL
+    AND superseded_at IS NULL
+    AND expires_at > NOW()
+)::boolean
+`
+
+type IsSessionActiveParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+// Access tokens carry their session's family ID; they only work while the
+// family's newest token is neither revoked nor expired.
+func (q *Queries) IsSessionActive(ctx context.Context, arg IsSessionActiveParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSessionActive, arg.FamilyID, arg.UserID)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const revokeOtherUserSessions = `-- name: RevokeOtherUserSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND ($2::uuid IS NULL OR family_id <> $2::uuid)
+AND revoked_at IS NULL
+`
+
+type RevokeOtherUserSessionsParams struct {
+	UserID       uuid.UUID
+	KeepFamilyID uuid.NullUUID
+}
+
+func (q *Queries) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherUserSessions, arg.UserID, arg.KeepFamilyID)
+	return err
+}
+
 const revokeRefreshToken = `-- name: RevokeRefreshToken :one
 UPDATE refresh_tokens SET revoked_at = NOW(),
 updated_at = NOW()
 WHERE token = $1
-RETURNING id, user_id, token, created_at, updated_at, expires_at, revoked_at
+RETURNING id, user_id, token, created_at, updated_at, expires_at, revoked_at, family_id, parent_id, superseded_at, user_agent, ip_address, last_used_at
 `
 
 func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
@@ -77,6 +234,103 @@ func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (Refresh
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentID,
+		&i.SupersededAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND family_id = $2
+AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+INSERT INTO refresh_tokens (user_id, token, expires_at, family_id, parent_id, user_agent, ip_address)
+SELECT parent.user_id, $1, parent.expires_at, parent.family_id, parent.id,
+    $2, $3
+FROM refresh_tokens parent
+WHERE parent.id = $4
+RETURNING id, user_id, token, created_at, updated_at, expires_at, revoked_at, family_id, parent_id, superseded_at, user_agent, ip_address, last_used_at
+`
+
+type RotateRefreshTokenParams struct {
+	Token     string
+	UserAgent string
+	IpAddress string
+	ParentID  uuid.UUID
+}
+
+// The new token keeps the family's expiry, so rotating doesn't extend a
+// session beyond its original 60 days.
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken,
+		arg.Token,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.ParentID,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ParentID,
+		&i.SupersededAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
+
+const supersedeRefreshToken = `-- name: SupersedeRefreshToken :exec
+UPDATE refresh_tokens
+SET superseded_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) SupersedeRefreshToken(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, supersedeRefreshToken, id)
+	return err
+}
@@ -0,0 +1,194 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: reports.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const createReport = `-- name: CreateReport :one
+INSERT INTO reports (chirp_id, reporter_id, reason, details)
+VALUES ($1, $2, $3, $4)
+RETURNING id, chirp_id, reporter_id, reason, details, status, resolution, resolved_at, created_at, updated_at
+`
+
+type CreateReportParams struct {
+	ChirpID    uuid.UUID
+	ReporterID uuid.NullUUID
+	Reason     string
+	Details    string
+}
+
+func (q *Queries) CreateReport(ctx context.Context, arg CreateReportParams) (Report, error) {
+	row := q.db.QueryRowContext(ctx, createReport,
+		arg.ChirpID,
+		arg.ReporterID,
+		arg.Reason,
+		arg.Details,
+	)
+	var i Report
+	err := row.Scan(
+		&i.ID,
+		&i.ChirpID,
+		&i.ReporterID,
+		&i.Reason,
+		&i.Details,
+		&i.Status,
+		&i.Resolution,
+		&i.ResolvedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getReportByID = `-- name: GetReportByID :one
+SELECT id, chirp_id, reporter_id, reason, details, status, resolution, resolved_at, created_at, updated_at FROM reports
+WHERE id = $1
+`
+
+func (q *Queries) GetReportByID(ctx context.Context, id uuid.UUID) (Report, error) {
+	row := q.db.QueryRowContext(ctx, getReportByID, id)
+	var i Report
+	err := row.Scan(
+		&i.ID,
+		&i.ChirpID,
+		&i.ReporterID,
+		&i.Reason,
+		&i.Details,
+		&i.Status,
+		&i.Resolution,
+		&i.ResolvedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getReportsPage = `-- name: GetReportsPage :many
+SELECT reports.id, reports.chirp_id, reports.reporter_id, reports.reason, reports.details, reports.status, reports.resolution, reports.resolved_at, reports.created_at, reports.updated_at, chirps.user_id AS author_id, chirps.body AS chirp_body,
+    chirps.hidden_at AS chirp_hidden_at, chirps.deleted_at AS chirp_deleted_at
+FROM reports
+JOIN chirps ON chirps.id = reports.chirp_id
+WHERE reports.status = $1
+AND (
+    $2::timestamp IS NULL
+    OR (reports.created_at, reports.id) > ($2::timestamp, $3::uuid)
+)
+ORDER BY reports.created_at ASC, reports.id ASC
+LIMIT $4
+`
+
+type GetReportsPageParams struct {
+	Status          string
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetReportsPageRow struct {
+	Report         Report
+	AuthorID       uuid.NullUUID
+	ChirpBody      string
+	ChirpHiddenAt  sql.NullTime
+	ChirpDeletedAt sql.NullTime
+}
+
+func (q *Queries) GetReportsPage(ctx context.Context, arg GetReportsPageParams) ([]GetReportsPageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getReportsPage,
+		arg.Status,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReportsPageRow
+	for rows.Next() {
+		var i GetReportsPageRow
+		if err := rows.Scan(
+			&i.Report.ID,
+			&i.Report.ChirpID,
+			&i.Report.ReporterID,
+			&i.Report.Reason,
+			&i.Report.Details,
+			&i.Report.Status,
+			&i.Report.Resolution,
+			&i.Report.ResolvedAt,
+			&i.Report.CreatedAt,
+			&i.Report.UpdatedAt,
+			&i.AuthorID,
+			&i.ChirpBody,
+			&i.ChirpHiddenAt,
+			&i.ChirpDeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resolveChirpReports = `-- name: ResolveChirpReports :many
+UPDATE reports
+SET status = 'resolved',
+    resolution = $1,
+    resolved_at = NOW(),
+    updated_at = NOW()
+WHERE chirp_id = $2
+AND status = 'open'
+RETURNING id, chirp_id, reporter_id, reason, details, status, resolution, resolved_at, created_at, updated_at
+`
+
+type ResolveChirpReportsParams struct {
+	Resolution sql.NullString
+	ChirpID    uuid.UUID
+}
+
+func (q *Queries) ResolveChirpReports(ctx context.Context, arg ResolveChirpReportsParams) ([]Report, error) {
+	rows, err := q.db.QueryContext(ctx, resolveChirpReports, arg.Resolution, arg.ChirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Report
+	for rows.Next() {
+		var i Report
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChirpID,
+			&i.ReporterID,
+			&i.Reason,
+			&i.Details,
+			&i.Status,
+			&i.Resolution,
+			&i.ResolvedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,172 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: scheduled_chirps.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const claimDueScheduledChirp = `-- name: ClaimDueScheduledChirp :one
+SELECT id, user_id, body, in_reply_to, publish_at, status, failure_reason, created_at, updated_at FROM scheduled_chirps
+WHERE status = 'scheduled' AND publish_at <= $1
+ORDER BY publish_at ASC, id ASC
+LIMIT 1
+FOR UPDATE SKIP LOCKED
+`
+
+// Locks the next chirp due for publishing. Other publishers skip it until
+// the transaction ends.
+func (q *Queries) ClaimDueScheduledChirp(ctx context.Context, now time.Time) (ScheduledChirp, error) {
+	row := q.db.QueryRowContext(ctx, claimDueScheduledChirp, now)
+	var i ScheduledChirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Body,
+		&i.InReplyTo,
+		&i.PublishAt,
+		&i.Status,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const countScheduledChirps = `-- name: CountScheduledChirps :one
+SELECT COUNT(*) FROM scheduled_chirps
+WHERE user_id = $1 AND status = 'scheduled'
+`
+
+func (q *Queries) CountScheduledChirps(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countScheduledChirps, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createScheduledChirp = `-- name: CreateScheduledChirp :one
+INSERT INTO scheduled_chirps (user_id, body, in_reply_to, publish_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, body, in_reply_to, publish_at, status, failure_reason, created_at, updated_at
+`
+
+type CreateScheduledChirpParams struct {
+	UserID    uuid.UUID
+	Body      string
+	InReplyTo uuid.NullUUID
+	PublishAt time.Time
+}
+
+func (q *Queries) CreateScheduledChirp(ctx context.Context, arg CreateScheduledChirpParams) (ScheduledChirp, error) {
+	row := q.db.QueryRowContext(ctx, createScheduledChirp,
+		arg.UserID,
+		arg.Body,
+		arg.InReplyTo,
+		arg.PublishAt,
+	)
+	var i ScheduledChirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Body,
+		&i.InReplyTo,
+		&i.PublishAt,
+		&i.Status,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deletePublishedScheduledChirp = `-- name: DeletePublishedScheduledChirp :exec
+DELETE FROM scheduled_chirps
+WHERE id = $1
+`
+
+func (q *Queries) DeletePublishedScheduledChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deletePublishedScheduledChirp, id)
+	return err
+}
+
+const deleteScheduledChirp = `-- name: DeleteScheduledChirp :execrows
+DELETE FROM scheduled_chirps
+WHERE id = $1 AND user_id = $2
+`
+
+type DeleteScheduledChirpParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) DeleteScheduledChirp(ctx context.Context, arg DeleteScheduledChirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteScheduledChirp, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const failScheduledChirp = `-- name: FailScheduledChirp :exec
+UPDATE scheduled_chirps
+SET status = 'failed',
+    failure_reason = $1,
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type FailScheduledChirpParams struct {
+	FailureReason string
+	ID            uuid.UUID
+}
+
+func (q *Queries) FailScheduledChirp(ctx context.Context, arg FailScheduledChirpParams) error {
+	_, err := q.db.ExecContext(ctx, failScheduledChirp, arg.FailureReason, arg.ID)
+	return err
+}
+
+const getScheduledChirps = `-- name: GetScheduledChirps :many
+SELECT id, user_id, body, in_reply_to, publish_at, status, failure_reason, created_at, updated_at FROM scheduled_chirps
+WHERE user_id = $1
+ORDER BY publish_at ASC, id ASC
+`
+
+func (q *Queries) GetScheduledChirps(ctx context.Context, userID uuid.UUID) ([]ScheduledChirp, error) {
+	rows, err := q.db.QueryContext(ctx, getScheduledChirps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ScheduledChirp
+	for rows.Next() {
+		var i ScheduledChirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Body,
+			&i.InReplyTo,
+			&i.PublishAt,
+			&i.Status,
+			&i.FailureReason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
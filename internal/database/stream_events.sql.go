@@ -0,0 +1,160 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: stream_events.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createStreamEvent = `-- name: CreateStreamEvent :one
+INSERT INTO stream_events (type, chirp_id, user_id, hashtags, payload)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`
+
+type CreateStreamEventParams struct {
+	Type     string
+	ChirpID  uuid.UUID
+	UserID   uuid.UUID
+	Hashtags []string
+	Payload  string
+}
+
+func (q *Queries) CreateStreamEvent(ctx context.Context, arg CreateStreamEventParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createStreamEvent,
+		arg.Type,
+		arg.ChirpID,
+		arg.UserID,
+		pq.Array(arg.Hashtags),
+		arg.Payload,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteStreamEventsBefore = `-- name: DeleteStreamEventsBefore :execrows
+DELETE FROM stream_events
+WHERE created_at < $1::timestamp
+`
+
+func (q *Queries) DeleteStreamEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStreamEventsBefore, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getLatestStreamEventID = `-- name: GetLatestStreamEventID :one
+SELECT COALESCE(MAX(id), 0)::bigint FROM stream_events
+`
+
+func (q *Queries) GetLatestStreamEventID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getLatestStreamEventID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getOldestStreamEventID = `-- name: GetOldestStreamEventID :one
+SELECT COALESCE(MIN(id), 0)::bigint FROM stream_events
+`
+
+func (q *Queries) GetOldestStreamEventID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getOldestStreamEventID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getStreamEventsAfter = `-- name: GetStreamEventsAfter :many
+SELECT id, type, chirp_id, user_id, hashtags, payload, created_at FROM stream_events
+WHERE id > $1
+AND (NOT $2::boolean OR user_id = ANY($3::uuid[]))
+AND ($4::text = '' OR $4::text = ANY(hashtags))
+ORDER BY id
+LIMIT $5
+`
+
+type GetStreamEventsAfterParams struct {
+	AfterID       int64
+	FilterAuthors bool
+	AuthorIds     []uuid.UUID
+	Hashtag       string
+	MaxEvents     int32
+}
+
+func (q *Queries) GetStreamEventsAfter(ctx context.Context, arg GetStreamEventsAfterParams) ([]StreamEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getStreamEventsAfter,
+		arg.AfterID,
+		arg.FilterAuthors,
+		pq.Array(arg.AuthorIds),
+		arg.Hashtag,
+		arg.MaxEvents,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StreamEvent
+	for rows.Next() {
+		var i StreamEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.ChirpID,
+			&i.UserID,
+			pq.Array(&i.Hashtags),
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockStreamEvents = `-- name: LockStreamEvents :exec
+SELECT pg_advisory_xact_lock(hashtext('stream_events'))
+`
+
+func (q *Queries) LockStreamEvents(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, lockStreamEvents)
+	return err
+}
+
+const notifyStreamEvent = `-- name: NotifyStreamEvent :exec
+SELECT pg_notify('stream_events', $1::bigint::text)
+`
+
+func (q *Queries) NotifyStreamEvent(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, notifyStreamEvent, id)
+	return err
+}
+
+const redactStreamEvents = `-- name: RedactStreamEvents :exec
+UPDATE stream_events
+SET payload = ''
+WHERE chirp_id = $1 AND payload <> ''
+`
+
+// Redacted events keep their id but have no payload.
+func (q *Queries) RedactStreamEvents(ctx context.Context, chirpID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, redactStreamEvents, chirpID)
+	return err
+}
@@ -0,0 +1,130 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: subscriptions.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const expireSubscriptions = `-- name: ExpireSubscriptions :many
+WITH expired AS (
+    UPDATE subscriptions
+    SET status = 'expired',
+        updated_at = NOW()
+    WHERE status <> 'expired' AND access_until <= $1
+    RETURNING user_id
+)
+UPDATE users
+SET is_chirpy_red = FALSE,
+    updated_at = NOW()
+FROM expired
+WHERE users.id = expired.user_id
+RETURNING users.id
+`
+
+// Ends access for subscriptions whose period and grace period are over, and
+// returns the users who lost Chirpy Red.
+func (q *Queries) ExpireSubscriptions(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, expireSubscriptions, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubscription = `-- name: GetSubscription :one
+SELECT user_id, status, current_period_end, access_until, created_at, updated_at FROM subscriptions
+WHERE user_id = $1
+`
+
+func (q *Queries) GetSubscription(ctx context.Context, userID uuid.UUID) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, getSubscription, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.UserID,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+		&i.AccessUntil,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSubscriptionForUpdate = `-- name: GetSubscriptionForUpdate :one
+SELECT user_id, status, current_period_end, access_until, created_at, updated_at FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetSubscriptionForUpdate(ctx context.Context, userID uuid.UUID) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, getSubscriptionForUpdate, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.UserID,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+		&i.AccessUntil,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, status, current_period_end, access_until)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE
+SET status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    access_until = EXCLUDED.access_until,
+    updated_at = NOW()
+RETURNING user_id, status, current_period_end, access_until, created_at, updated_at
+`
+
+type UpsertSubscriptionParams struct {
+	UserID           uuid.UUID
+	Status           string
+	CurrentPeriodEnd time.Time
+	AccessUntil      time.Time
+}
+
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, upsertSubscription,
+		arg.UserID,
+		arg.Status,
+		arg.CurrentPeriodEnd,
+		arg.AccessUntil,
+	)
+	var i Subscription
+	err := row.Scan(
+		&i.UserID,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+		&i.AccessUntil,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,229 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.25.0
+// source: webhook_events.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const getWebhookEventByID = `-- name: GetWebhookEventByID :one
+SELECT id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at FROM webhook_events
+WHERE id = $1
+`
+
+func (q *Queries) GetWebhookEventByID(ctx context.Context, id uuid.UUID) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookEventByID, id)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Deliveries,
+		&i.ProcessedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getWebhookEventForUpdate = `-- name: GetWebhookEventForUpdate :one
+SELECT id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at FROM webhook_events
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetWebhookEventForUpdate(ctx context.Context, id uuid.UUID) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookEventForUpdate, id)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Deliveries,
+		&i.ProcessedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getWebhookEventsPage = `-- name: GetWebhookEventsPage :many
+SELECT id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at FROM webhook_events
+WHERE ($1::text IS NULL OR status = $1)
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type GetWebhookEventsPageParams struct {
+	Status          sql.NullString
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetWebhookEventsPage(ctx context.Context, arg GetWebhookEventsPageParams) ([]WebhookEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getWebhookEventsPage,
+		arg.Status,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEvent
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.Source,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Error,
+			&i.Deliveries,
+			&i.ProcessedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markWebhookEventDone = `-- name: MarkWebhookEventDone :one
+UPDATE webhook_events
+SET status = $1,
+    error = '',
+    processed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at
+`
+
+type MarkWebhookEventDoneParams struct {
+	Status string
+	ID     uuid.UUID
+}
+
+func (q *Queries) MarkWebhookEventDone(ctx context.Context, arg MarkWebhookEventDoneParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, markWebhookEventDone, arg.Status, arg.ID)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Deliveries,
+		&i.ProcessedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markWebhookEventFailed = `-- name: MarkWebhookEventFailed :one
+UPDATE webhook_events
+SET status = 'failed',
+    error = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at
+`
+
+type MarkWebhookEventFailedParams struct {
+	Error string
+	ID    uuid.UUID
+}
+
+func (q *Queries) MarkWebhookEventFailed(ctx context.Context, arg MarkWebhookEventFailedParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, markWebhookEventFailed, arg.Error, arg.ID)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Deliveries,
+		&i.ProcessedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const recordWebhookEvent = `-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (source, event_id, event_type, payload)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (source, event_id) DO UPDATE
+SET deliveries = webhook_events.deliveries + 1,
+    updated_at = NOW()
+RETURNING id, source, event_id, event_type, payload, status, error, deliveries, processed_at, created_at, updated_at
+`
+
+type RecordWebhookEventParams struct {
+	Source    string
+	EventID   string
+	EventType string
+	Payload   string
+}
+
+// Stores an incoming event, or counts another delivery of one already
+// stored. The payload of the first delivery is kept.
+func (q *Queries) RecordWebhookEvent(ctx context.Context, arg RecordWebhookEventParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, recordWebhookEvent,
+		arg.Source,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Deliveries,
+		&i.ProcessedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
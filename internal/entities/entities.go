@@ -0,0 +1,102 @@
+// Package entities finds #hashtags and @mentions in chirp bodies.
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxHashtagLength  = 100
+	MaxUsernameLength = 30
+)
+
+// Entity is a hashtag or mention found in a body. Offsets count Unicode code
+// points, not bytes: Start points at the # or @ and End just past the entity.
+type Entity struct {
+	// Text is the normalized name without its # or @ prefix.
+	Text  string
+	Start int
+	End   int
+}
+
+// Hashtags returns the hashtags in body in order of appearance. A hashtag is
+// a # followed by letters, digits or underscores, at least one of which is a
+// letter, and is normalized to lowercase.
+func Hashtags(body string) []Entity {
+	return extract(body, '#', isHashtagRune, MaxHashtagLength)
+}
+
+// Mentions returns the @mentions in body in order of appearance, normalized
+// to lowercase. Whether the username exists is up to the caller.
+func Mentions(body string) []Entity {
+	return extract(body, '@', isUsernameRune, MaxUsernameLength)
+}
+
+// ValidUsername reports whether name can be mentioned as a whole, so it is
+// also the rule for choosing a username.
+func ValidUsername(name string) bool {
+	if len(name) < 3 || len(name) > MaxUsernameLength {
+		return false
+	}
+	for _, r := range name {
+		if !isUsernameRune(r) || unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func extract(body string, prefix rune, valid func(rune) bool, maxLength int) []Entity {
+	runes := []rune(body)
+	found := []Entity{}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != prefix {
+			continue
+		}
+		// Skip things like emails and URL fragments glued to a word
+		if i > 0 && (isWordRune(runes[i-1]) || runes[i-1] == prefix) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && valid(runes[end]) {
+			end++
+		}
+		name := string(runes[i+1 : end])
+		if name == "" || end-i-1 > maxLength || (prefix == '#' && !containsLetter(name)) {
+			i = end - 1
+			continue
+		}
+
+		found = append(found, Entity{
+			Text:  strings.ToLower(name),
+			Start: i,
+			End:   end,
+		})
+		i = end - 1
+	}
+	return found
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isHashtagRune(r rune) bool {
+	return isWordRune(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isUsernameRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
+
+func containsLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
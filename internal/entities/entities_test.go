@@ -0,0 +1,112 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{
+			name: "Single hashtag",
+			body: "I love #Golang",
+			want: []Entity{{Text: "golang", Start: 7, End: 14}},
+		},
+		{
+			name: "Several hashtags with punctuation",
+			body: "#go, #rust! and #zig.",
+			want: []Entity{
+				{Text: "go", Start: 0, End: 3},
+				{Text: "rust", Start: 5, End: 10},
+				{Text: "zig", Start: 16, End: 20},
+			},
+		},
+		{
+			name: "Offsets count code points",
+			body: "café #crème",
+			want: []Entity{{Text: "crème", Start: 5, End: 11}},
+		},
+		{
+			name: "Numbers only are not hashtags",
+			body: "issue #42",
+			want: []Entity{},
+		},
+		{
+			name: "Hash inside a word is ignored",
+			body: "C#sharp and x#y",
+			want: []Entity{},
+		},
+		{
+			name: "Bare hash",
+			body: "# heading",
+			want: []Entity{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Hashtags(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Hashtags(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{
+			name: "Single mention",
+			body: "hello @Alice_01!",
+			want: []Entity{{Text: "alice_01", Start: 6, End: 15}},
+		},
+		{
+			name: "Emails are not mentions",
+			body: "mail me at bob@example.com",
+			want: []Entity{},
+		},
+		{
+			name: "Double at is ignored",
+			body: "@@bob",
+			want: []Entity{},
+		},
+		{
+			name: "Too long",
+			body: "@abcdefghijklmnopqrstuvwxyz0123456789",
+			want: []Entity{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Mentions(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Mentions(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidUsername(t *testing.T) {
+	valid := []string{"bob", "alice_01", "abcdefghijklmnopqrstuvwxyz0123"}
+	for _, name := range valid {
+		if !ValidUsername(name) {
+			t.Errorf("ValidUsername(%q) = false, want true", name)
+		}
+	}
+
+	invalid := []string{"", "bo", "Bob", "bob smith", "bob!", "crème", "abcdefghijklmnopqrstuvwxyz01234"}
+	for _, name := range invalid {
+		if ValidUsername(name) {
+			t.Errorf("ValidUsername(%q) = true, want false", name)
+		}
+	}
+}
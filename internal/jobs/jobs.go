@@ -0,0 +1,368 @@
+// Package jobs runs background work from a queue kept in Postgres. Jobs are
+// rows in the jobs table, so they can be enqueued in the same transaction as
+// the change that calls for them and survive restarts, and any number of
+// instances can run workers against the same table.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+const (
+	// Attempts a job gets before it's marked failed
+	DefaultMaxAttempts = 5
+	// Failed attempts are retried after retryBaseDelay, doubling each time
+	// up to maxRetryDelay
+	retryBaseDelay = 10 * time.Second
+	maxRetryDelay  = time.Hour
+	// How long a worker waits when there is nothing to do
+	pollInterval = time.Second
+	// How long a claimed job is locked for. Workers renew the lock while
+	// the job runs; once it runs out the job is taken to be abandoned and
+	// can be claimed again.
+	lockDuration = time.Minute
+	// How long results are given to be recorded, even during shutdown
+	recordTimeout = 10 * time.Second
+	// Finished jobs are deleted after these
+	succeededRetention = 24 * time.Hour
+	failedRetention    = 30 * 24 * time.Hour
+)
+
+// PruneKind is the recurring job that deletes old finished jobs.
+const PruneKind = "jobs.prune"
+
+// Job is a claimed job as handlers see it.
+type Job struct {
+	ID      uuid.UUID
+	Kind    string
+	Payload json.RawMessage
+	// 1 on the first run
+	Attempt     int
+	MaxAttempts int
+	// When the job was due
+	RunAt time.Time
+}
+
+// Decode unmarshals the job's payload into v.
+func (j Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job. Returning an error retries the job later, until it
+// runs out of attempts. ctx is cancelled if the runner is shut down before
+// the job finishes.
+type Handler func(ctx context.Context, job Job) error
+
+// Enqueue adds a job to run as soon as a worker is free. Pass the queries
+// of a transaction (see Queries.WithTx) to only enqueue the job if the
+// transaction commits.
+func Enqueue(ctx context.Context, q *database.Queries, kind string, payload any) error {
+	return EnqueueAt(ctx, q, kind, payload, time.Now())
+}
+
+// EnqueueAt adds a job to run at runAt.
+func EnqueueAt(ctx context.Context, q *database.Queries, kind string, payload any, runAt time.Time) error {
+	return enqueue(ctx, q, kind, payload, runAt, DefaultMaxAttempts, sql.NullString{})
+}
+
+func enqueue(ctx context.Context, db store, kind string, payload any, runAt time.Time, maxAttempts int, uniqueKey sql.NullString) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("couldn't encode payload of %s job: %w", kind, err)
+	}
+	return db.EnqueueJob(ctx, database.EnqueueJobParams{
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: int32(maxAttempts),
+		RunAt:       runAt.UTC(),
+		UniqueKey:   uniqueKey,
+	})
+}
+
+// store is the part of database.Queries the runner uses.
+type store interface {
+	EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) error
+	ClaimJob(ctx context.Context, arg database.ClaimJobParams) (database.Job, error)
+	ExtendJobLock(ctx context.Context, arg database.ExtendJobLockParams) error
+	CompleteJob(ctx context.Context, id uuid.UUID) error
+	RetryJob(ctx context.Context, arg database.RetryJobParams) error
+	FailJob(ctx context.Context, arg database.FailJobParams) error
+	DeleteFinishedJobs(ctx context.Context, arg database.DeleteFinishedJobsParams) (int64, error)
+}
+
+type recurringJob struct {
+	kind     string
+	interval time.Duration
+	// The last occurrence this runner enqueued
+	scheduled time.Time
+}
+
+// Runner claims jobs of the kinds it has handlers for and runs them on a
+// fixed number of workers.
+type Runner struct {
+	db        store
+	workers   int
+	handlers  map[string]Handler
+	kinds     []string
+	recurring []*recurringJob
+
+	stop       chan struct{}
+	cancelJobs context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewRunner returns a runner with the given number of workers. Register
+// handlers with Handle and Every, then call Start.
+func NewRunner(db *database.Queries, workers int) *Runner {
+	return newRunner(db, workers)
+}
+
+func newRunner(db store, workers int) *Runner {
+	r := &Runner{
+		db:       db,
+		workers:  max(workers, 1),
+		handlers: map[string]Handler{},
+	}
+	r.Every(PruneKind, time.Hour, r.prune)
+	return r
+}
+
+// Handle registers the handler for jobs of kind.
+func (r *Runner) Handle(kind string, h Handler) {
+	r.handlers[kind] = h
+	r.kinds = append(r.kinds, kind)
+	sort.Strings(r.kinds)
+}
+
+// Every registers a job that runs once per interval, at multiples of
+// interval since the Unix epoch. However many instances run it, each
+// occurrence runs once. Occurrences aren't retried, since the next one
+// follows soon enough.
+func (r *Runner) Every(kind string, interval time.Duration, h Handler) {
+	r.Handle(kind, h)
+	r.recurring = append(r.recurring, &recurringJob{kind: kind, interval: interval})
+}
+
+// Start runs the workers and the scheduler for recurring jobs in the
+// background until Shutdown.
+func (r *Runner) Start() {
+	r.stop = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancelJobs = cancel
+
+	r.wg.Add(r.workers + 1)
+	for i := 0; i < r.workers; i++ {
+		go r.work(ctx)
+	}
+	go r.schedule(ctx)
+}
+
+// Shutdown stops claiming jobs and waits for running ones to finish. If ctx
+// ends first, running jobs are cancelled; they are retried once their lock
+// runs out.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	close(r.stop)
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		r.cancelJobs()
+		return nil
+	case <-ctx.Done():
+		r.cancelJobs()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) work(ctx context.Context) {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		ran, err := r.runNext(ctx)
+		if err != nil {
+			log.Printf("Couldn't run job: %v", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runNext claims and runs one job, and returns false if none was due.
+func (r *Runner) runNext(ctx context.Context) (bool, error) {
+	now := time.Now().UTC()
+	claimed, err := r.db.ClaimJob(ctx, database.ClaimJobParams{
+		LockedUntil: now.Add(lockDuration),
+		Kinds:       r.kinds,
+		Now:         now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	job := Job{
+		ID:          claimed.ID,
+		Kind:        claimed.Kind,
+		Payload:     claimed.Payload,
+		Attempt:     int(claimed.Attempts),
+		MaxAttempts: int(claimed.MaxAttempts),
+		RunAt:       claimed.RunAt,
+	}
+
+	stopRenewing := r.renewLock(ctx, job.ID)
+	err = run(ctx, r.handlers[job.Kind], job)
+	stopRenewing()
+
+	return true, r.record(job, err)
+}
+
+// renewLock keeps extending the lock on a running job until the returned
+// function is called.
+func (r *Runner) renewLock(ctx context.Context, id uuid.UUID) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := r.db.ExtendJobLock(ctx, database.ExtendJobLockParams{
+					ID:          id,
+					LockedUntil: time.Now().UTC().Add(lockDuration),
+				})
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Couldn't renew lock on job %s: %v", id, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// run calls h, turning panics into errors so one bad job can't take the
+// worker down.
+func run(ctx context.Context, h Handler, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// record stores the outcome of a job. It has its own context so results
+// are recorded even when the runner is shutting down.
+func (r *Runner) record(job Job, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	if jobErr == nil {
+		return r.db.CompleteJob(ctx, job.ID)
+	}
+
+	log.Printf("Job %s (%s) failed on attempt %d of %d: %v", job.ID, job.Kind, job.Attempt, job.MaxAttempts, jobErr)
+	if job.Attempt >= job.MaxAttempts {
+		return r.db.FailJob(ctx, database.FailJobParams{
+			ID:        job.ID,
+			LastError: jobErr.Error(),
+		})
+	}
+	return r.db.RetryJob(ctx, database.RetryJobParams{
+		ID:        job.ID,
+		RunAt:     time.Now().UTC().Add(retryDelay(job.Attempt)),
+		LastError: jobErr.Error(),
+	})
+}
+
+// retryDelay is how long to wait after a job's attempt-th failed attempt.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// schedule enqueues the next occurrence of each recurring job. Every
+// instance does this; the unique key leaves one row per occurrence.
+func (r *Runner) schedule(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, job := range r.recurring {
+			next := nextOccurrence(time.Now(), job.interval)
+			if next.Equal(job.scheduled) {
+				continue
+			}
+			key := sql.NullString{String: occurrenceKey(job.kind, next), Valid: true}
+			if err := enqueue(ctx, r.db, job.kind, nil, next, 1, key); err != nil {
+				log.Printf("Couldn't schedule %s job: %v", job.kind, err)
+				continue
+			}
+			job.scheduled = next
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// nextOccurrence returns the first multiple of interval since the Unix
+// epoch after now.
+func nextOccurrence(now time.Time, interval time.Duration) time.Time {
+	return now.UTC().Truncate(interval).Add(interval)
+}
+
+func occurrenceKey(kind string, at time.Time) string {
+	return fmt.Sprintf("%s@%d", kind, at.UnixNano())
+}
+
+func (r *Runner) prune(ctx context.Context, job Job) error {
+	now := time.Now().UTC()
+	_, err := r.db.DeleteFinishedJobs(ctx, database.DeleteFinishedJobsParams{
+		SucceededBefore: now.Add(-succeededRetention),
+		FailedBefore:    now.Add(-failedRetention),
+	})
+	return err
+}
@@ -0,0 +1,370 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// fakeStore keeps jobs in memory with the same claim rules as the queries.
+type fakeStore struct {
+	mu   sync.Mutex
+	jobs []*database.Job
+}
+
+func (s *fakeStore) EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if arg.UniqueKey.Valid && job.UniqueKey == arg.UniqueKey {
+			return nil
+		}
+	}
+	s.jobs = append(s.jobs, &database.Job{
+		ID:          uuid.New(),
+		Kind:        arg.Kind,
+		Payload:     arg.Payload,
+		Status:      "pending",
+		MaxAttempts: arg.MaxAttempts,
+		RunAt:       arg.RunAt,
+		UniqueKey:   arg.UniqueKey,
+	})
+	return nil
+}
+
+func (s *fakeStore) ClaimJob(ctx context.Context, arg database.ClaimJobParams) (database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.Status == "pending" && !job.RunAt.After(arg.Now) && slices.Contains(arg.Kinds, job.Kind) {
+			job.Status = "running"
+			job.Attempts++
+			job.LockedUntil = sql.NullTime{Time: arg.LockedUntil, Valid: true}
+			return *job, nil
+		}
+	}
+	return database.Job{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) ExtendJobLock(ctx context.Context, arg database.ExtendJobLockParams) error {
+	return nil
+}
+
+func (s *fakeStore) CompleteJob(ctx context.Context, id uuid.UUID) error {
+	return s.update(id, func(job *database.Job) { job.Status = "succeeded" })
+}
+
+func (s *fakeStore) RetryJob(ctx context.Context, arg database.RetryJobParams) error {
+	return s.update(arg.ID, func(job *database.Job) {
+		job.Status = "pending"
+		job.RunAt = arg.RunAt
+		job.LastError = arg.LastError
+	})
+}
+
+func (s *fakeStore) FailJob(ctx context.Context, arg database.FailJobParams) error {
+	return s.update(arg.ID, func(job *database.Job) {
+		job.Status = "failed"
+		job.LastError = arg.LastError
+	})
+}
+
+func (s *fakeStore) DeleteFinishedJobs(ctx context.Context, arg database.DeleteFinishedJobsParams) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) update(id uuid.UUID, apply func(*database.Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.ID == id {
+			apply(job)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// job returns a copy of the only job of kind.
+func (s *fakeStore) job(t *testing.T, kind string) database.Job {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.Kind == kind {
+			return *job
+		}
+	}
+	t.Fatalf("No %s job", kind)
+	return database.Job{}
+}
+
+// makeDue moves every pending job's run time into the past.
+func (s *fakeStore) makeDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		job.RunAt = time.Now().UTC().Add(-time.Second)
+	}
+}
+
+func TestRunnerRunsJob(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 1)
+
+	type payload struct {
+		UserID string `json:"user_id"`
+	}
+	var got payload
+	runner.Handle("email.send", func(ctx context.Context, job Job) error {
+		return job.Decode(&got)
+	})
+
+	ctx := context.Background()
+	if err := enqueue(ctx, store, "email.send", payload{UserID: "42"}, time.Now(), DefaultMaxAttempts, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+
+	ran, err := runner.runNext(ctx)
+	if err != nil || !ran {
+		t.Fatalf("runNext() = %v, %v, want true, nil", ran, err)
+	}
+	if got.UserID != "42" {
+		t.Errorf("Handler got payload %+v, want user_id 42", got)
+	}
+	if status := store.job(t, "email.send").Status; status != "succeeded" {
+		t.Errorf("Job status = %q, want succeeded", status)
+	}
+
+	ran, err = runner.runNext(ctx)
+	if err != nil || ran {
+		t.Fatalf("runNext() with nothing due = %v, %v, want false, nil", ran, err)
+	}
+}
+
+func TestRunnerRetriesThenFails(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 1)
+
+	calls := 0
+	runner.Handle("flaky", func(ctx context.Context, job Job) error {
+		calls++
+		if job.Attempt != calls {
+			t.Errorf("Attempt = %d on call %d", job.Attempt, calls)
+		}
+		return errors.New("upstream unavailable")
+	})
+
+	ctx := context.Background()
+	if err := enqueue(ctx, store, "flaky", nil, time.Now(), 3, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		ran, err := runner.runNext(ctx)
+		if err != nil || !ran {
+			t.Fatalf("runNext() on attempt %d = %v, %v, want true, nil", attempt, ran, err)
+		}
+
+		job := store.job(t, "flaky")
+		if attempt < 3 {
+			if job.Status != "pending" {
+				t.Fatalf("Job status after attempt %d = %q, want pending", attempt, job.Status)
+			}
+			if !job.RunAt.After(time.Now()) {
+				t.Fatalf("Retry after attempt %d isn't delayed", attempt)
+			}
+			// A retry isn't claimed before it's due
+			if ran, _ := runner.runNext(ctx); ran {
+				t.Fatalf("Retry after attempt %d ran early", attempt)
+			}
+			store.makeDue()
+		} else if job.Status != "failed" || job.LastError != "upstream unavailable" {
+			t.Fatalf("Job after last attempt = %q (%q), want failed (upstream unavailable)", job.Status, job.LastError)
+		}
+	}
+}
+
+func TestRunnerRecoversFromPanics(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 1)
+	runner.Handle("broken", func(ctx context.Context, job Job) error {
+		panic("nil map")
+	})
+
+	ctx := context.Background()
+	if err := enqueue(ctx, store, "broken", nil, time.Now(), 1, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+	if _, err := runner.runNext(ctx); err != nil {
+		t.Fatalf("runNext returned error: %v", err)
+	}
+
+	job := store.job(t, "broken")
+	if job.Status != "failed" || job.LastError != "job panicked: nil map" {
+		t.Errorf("Job = %q (%q), want failed (job panicked: nil map)", job.Status, job.LastError)
+	}
+}
+
+func TestRunnerOnlyClaimsHandledKinds(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 1)
+
+	ctx := context.Background()
+	if err := enqueue(ctx, store, "someone.else", nil, time.Now(), 1, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+	if ran, err := runner.runNext(ctx); ran || err != nil {
+		t.Fatalf("runNext() = %v, %v, want false, nil", ran, err)
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 2)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	runner.Handle("slow", func(ctx context.Context, job Job) error {
+		close(started)
+		<-release
+		return ctx.Err()
+	})
+
+	if err := enqueue(context.Background(), store, "slow", nil, time.Now(), 1, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+	runner.Start()
+	<-started
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	if err := runner.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	if status := store.job(t, "slow").Status; status != "succeeded" {
+		t.Errorf("Job status after drain = %q, want succeeded", status)
+	}
+}
+
+func TestShutdownCancelsJobsAfterDeadline(t *testing.T) {
+	store := &fakeStore{}
+	runner := newRunner(store, 1)
+
+	started := make(chan struct{})
+	runner.Handle("stuck", func(ctx context.Context, job Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := enqueue(context.Background(), store, "stuck", nil, time.Now(), 2, sql.NullString{}); err != nil {
+		t.Fatalf("enqueue returned error: %v", err)
+	}
+	runner.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := runner.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The interrupted attempt is recorded, so the job is retried later
+	job := store.job(t, "stuck")
+	if job.Status != "pending" || job.LastError != context.Canceled.Error() {
+		t.Errorf("Job = %q (%q), want pending (%q)", job.Status, job.LastError, context.Canceled)
+	}
+}
+
+func TestRecurringJobsAreScheduledOnce(t *testing.T) {
+	store := &fakeStore{}
+	first := newRunner(store, 1)
+	second := newRunner(store, 1)
+	noop := func(ctx context.Context, job Job) error { return nil }
+	first.Every("subscriptions.expire", time.Minute, noop)
+	second.Every("subscriptions.expire", time.Minute, noop)
+
+	// Both instances schedule the same occurrence
+	for _, runner := range []*Runner{first, second} {
+		runner.stop = make(chan struct{})
+		close(runner.stop)
+		runner.wg.Add(1)
+		runner.schedule(context.Background())
+	}
+
+	count := 0
+	for _, job := range store.jobs {
+		if job.Kind == "subscriptions.expire" {
+			count++
+			if job.MaxAttempts != 1 {
+				t.Errorf("Recurring job has %d attempts, want 1", job.MaxAttempts)
+			}
+			if !job.RunAt.After(time.Now()) || job.RunAt.Truncate(time.Minute) != job.RunAt {
+				t.Errorf("Recurring job runs at %v, want the next whole minute", job.RunAt)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("Got %d scheduled occurrences, want 1", count)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempt); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	now := time.Date(2025, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	tests := []struct {
+		interval time.Duration
+		want     time.Time
+	}{
+		{15 * time.Second, time.Date(2025, 3, 14, 15, 9, 30, 0, time.UTC)},
+		{time.Minute, time.Date(2025, 3, 14, 15, 10, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2025, 3, 14, 16, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := nextOccurrence(now, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("nextOccurrence(%v, %v) = %v, want %v", now, tt.interval, got, tt.want)
+		}
+	}
+
+	// On the boundary the next occurrence is a whole interval away
+	boundary := time.Date(2025, 3, 14, 15, 10, 0, 0, time.UTC)
+	if got := nextOccurrence(boundary, time.Minute); !got.Equal(boundary.Add(time.Minute)) {
+		t.Errorf("nextOccurrence(%v, 1m) = %v, want %v", boundary, got, boundary.Add(time.Minute))
+	}
+}
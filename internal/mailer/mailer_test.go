@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageFormat(t *testing.T) {
+	msg := Message{
+		To:      "alice@example.com",
+		Subject: "Verify your email",
+		Body:    "Hello\nWorld",
+	}
+
+	formatted := string(msg.format("Chirpy <no-reply@chirpy.test>", time.Unix(0, 0).UTC()))
+
+	for _, want := range []string{
+		"From: Chirpy <no-reply@chirpy.test>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Verify your email\r\n",
+		"\r\n\r\nHello\r\nWorld\r\n",
+	} {
+		if !strings.Contains(formatted, want) {
+			t.Errorf("Formatted message is missing %q:\n%s", want, formatted)
+		}
+	}
+}
+
+func TestMessageRejectsHeaderInjection(t *testing.T) {
+	tests := []Message{
+		{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"},
+		{To: "alice@example.com", Subject: "Hi\nBcc: eve@example.com"},
+	}
+
+	mailer := NewMemoryMailer()
+	for _, msg := range tests {
+		if err := mailer.Send(context.Background(), msg); err != ErrInvalidHeader {
+			t.Errorf("Send(%q, %q) = %v, want ErrInvalidHeader", msg.To, msg.Subject, err)
+		}
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Fatal("Rejected messages shouldn't be recorded")
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+	first := Message{To: "alice@example.com", Subject: "One", Body: "1"}
+	second := Message{To: "bob@example.com", Subject: "Two", Body: "2"}
+
+	for _, msg := range []Message{first, second} {
+		if err := mailer.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 2 || messages[0] != first || messages[1] != second {
+		t.Fatalf("Messages() = %v, want [%v %v]", messages, first, second)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := NewFileMailer(dir, "no-reply@chirpy.test")
+	if err != nil {
+		t.Fatalf("NewFileMailer returned error: %v", err)
+	}
+
+	msg := Message{To: "alice@example.com", Subject: "Reset your password", Body: "token"}
+	if err := mailer.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one .eml file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Couldn't read message: %v", err)
+	}
+	if !strings.Contains(string(data), "Subject: Reset your password\r\n") {
+		t.Fatalf("Message file is missing the subject:\n%s", data)
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go serveOneSMTPMessage(listener, received)
+
+	mailer, err := NewSMTPMailer(listener.Addr().String(), "no-reply@chirpy.test", "", "")
+	if err != nil {
+		t.Fatalf("NewSMTPMailer returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg := Message{To: "alice@example.com", Subject: "Verify your email", Body: "token"}
+	if err := mailer.Send(ctx, msg); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "To: alice@example.com\r\n") || !strings.Contains(data, "\r\ntoken\r\n") {
+		t.Fatalf("Server received an unexpected message:\n%s", data)
+	}
+}
+
+// serveOneSMTPMessage plays the server side of a minimal SMTP conversation
+// and reports the message data it received.
+func serveOneSMTPMessage(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 Go ahead")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			received <- data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Package moderation checks text against a list of banned terms, seeing
+// through the usual tricks for slipping a word past a filter: accents and
+// look-alike letters, leetspeak, punctuation between letters and repeated
+// letters.
+package moderation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MatchMode controls where in the text a term may match.
+type MatchMode string
+
+const (
+	// MatchWholeWord only matches a term that makes up a whole word.
+	MatchWholeWord MatchMode = "whole_word"
+	// MatchSubstring matches a term anywhere, including inside other words.
+	MatchSubstring MatchMode = "substring"
+)
+
+// Action is what happens to text that contains a term.
+type Action string
+
+const (
+	// ActionMask replaces the term with asterisks.
+	ActionMask Action = "mask"
+	// ActionReject refuses the text altogether.
+	ActionReject Action = "reject"
+	// ActionFlag lets the text through unchanged but marks it for review.
+	ActionFlag Action = "flag"
+)
+
+// Mask replaces every masked term.
+const Mask = "****"
+
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch MatchMode(s) {
+	case MatchWholeWord, MatchSubstring:
+		return MatchMode(s), nil
+	}
+	return "", fmt.Errorf("invalid match mode %q", s)
+}
+
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case ActionMask, ActionReject, ActionFlag:
+		return Action(s), nil
+	}
+	return "", fmt.Errorf("invalid action %q", s)
+}
+
+type Term struct {
+	Term   string
+	Mode   MatchMode
+	Action Action
+}
+
+// Match is one occurrence of a term. Start and End are byte offsets into
+// the checked text and cover any punctuation inserted into the term.
+type Match struct {
+	Term  Term
+	Start int
+	End   int
+}
+
+type Result struct {
+	// Text is the checked text with every masked term replaced by Mask.
+	Text     string
+	Matches  []Match
+	Rejected bool
+	Flagged  bool
+}
+
+// Filter is safe for concurrent use, including replacing its terms while
+// other goroutines check text.
+type Filter struct {
+	mu    sync.RWMutex
+	terms []compiledTerm
+}
+
+func NewFilter(terms []Term) *Filter {
+	f := &Filter{}
+	f.SetTerms(terms)
+	return f
+}
+
+// SetTerms replaces the filter's terms. Terms with nothing to match after
+// normalization are ignored.
+func (f *Filter) SetTerms(terms []Term) {
+	compiled := make([]compiledTerm, 0, len(terms))
+	for _, term := range terms {
+		runes := normalizeTerm(term.Term)
+		if len(runes) == 0 {
+			continue
+		}
+		compiled = append(compiled, compiledTerm{Term: term, runes: runes})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terms = compiled
+}
+
+func (f *Filter) Check(text string) Result {
+	f.mu.RLock()
+	terms := f.terms
+	f.mu.RUnlock()
+
+	chars := normalizeText(text)
+	result := Result{Text: text}
+
+	for _, term := range terms {
+		for start := 0; start < len(chars); start++ {
+			if term.Mode == MatchWholeWord && !chars[start].boundaryBefore && start > 0 {
+				continue
+			}
+			end := term.matchAt(chars, start)
+			if end < 0 {
+				continue
+			}
+			result.Matches = append(result.Matches, Match{
+				Term:  term.Term,
+				Start: chars[start].start,
+				End:   chars[end-1].end,
+			})
+			start = end - 1
+		}
+	}
+
+	masked := []Match{}
+	for _, match := range result.Matches {
+		switch match.Term.Action {
+		case ActionReject:
+			result.Rejected = true
+		case ActionFlag:
+			result.Flagged = true
+		case ActionMask:
+			masked = append(masked, match)
+		}
+	}
+	result.Text = mask(text, masked)
+
+	return result
+}
+
+// mask replaces the text covered by matches, merging overlapping matches
+// so that each masked stretch becomes a single Mask.
+func mask(text string, matches []Match) string {
+	if len(matches) == 0 {
+		return text
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Start < matches[j].Start
+	})
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(matches); i++ {
+		start, end := matches[i].Start, matches[i].End
+		for i+1 < len(matches) && matches[i+1].Start < end {
+			i++
+			end = max(end, matches[i].End)
+		}
+		if start < last {
+			start = last
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(Mask)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+type compiledTerm struct {
+	Term
+	runes []rune
+}
+
+// matchAt returns the index just past a match of the term beginning at
+// chars[start], or -1. Every rune of the term must match at least one char
+// and may repeat over any number of further chars, so "kerfuffle" also
+// matches "kerrrfuffle". Matches never span whitespace.
+func (t compiledTerm) matchAt(chars []normalizedChar, start int) int {
+	failed := make(map[[2]int]bool)
+
+	var match func(pos, ti int) int
+	match = func(pos, ti int) int {
+		if ti == len(t.runes) {
+			if t.Mode == MatchWholeWord && pos < len(chars) && !chars[pos].boundaryBefore {
+				return -1
+			}
+			return pos
+		}
+		if pos >= len(chars) || !chars[pos].matches(t.runes[ti]) {
+			return -1
+		}
+		if pos > start && chars[pos].spaceBefore {
+			return -1
+		}
+		if failed[[2]int{pos, ti}] {
+			return -1
+		}
+
+		if end := match(pos+1, ti+1); end >= 0 {
+			return end
+		}
+		// Let the same rune of the term absorb a repeated letter
+		if end := match(pos+1, ti); end >= 0 {
+			return end
+		}
+
+		failed[[2]int{pos, ti}] = true
+		return -1
+	}
+
+	return match(start, 0)
+}
+
+// normalizedChar is a letter or digit of the checked text after
+// normalization, remembering where it came from.
+type normalizedChar struct {
+	// candidates lists every rune the original character could stand for,
+	// so "1" may be an "i" or an "l"
+	candidates string
+	// start and end are byte offsets of the original character
+	start int
+	end   int
+	// spaceBefore is set when whitespace separates this char from the
+	// previous one, boundaryBefore when whitespace or punctuation does
+	spaceBefore    bool
+	boundaryBefore bool
+}
+
+func (c normalizedChar) matches(r rune) bool {
+	return strings.ContainsRune(c.candidates, r)
+}
+
+// leetspeak maps characters commonly used in place of letters to the
+// letters they stand for.
+var leetspeak = map[rune]string{
+	'0': "o",
+	'1': "il",
+	'3': "e",
+	'4': "a",
+	'5': "s",
+	'7': "t",
+	'@': "a",
+	'$': "s",
+}
+
+// confusables maps letters from other scripts that look like Latin ones.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'ѕ': 's',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// foldRune reduces a single character to lowercase Latin base letters,
+// dropping accents and compatibility forms such as full-width letters.
+func foldRune(r rune) []rune {
+	folded := []rune{}
+	for _, d := range norm.NFKD.String(string(r)) {
+		if unicode.Is(unicode.Mn, d) {
+			continue
+		}
+		d = unicode.ToLower(d)
+		if c, ok := confusables[d]; ok {
+			d = c
+		}
+		folded = append(folded, d)
+	}
+	return folded
+}
+
+// ValidTerm reports whether term has any letters or digits left to match
+// once normalized.
+func ValidTerm(term string) bool {
+	return len(normalizeTerm(term)) > 0
+}
+
+func normalizeTerm(term string) []rune {
+	runes := []rune{}
+	for _, r := range term {
+		for _, f := range foldRune(r) {
+			if unicode.IsLetter(f) || unicode.IsDigit(f) {
+				runes = append(runes, f)
+			}
+		}
+	}
+	return runes
+}
+
+func normalizeText(text string) []normalizedChar {
+	chars := []normalizedChar{}
+	spaceBefore, boundaryBefore := false, false
+
+	for start, r := range text {
+		end := start + len(string(r))
+
+		if unicode.IsSpace(r) {
+			spaceBefore, boundaryBefore = true, true
+			continue
+		}
+
+		for _, f := range foldRune(r) {
+			candidates, isLeet := leetspeak[f]
+			switch {
+			case isLeet:
+				candidates = string(f) + candidates
+			case unicode.IsLetter(f) || unicode.IsDigit(f):
+				candidates = string(f)
+			case unicode.Is(unicode.Cf, f):
+				// Zero-width characters hide between letters
+				continue
+			default:
+				boundaryBefore = true
+				continue
+			}
+
+			chars = append(chars, normalizedChar{
+				candidates:     candidates,
+				start:          start,
+				end:            end,
+				spaceBefore:    spaceBefore,
+				boundaryBefore: boundaryBefore,
+			})
+			spaceBefore, boundaryBefore = false, false
+		}
+	}
+	return chars
+}
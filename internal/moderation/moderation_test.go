@@ -0,0 +1,204 @@
+package moderation
+
+import "testing"
+
+func TestCheckMasking(t *testing.T) {
+	filter := NewFilter([]Term{
+		{Term: "kerfuffle", Mode: MatchSubstring, Action: ActionMask},
+		{Term: "fornax", Mode: MatchWholeWord, Action: ActionMask},
+	})
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "Clean text",
+			text: "What a lovely day",
+			want: "What a lovely day",
+		},
+		{
+			name: "Plain term",
+			text: "This is a kerfuffle opinion",
+			want: "This is a **** opinion",
+		},
+		{
+			name: "Mixed case",
+			text: "This is a KerFuffle opinion",
+			want: "This is a **** opinion",
+		},
+		{
+			name: "Leetspeak",
+			text: "what a k3rfuff1e",
+			want: "what a ****",
+		},
+		{
+			name: "Inserted punctuation",
+			text: "what a k.e.r-f_u*f*f*l.e!",
+			want: "what a ****!",
+		},
+		{
+			name: "Accents and full-width letters",
+			text: "what a kérfüｆｆle",
+			want: "what a ****",
+		},
+		{
+			name: "Look-alike Cyrillic letters",
+			text: "what a kеrfuffle",
+			want: "what a ****",
+		},
+		{
+			name: "Zero-width characters",
+			text: "what a ker​fuffle",
+			want: "what a ****",
+		},
+		{
+			name: "Repeated letters",
+			text: "what a kerrrfuuuffle",
+			want: "what a ****",
+		},
+		{
+			name: "Substring inside a word",
+			text: "kerfuffles everywhere",
+			want: "****s everywhere",
+		},
+		{
+			name: "Whole word only",
+			text: "fornax fornaxes",
+			want: "**** fornaxes",
+		},
+		{
+			name: "Whole word next to punctuation",
+			text: "(fornax), f0rnax.",
+			want: "(****), ****.",
+		},
+		{
+			name: "Spaced out letters are separate words",
+			text: "k e r f u f f l e",
+			want: "k e r f u f f l e",
+		},
+		{
+			name: "Missing doubled letter",
+			text: "kerfufle",
+			want: "kerfufle",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filter.Check(tt.text)
+			if got.Text != tt.want {
+				t.Fatalf("Check(%q).Text = %q, want %q", tt.text, got.Text, tt.want)
+			}
+			if got.Rejected || got.Flagged {
+				t.Fatalf("Check(%q) rejected or flagged a masked term", tt.text)
+			}
+		})
+	}
+}
+
+func TestCheckActions(t *testing.T) {
+	filter := NewFilter([]Term{
+		{Term: "sharbert", Mode: MatchSubstring, Action: ActionMask},
+		{Term: "spam", Mode: MatchWholeWord, Action: ActionReject},
+		{Term: "scam", Mode: MatchWholeWord, Action: ActionFlag},
+	})
+
+	tests := []struct {
+		name         string
+		text         string
+		wantText     string
+		wantRejected bool
+		wantFlagged  bool
+		wantMatches  int
+	}{
+		{
+			name:        "Mask only",
+			text:        "sharbert",
+			wantText:    "****",
+			wantMatches: 1,
+		},
+		{
+			name:         "Reject",
+			text:         "buy $pam now",
+			wantText:     "buy $pam now",
+			wantRejected: true,
+			wantMatches:  1,
+		},
+		{
+			name:        "Flag leaves text alone",
+			text:        "this is a sc@m",
+			wantText:    "this is a sc@m",
+			wantFlagged: true,
+			wantMatches: 1,
+		},
+		{
+			name:         "Several actions",
+			text:         "sharbert spam scam",
+			wantText:     "**** spam scam",
+			wantRejected: true,
+			wantFlagged:  true,
+			wantMatches:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filter.Check(tt.text)
+			if got.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", got.Text, tt.wantText)
+			}
+			if got.Rejected != tt.wantRejected {
+				t.Errorf("Rejected = %v, want %v", got.Rejected, tt.wantRejected)
+			}
+			if got.Flagged != tt.wantFlagged {
+				t.Errorf("Flagged = %v, want %v", got.Flagged, tt.wantFlagged)
+			}
+			if len(got.Matches) != tt.wantMatches {
+				t.Errorf("len(Matches) = %d, want %d", len(got.Matches), tt.wantMatches)
+			}
+		})
+	}
+}
+
+func TestSetTerms(t *testing.T) {
+	filter := NewFilter(nil)
+	if got := filter.Check("fornax").Text; got != "fornax" {
+		t.Fatalf("empty filter changed text to %q", got)
+	}
+
+	filter.SetTerms([]Term{{Term: "Fornax", Mode: MatchSubstring, Action: ActionMask}})
+	if got := filter.Check("fornax").Text; got != "****" {
+		t.Fatalf("Check after SetTerms = %q, want %q", got, "****")
+	}
+
+	filter.SetTerms([]Term{{Term: "--", Mode: MatchSubstring, Action: ActionMask}})
+	if got := filter.Check("a -- b").Text; got != "a -- b" {
+		t.Fatalf("term without letters matched: %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := ParseMatchMode("whole_word"); err != nil {
+		t.Errorf("ParseMatchMode(whole_word) error: %v", err)
+	}
+	if _, err := ParseMatchMode("regex"); err == nil {
+		t.Error("ParseMatchMode(regex) should fail")
+	}
+	if _, err := ParseAction("flag"); err != nil {
+		t.Errorf("ParseAction(flag) error: %v", err)
+	}
+	if _, err := ParseAction("ban"); err == nil {
+		t.Error("ParseAction(ban) should fail")
+	}
+}
+
+func TestValidTerm(t *testing.T) {
+	if !ValidTerm("Fornax") {
+		t.Error("ValidTerm(Fornax) = false, want true")
+	}
+	if ValidTerm(" -- ") {
+		t.Error("ValidTerm(\" -- \") = true, want false")
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so every
+// instance shares the same limits. Refills are computed with the database's
+// clock.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, capacity, rate float64) (float64, bool, error) {
+	tokens, err := s.db.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key:      key,
+		Capacity: capacity,
+		Rate:     rate,
+	})
+	if err == nil {
+		return tokens, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	// The bucket was empty; read how empty for the response headers
+	tokens, err = s.db.GetRateLimitTokens(ctx, database.GetRateLimitTokensParams{
+		Key:      key,
+		Capacity: capacity,
+		Rate:     rate,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+	return tokens, false, nil
+}
+
+// Prune deletes buckets that haven't been used for idle. It should be longer
+// than the longest limit period, after which an unused bucket is full and
+// the same as a missing one.
+func (s *PostgresStore) Prune(ctx context.Context, idle time.Duration) (int64, error) {
+	return s.db.DeleteIdleRateLimitBuckets(ctx, idle.Seconds())
+}
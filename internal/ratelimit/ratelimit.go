@@ -0,0 +1,114 @@
+// Package ratelimit implements token bucket rate limits whose buckets can be
+// kept in memory or shared between instances through Postgres.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limit allows bursts of up to Requests requests, refilling at Requests per
+// Period. The zero Limit allows everything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited reports whether l doesn't limit anything.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// Scale returns l with factor times as many requests per period.
+func (l Limit) Scale(factor int) Limit {
+	return Limit{Requests: l.Requests * factor, Period: l.Period}
+}
+
+// rate returns how many tokens are added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Store keeps token buckets. Take refills the bucket for key at rate tokens
+// per second, up to capacity, then takes a token if there is a whole one.
+// It returns the tokens left and whether one was taken.
+type Store interface {
+	Take(ctx context.Context, key string, capacity, rate float64) (tokens float64, ok bool, err error)
+}
+
+// Limiter checks requests against limits.
+type Limiter struct {
+	store Store
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Result is the outcome of a request against a limit.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, if this one
+	// wasn't.
+	RetryAfter time.Duration
+}
+
+// Allow takes a token from the bucket for key.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true, Limit: limit}, nil
+	}
+
+	capacity := float64(limit.Requests)
+	tokens, ok, err := l.store.Take(ctx, key, capacity, limit.rate())
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(limit, tokens, ok), nil
+}
+
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.rate()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: int(math.Max(math.Floor(tokens), 0)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(s, 0) * float64(time.Second))
+}
+
+// SetHeaders adds the RateLimit headers from the IETF draft on rate limit
+// headers, and Retry-After when the request wasn't allowed. Times are
+// rounded up to whole seconds.
+func (r Result) SetHeaders(h http.Header) {
+	if r.Limit.Unlimited() {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(r.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", r.Limit.Requests, ceilSeconds(r.Limit.Period)))
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(r.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
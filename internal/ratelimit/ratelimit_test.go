@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter over a memory store whose clock only moves
+// when the test advances it.
+func newTestLimiter() (*Limiter, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	return New(store), func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	limiter, advance := newTestLimiter()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "user:1", limit)
+		if err != nil {
+			t.Fatalf("Allow returned error: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Request %d of the burst should be allowed", i+1)
+		}
+		if result.Remaining != 2-i {
+			t.Fatalf("Remaining = %d, want %d", result.Remaining, 2-i)
+		}
+	}
+
+	result, _ := limiter.Allow(ctx, "user:1", limit)
+	if result.Allowed {
+		t.Fatal("Request after the burst should be refused")
+	}
+	if result.RetryAfter != time.Second {
+		t.Fatalf("RetryAfter = %v, want 1s", result.RetryAfter)
+	}
+
+	// One token per second comes back
+	advance(time.Second)
+	if result, _ := limiter.Allow(ctx, "user:1", limit); !result.Allowed {
+		t.Fatal("Request should be allowed once a token has been refilled")
+	}
+	if result, _ := limiter.Allow(ctx, "user:1", limit); result.Allowed {
+		t.Fatal("Only one token should have been refilled")
+	}
+
+	// Buckets never hold more than the limit
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.Allow(ctx, "user:1", limit)
+	}
+	if result, _ := limiter.Allow(ctx, "user:1", limit); result.Allowed {
+		t.Fatal("A bucket shouldn't refill past its capacity")
+	}
+}
+
+func TestLimiterKeysAreIndependent(t *testing.T) {
+	limiter, _ := newTestLimiter()
+	limit := Limit{Requests: 1, Period: time.Minute}
+	ctx := context.Background()
+
+	if result, _ := limiter.Allow(ctx, "ip:203.0.113.7", limit); !result.Allowed {
+		t.Fatal("First request should be allowed")
+	}
+	if result, _ := limiter.Allow(ctx, "ip:203.0.113.7", limit); result.Allowed {
+		t.Fatal("Second request from the same key should be refused")
+	}
+	if result, _ := limiter.Allow(ctx, "ip:203.0.113.8", limit); !result.Allowed {
+		t.Fatal("Another key should have its own bucket")
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter, _ := newTestLimiter()
+
+	for i := 0; i < 100; i++ {
+		if result, _ := limiter.Allow(context.Background(), "user:1", Limit{}); !result.Allowed {
+			t.Fatal("The zero Limit should allow everything")
+		}
+	}
+}
+
+func TestResultHeaders(t *testing.T) {
+	limiter, _ := newTestLimiter()
+	limit := Limit{Requests: 2, Period: time.Minute}
+	ctx := context.Background()
+
+	limiter.Allow(ctx, "user:1", limit)
+	result, _ := limiter.Allow(ctx, "user:1", limit)
+	header := http.Header{}
+	result.SetHeaders(header)
+
+	want := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60",
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if header.Get("Retry-After") != "" {
+		t.Error("Allowed requests shouldn't get Retry-After")
+	}
+
+	result, _ = limiter.Allow(ctx, "user:1", limit)
+	header = http.Header{}
+	result.SetHeaders(header)
+	if got := header.Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want %q", got, "30")
+	}
+}
+
+func TestLimitScale(t *testing.T) {
+	limit := Limit{Requests: 10, Period: time.Minute}.Scale(5)
+	if limit.Requests != 50 || limit.Period != time.Minute {
+		t.Fatalf("Scale(5) = %+v, want 50 per minute", limit)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	store.Take(context.Background(), "idle", 1, 1)
+	now = now.Add(time.Minute)
+	store.sweep(now)
+
+	if len(store.buckets) != 0 {
+		t.Fatalf("Full buckets should be swept, %d left", len(store.buckets))
+	}
+}
@@ -0,0 +1,373 @@
+// Package stream fans chirp changes out to clients of GET /api/stream.
+// Changes are recorded in the stream_events table in the transaction that
+// makes them and announced with NOTIFY, so every instance sees every change
+// whichever instance made it. The table also lets clients that dropped off
+// resume from the last event they saw.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// Channel is the Postgres channel new events are announced on.
+const Channel = "stream_events"
+
+const (
+	// Events a subscriber can fall behind by before it is dropped
+	subscriberBuffer = 256
+	// Events read from the table at a time
+	fetchBatchSize = 100
+	// Most events replayed to a resuming client; further behind than that,
+	// it has to reload instead
+	maxReplay = 1000
+	// How often the table is checked even without a notification, in case
+	// one was lost while the listener reconnected
+	pollInterval = 30 * time.Second
+	// Bounds of the listener's reconnect backoff
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+var (
+	// ErrSlowConsumer ends a subscription that fell too far behind. The
+	// client can reconnect and resume from the last event it received.
+	ErrSlowConsumer = errors.New("subscriber fell too far behind")
+	// ErrClosed ends subscriptions when the hub is closed.
+	ErrClosed = errors.New("stream closed")
+	// ErrMissedEvents is returned by Replay when the events a client missed
+	// can't all be replayed.
+	ErrMissedEvents = errors.New("missed events are no longer available")
+)
+
+// Event is a change to a chirp.
+type Event struct {
+	// Increases with every event, in the order they were committed
+	ID       int64
+	Type     string
+	ChirpID  uuid.UUID
+	UserID   uuid.UUID
+	Hashtags []string
+	// Sent to clients as is
+	Data json.RawMessage
+}
+
+func newEvent(row database.StreamEvent) Event {
+	return Event{
+		ID:       row.ID,
+		Type:     row.Type,
+		ChirpID:  row.ChirpID,
+		UserID:   row.UserID,
+		Hashtags: row.Hashtags,
+		Data:     json.RawMessage(row.Payload),
+	}
+}
+
+// Filter picks the events a subscriber gets. The zero Filter matches every
+// event.
+type Filter struct {
+	// Only chirps by these users, unless nil
+	Authors map[uuid.UUID]bool
+	// Only chirps with this hashtag, lowercase and without the #, unless
+	// empty
+	Hashtag string
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e Event) bool {
+	if f.Authors != nil && !f.Authors[e.UserID] {
+		return false
+	}
+	if f.Hashtag == "" {
+		return true
+	}
+	for _, tag := range e.Hashtags {
+		if tag == f.Hashtag {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish records e and announces it to every instance. ID is assigned
+// here. Pass the queries of the transaction making the change, so the event
+// is only sent if the change is committed, and commit soon after: events
+// are committed one at a time so that their IDs are in commit order.
+func Publish(ctx context.Context, q *database.Queries, e Event) error {
+	if err := q.LockStreamEvents(ctx); err != nil {
+		return err
+	}
+	hashtags := e.Hashtags
+	if hashtags == nil {
+		hashtags = []string{}
+	}
+	id, err := q.CreateStreamEvent(ctx, database.CreateStreamEventParams{
+		Type:     e.Type,
+		ChirpID:  e.ChirpID,
+		UserID:   e.UserID,
+		Hashtags: hashtags,
+		Payload:  string(e.Data),
+	})
+	if err != nil {
+		return err
+	}
+	// Notifications are delivered on commit
+	return q.NotifyStreamEvent(ctx, id)
+}
+
+// Redact blanks the data of the events recorded so far for a chirp, so that
+// once it is deleted or hidden its body can't be read by replaying them. Pass
+// the queries of the transaction removing the chirp. Redacted events are
+// skipped, but their IDs stay taken, so clients still resume from the right
+// place.
+func Redact(ctx context.Context, q *database.Queries, chirpID uuid.UUID) error {
+	return q.RedactStreamEvents(ctx, chirpID)
+}
+
+func redacted(row database.StreamEvent) bool {
+	return row.Payload == ""
+}
+
+// store is the part of database.Queries the hub uses.
+type store interface {
+	GetStreamEventsAfter(ctx context.Context, arg database.GetStreamEventsAfterParams) ([]database.StreamEvent, error)
+	GetLatestStreamEventID(ctx context.Context) (int64, error)
+	GetOldestStreamEventID(ctx context.Context) (int64, error)
+}
+
+// Hub delivers the events recorded by Publish to this instance's
+// subscribers.
+type Hub struct {
+	db store
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	// The last event delivered; only touched by the listening goroutine
+	lastID int64
+}
+
+func NewHub(db *database.Queries) *Hub {
+	return newHub(db)
+}
+
+func newHub(db store) *Hub {
+	return &Hub{
+		db:   db,
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Listen connects to the database at dsn, listens for new events and
+// delivers them in the background until ctx is done. Events published
+// before Listen are not delivered.
+func (h *Hub) Listen(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Stream listener: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	latest, err := h.db.GetLatestStreamEventID(ctx)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	h.lastID = latest
+
+	go func() {
+		defer listener.Close()
+		h.run(ctx, listener.Notify)
+	}()
+	return nil
+}
+
+// run delivers new events whenever notify fires. Notifications only say
+// that there is something new, so a lost one is made up for by the next.
+func (h *Hub) run(ctx context.Context, notify <-chan *pq.Notification) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		// nil after the listener reconnects
+		case <-notify:
+		case <-ticker.C:
+		}
+
+		if err := h.deliverNew(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Couldn't read stream events: %v", err)
+		}
+	}
+}
+
+// deliverNew delivers every event after the last one delivered.
+func (h *Hub) deliverNew(ctx context.Context) error {
+	for {
+		rows, err := h.db.GetStreamEventsAfter(ctx, database.GetStreamEventsAfterParams{
+			AfterID:   h.lastID,
+			MaxEvents: fetchBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if !redacted(row) {
+				h.deliver(newEvent(row))
+			}
+			h.lastID = row.ID
+		}
+		if len(rows) < fetchBatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver hands e to every subscriber it matches. Sends never block:
+// subscribers that can't keep up are dropped.
+func (h *Hub) deliver(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			delete(h.subs, sub)
+			sub.end(ErrSlowConsumer)
+		}
+	}
+}
+
+// Subscribe returns a subscription to events matching f. Close it when done.
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	sub := &Subscription{
+		hub:    h,
+		filter: f,
+		events: make(chan Event, subscriberBuffer),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		sub.end(ErrClosed)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Replay returns the events after afterID that match f, oldest first,
+// leaving out redacted ones. It
+// returns ErrMissedEvents if some have already been deleted or there are too
+// many to replay.
+func (h *Hub) Replay(ctx context.Context, f Filter, afterID int64) ([]Event, error) {
+	oldest, err := h.db.GetOldestStreamEventID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if oldest > afterID+1 {
+		return nil, ErrMissedEvents
+	}
+
+	var authorIDs []uuid.UUID
+	for id := range f.Authors {
+		authorIDs = append(authorIDs, id)
+	}
+	rows, err := h.db.GetStreamEventsAfter(ctx, database.GetStreamEventsAfterParams{
+		AfterID:       afterID,
+		FilterAuthors: f.Authors != nil,
+		AuthorIds:     authorIDs,
+		Hashtag:       f.Hashtag,
+		MaxEvents:     maxReplay + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > maxReplay {
+		return nil, ErrMissedEvents
+	}
+
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		if !redacted(row) {
+			events = append(events, newEvent(row))
+		}
+	}
+	return events, nil
+}
+
+// Close ends every subscription with ErrClosed, and any made later. Call it
+// on shutdown so streaming requests finish.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		sub.end(ErrClosed)
+	}
+}
+
+// Subscription receives the events matching its filter until it ends.
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// Events delivers the subscription's events in order.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ends. Events may still hold
+// events delivered before then.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, once Done is closed.
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	delete(s.hub.subs, s)
+	s.end(ErrClosed)
+}
+
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
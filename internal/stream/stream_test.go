@@ -0,0 +1,300 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// fakeStore keeps events in memory with the same filters as the queries.
+type fakeStore struct {
+	events []database.StreamEvent
+}
+
+func (s *fakeStore) add(userID uuid.UUID, hashtags ...string) {
+	s.events = append(s.events, database.StreamEvent{
+		ID:       int64(len(s.events) + 1),
+		Type:     "chirp.created",
+		ChirpID:  uuid.New(),
+		UserID:   userID,
+		Hashtags: hashtags,
+		Payload:  "{}",
+	})
+}
+
+// redact does what RedactStreamEvents does.
+func (s *fakeStore) redact(chirpID uuid.UUID) {
+	for i := range s.events {
+		if s.events[i].ChirpID == chirpID {
+			s.events[i].Payload = ""
+		}
+	}
+}
+
+func (s *fakeStore) GetStreamEventsAfter(ctx context.Context, arg database.GetStreamEventsAfterParams) ([]database.StreamEvent, error) {
+	var events []database.StreamEvent
+	for _, e := range s.events {
+		if e.ID <= arg.AfterID {
+			continue
+		}
+		if arg.FilterAuthors && !slices.Contains(arg.AuthorIds, e.UserID) {
+			continue
+		}
+		if arg.Hashtag != "" && !slices.Contains(e.Hashtags, arg.Hashtag) {
+			continue
+		}
+		if len(events) == int(arg.MaxEvents) {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *fakeStore) GetLatestStreamEventID(ctx context.Context) (int64, error) {
+	if len(s.events) == 0 {
+		return 0, nil
+	}
+	return s.events[len(s.events)-1].ID, nil
+}
+
+func (s *fakeStore) GetOldestStreamEventID(ctx context.Context) (int64, error) {
+	if len(s.events) == 0 {
+		return 0, nil
+	}
+	return s.events[0].ID, nil
+}
+
+func receivedIDs(sub *Subscription) []int64 {
+	var ids []int64
+	for {
+		select {
+		case e := <-sub.Events():
+			ids = append(ids, e.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	alice := uuid.New()
+	bob := uuid.New()
+	event := Event{UserID: alice, Hashtags: []string{"go", "chirpy"}}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"Everything", Filter{}, true},
+		{"Author", Filter{Authors: map[uuid.UUID]bool{alice: true}}, true},
+		{"Other author", Filter{Authors: map[uuid.UUID]bool{bob: true}}, false},
+		{"Following nobody", Filter{Authors: map[uuid.UUID]bool{}}, false},
+		{"Hashtag", Filter{Hashtag: "chirpy"}, true},
+		{"Other hashtag", Filter{Hashtag: "rust"}, false},
+		{"Author and hashtag", Filter{Authors: map[uuid.UUID]bool{alice: true}, Hashtag: "go"}, true},
+		{"Author and other hashtag", Filter{Authors: map[uuid.UUID]bool{alice: true}, Hashtag: "rust"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliverNewFansOutMatchingEvents(t *testing.T) {
+	alice := uuid.New()
+	bob := uuid.New()
+	db := &fakeStore{}
+	hub := newHub(db)
+
+	everything := hub.Subscribe(Filter{})
+	aliceOnly := hub.Subscribe(Filter{Authors: map[uuid.UUID]bool{alice: true}})
+	tagged := hub.Subscribe(Filter{Hashtag: "go"})
+
+	for i := 0; i < fetchBatchSize; i++ {
+		db.add(bob)
+	}
+	db.add(alice, "go")
+	db.add(bob, "go")
+
+	if err := hub.deliverNew(context.Background()); err != nil {
+		t.Fatalf("deliverNew() error = %v", err)
+	}
+
+	if got := len(receivedIDs(everything)); got != fetchBatchSize+2 {
+		t.Errorf("unfiltered subscriber got %d events, want %d", got, fetchBatchSize+2)
+	}
+	if got, want := receivedIDs(aliceOnly), []int64{fetchBatchSize + 1}; !slices.Equal(got, want) {
+		t.Errorf("author subscriber got %v, want %v", got, want)
+	}
+	if got, want := receivedIDs(tagged), []int64{fetchBatchSize + 1, fetchBatchSize + 2}; !slices.Equal(got, want) {
+		t.Errorf("hashtag subscriber got %v, want %v", got, want)
+	}
+
+	// Only new events are delivered next time
+	db.add(alice)
+	if err := hub.deliverNew(context.Background()); err != nil {
+		t.Fatalf("deliverNew() error = %v", err)
+	}
+	if got, want := receivedIDs(everything), []int64{fetchBatchSize + 3}; !slices.Equal(got, want) {
+		t.Errorf("unfiltered subscriber got %v, want %v", got, want)
+	}
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	userID := uuid.New()
+	db := &fakeStore{}
+	hub := newHub(db)
+
+	slow := hub.Subscribe(Filter{})
+	for i := 0; i < subscriberBuffer+1; i++ {
+		db.add(userID)
+	}
+	if err := hub.deliverNew(context.Background()); err != nil {
+		t.Fatalf("deliverNew() error = %v", err)
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("subscription didn't end")
+	}
+	if err := slow.Err(); !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("Err() = %v, want %v", err, ErrSlowConsumer)
+	}
+	// What was delivered before it fell behind can still be read
+	if got := len(receivedIDs(slow)); got != subscriberBuffer {
+		t.Errorf("got %d buffered events, want %d", got, subscriberBuffer)
+	}
+
+	// Other subscribers carry on
+	fast := hub.Subscribe(Filter{})
+	db.add(userID)
+	if err := hub.deliverNew(context.Background()); err != nil {
+		t.Fatalf("deliverNew() error = %v", err)
+	}
+	if got := len(receivedIDs(fast)); got != 1 {
+		t.Errorf("got %d events, want 1", got)
+	}
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	hub := newHub(&fakeStore{})
+	sub := hub.Subscribe(Filter{})
+
+	hub.Close()
+	if err := sub.Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err() = %v, want %v", err, ErrClosed)
+	}
+
+	late := hub.Subscribe(Filter{})
+	if err := late.Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err() after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	alice := uuid.New()
+	bob := uuid.New()
+	db := &fakeStore{}
+	hub := newHub(db)
+
+	db.add(alice)
+	db.add(bob)
+	db.add(alice, "go")
+
+	events, err := hub.Replay(context.Background(), Filter{Authors: map[uuid.UUID]bool{alice: true}}, 1)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(events) != 1 || events[0].ID != 3 {
+		t.Errorf("Replay() = %v, want event 3", events)
+	}
+
+	events, err = hub.Replay(context.Background(), Filter{}, 3)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Replay() from the latest event = %v, want none", events)
+	}
+
+	// Events 1 and 2 have been deleted
+	db.events = db.events[2:]
+	if _, err := hub.Replay(context.Background(), Filter{}, 0); !errors.Is(err, ErrMissedEvents) {
+		t.Errorf("Replay() past deleted events error = %v, want %v", err, ErrMissedEvents)
+	}
+	if _, err := hub.Replay(context.Background(), Filter{}, 2); err != nil {
+		t.Errorf("Replay() after deleted events error = %v", err)
+	}
+}
+
+func TestReplayIsBounded(t *testing.T) {
+	userID := uuid.New()
+	db := &fakeStore{}
+	hub := newHub(db)
+
+	for i := 0; i < maxReplay+1; i++ {
+		db.add(userID)
+	}
+	if _, err := hub.Replay(context.Background(), Filter{}, 0); !errors.Is(err, ErrMissedEvents) {
+		t.Errorf("Replay() error = %v, want %v", err, ErrMissedEvents)
+	}
+	events, err := hub.Replay(context.Background(), Filter{}, 1)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(events) != maxReplay {
+		t.Errorf("Replay() returned %d events, want %d", len(events), maxReplay)
+	}
+}
+
+func TestReplaySkipsDeletedChirps(t *testing.T) {
+	userID := uuid.New()
+	db := &fakeStore{}
+	hub := newHub(db)
+
+	db.add(userID)
+	db.add(userID)
+	deleted := db.events[0]
+
+	// Deleting the chirp redacts its events, then records the deletion
+	db.redact(deleted.ChirpID)
+	db.events = append(db.events, database.StreamEvent{
+		ID:      3,
+		Type:    "chirp.deleted",
+		ChirpID: deleted.ChirpID,
+		UserID:  userID,
+		Payload: "{}",
+	})
+
+	events, err := hub.Replay(context.Background(), Filter{}, 0)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	if want := []int64{2, 3}; !slices.Equal(ids, want) {
+		t.Errorf("Replay() returned events %v, want %v", ids, want)
+	}
+
+	// Live subscribers skip them too
+	sub := hub.Subscribe(Filter{})
+	if err := hub.deliverNew(context.Background()); err != nil {
+		t.Fatalf("deliverNew() error = %v", err)
+	}
+	if got, want := receivedIDs(sub), []int64{2, 3}; !slices.Equal(got, want) {
+		t.Errorf("subscriber got %v, want %v", got, want)
+	}
+}
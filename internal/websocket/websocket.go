@@ -0,0 +1,343 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455), as much of it as the server needs to push messages to
+// clients: the handshake, text messages, pings and closing. Extensions and
+// subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the client's key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// Largest message read from a client unless changed with SetReadLimit
+const defaultReadLimit = 64 << 10
+
+// How long the closing handshake may take
+const closeTimeout = 5 * time.Second
+
+var (
+	ErrBadHandshake   = errors.New("not a valid websocket handshake")
+	ErrProtocol       = errors.New("websocket protocol error")
+	ErrMessageTooBig  = errors.New("websocket message too big")
+	ErrClosed         = errors.New("websocket closed")
+	ErrControlTooLong = errors.New("websocket control frame payload too long")
+)
+
+// CloseError is returned by ReadMessage when the client closes the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed by peer with code %d %q", e.Code, e.Reason)
+}
+
+// IsUpgrade reports whether r asks to switch to the WebSocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the handshake for r and takes over its connection. On
+// failure it has already responded with an error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "Expected a websocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Couldn't upgrade connection", http.StatusInternalServerError)
+		return nil, err
+	}
+	// Deadlines set by the server for the request no longer apply
+	netConn.SetDeadline(time.Time{})
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:      netConn,
+		r:         rw.Reader,
+		readLimit: defaultReadLimit,
+	}, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is an upgraded connection. Writes may be made from any goroutine;
+// ReadMessage should only be called from one.
+type Conn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	readLimit int64
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+// SetReadLimit sets the size of the largest message ReadMessage accepts.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetWriteDeadline sets the deadline for writes, see net.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// WriteText sends data as a text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping sends a ping. The client answers with a pong, which ReadMessage
+// discards.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with code and reason, unless one was sent
+// already, and closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	err := c.writeClose(code, reason)
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return c.writeFrame(opClose, payload)
+}
+
+// writeFrame sends a single unmasked frame, as servers must.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	if opcode >= opClose && len(payload) > 125 {
+		return ErrControlTooLong
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// ReadMessage reads the next text or binary message, answering pings and
+// close frames along the way. When the client closes the connection it
+// returns a *CloseError. Protocol errors and messages over the read limit
+// close the connection.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	var message []byte
+	messageOpcode := -1
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			// Echo the close to complete the handshake
+			c.Close(closeErr.Code, "")
+			return 0, nil, closeErr
+		case opText, opBinary:
+			if messageOpcode != -1 {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+			messageOpcode = int(op)
+		case opContinuation:
+			if messageOpcode == -1 {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+		default:
+			return 0, nil, c.fail(ErrProtocol)
+		}
+
+		if int64(len(message)+len(payload)) > c.readLimit {
+			return 0, nil, c.fail(ErrMessageTooBig)
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+// fail closes the connection after a read error, telling the client why
+// where the error is its fault.
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrMessageTooBig):
+		c.Close(CloseMessageTooBig, "")
+	case errors.Is(err, ErrProtocol), errors.Is(err, ErrControlTooLong):
+		c.Close(CloseProtocolError, "")
+	default:
+		c.conn.Close()
+	}
+	return err
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	// No extensions were negotiated, so the reserved bits must be unset
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, ErrProtocol
+	}
+	opcode = header[0] & 0x0F
+	// Clients must mask everything they send
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, ErrProtocol
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, ErrControlTooLong
+	}
+	if length < 0 || length > c.readLimit {
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
@@ -0,0 +1,243 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient speaks just enough of the protocol to test the server.
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, serverURL string, headers string) (*testClient, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n"+headers+"\r\n")
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	return &testClient{conn: conn, r: r}, resp
+}
+
+const validHandshake = "Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+// writeFrame sends a masked frame, as clients must.
+func (c *testClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+}
+
+func (c *testClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server sent a masked frame")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func TestUpgrade(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.WriteText([]byte(strings.Repeat("a", 200)))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Errorf("ReadMessage() error = %v", err)
+			return
+		}
+		received <- string(data)
+		_, _, err = conn.ReadMessage()
+		var closeErr *CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != CloseNormal {
+			t.Errorf("ReadMessage() after close error = %v, want CloseError 1000", err)
+		}
+	}))
+	defer server.Close()
+
+	client, resp := dial(t, server.URL, validHandshake)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	// Example from RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	opcode, payload := client.readFrame(t)
+	if opcode != opText || len(payload) != 200 {
+		t.Errorf("got opcode %d with %d bytes, want a 200 byte text message", opcode, len(payload))
+	}
+
+	// Pings are answered with the same payload
+	client.writeFrame(t, true, opPing, []byte("hi"))
+	opcode, payload = client.readFrame(t)
+	if opcode != opPong || string(payload) != "hi" {
+		t.Errorf("got opcode %d %q, want pong \"hi\"", opcode, payload)
+	}
+
+	// Fragmented messages are reassembled
+	client.writeFrame(t, false, opText, []byte("hello "))
+	client.writeFrame(t, true, opContinuation, []byte("world"))
+	select {
+	case got := <-received:
+		if got != "hello world" {
+			t.Errorf("server received %q, want %q", got, "hello world")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't receive the message")
+	}
+
+	// Closing is echoed
+	client.writeFrame(t, true, opClose, []byte{0x03, 0xE8})
+	opcode, payload = client.readFrame(t)
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("got opcode %d %v, want close 1000", opcode, payload)
+	}
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Upgrade(w, r); err == nil {
+			t.Error("Upgrade() succeeded")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		headers string
+		want    int
+	}{
+		{"Plain request", "", http.StatusBadRequest},
+		{"Old version", strings.Replace(validHandshake, "13", "8", 1), http.StatusUpgradeRequired},
+		{"Bad key", strings.Replace(validHandshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, resp := dial(t, server.URL, tt.headers)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessageRejectsBadFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		send     func(t *testing.T, c *testClient)
+		wantErr  error
+		wantCode uint16
+	}{
+		{
+			name: "Unmasked frame",
+			send: func(t *testing.T, c *testClient) {
+				c.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+			},
+			wantErr:  ErrProtocol,
+			wantCode: CloseProtocolError,
+		},
+		{
+			name: "Too big",
+			send: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, opText, []byte(strings.Repeat("a", 20)))
+			},
+			wantErr:  ErrMessageTooBig,
+			wantCode: CloseMessageTooBig,
+		},
+		{
+			name: "Continuation without a message",
+			send: func(t *testing.T, c *testClient) {
+				c.writeFrame(t, true, opContinuation, []byte("hi"))
+			},
+			wantErr:  ErrProtocol,
+			wantCode: CloseProtocolError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := Upgrade(w, r)
+				if err != nil {
+					errs <- err
+					return
+				}
+				conn.SetReadLimit(10)
+				_, _, err = conn.ReadMessage()
+				errs <- err
+			}))
+			defer server.Close()
+
+			client, _ := dial(t, server.URL, validHandshake)
+			tt.send(t, client)
+
+			if err := <-errs; !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			opcode, payload := client.readFrame(t)
+			if opcode != opClose || binary.BigEndian.Uint16(payload) != tt.wantCode {
+				t.Errorf("got opcode %d %v, want close %d", opcode, payload, tt.wantCode)
+			}
+		})
+	}
+}
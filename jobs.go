@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/vanzei/goserver/internal/jobs"
+)
+
+// Background jobs, run by internal/jobs on every instance
+const (
+	jobKindSendEmail              = "email.send"
+	jobKindPublishScheduledChirps = "chirps.publish_scheduled"
+	jobKindExpireSubscriptions    = "subscriptions.expire"
+	jobKindDispatchWebhooks       = "webhooks.dispatch"
+	jobKindPruneRateLimits        = "rate_limits.prune"
+	jobKindPruneStreamEvents      = "stream_events.prune"
+)
+
+const (
+	jobWorkers = 4
+	// How long running jobs get to finish when the server shuts down
+	shutdownTimeout = 30 * time.Second
+)
+
+func (cfg *apiConfig) registerJobs(runner *jobs.Runner) {
+	runner.Handle(jobKindSendEmail, cfg.handleSendEmailJob)
+	runner.Every(jobKindPublishScheduledChirps, scheduledChirpPublishInterval, cfg.handlePublishScheduledChirpsJob)
+	runner.Every(jobKindExpireSubscriptions, subscriptionExpiryInterval, cfg.handleExpireSubscriptionsJob)
+	runner.Every(jobKindDispatchWebhooks, webhookDispatchInterval, cfg.handleDispatchWebhooksJob)
+	runner.Every(jobKindPruneStreamEvents, streamPruneInterval, cfg.handlePruneStreamEventsJob)
+}
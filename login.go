@@ -17,37 +17,101 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	type response struct {
-		UserResponse
-	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
+	// The attempt is checked and recorded in a transaction that locks the
+	// IP address and the account, so parallel guesses wait for each other
+	// instead of all passing the checks before any failure is counted
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Addresses guessing across many accounts are slowed down as a whole
+	ip := cfg.clientIP(r)
+	if err := qtx.LockLoginAttemptsByIP(r.Context(), ip); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	retryAfter, err := ipLoginRetryAfter(r.Context(), qtx, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if retryAfter > 0 {
+		cfg.recordLoginEvent(r, uuid.NullUUID{}, req.Email, loginThrottled)
+		respondWithLoginThrottled(w, retryAfter)
+		return
+	}
+
 	// find email in db
-	user, err := cfg.DB.GetUserByEmail(r.Context(), req.Email)
+	user, err := qtx.GetUserByEmailForLogin(r.Context(), req.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			cfg.recordLoginEvent(r, uuid.NullUUID{}, req.Email, loginUnknownUser)
 			respondWithError(w, http.StatusUnauthorized, "Invalid email or password", nil)
 			return
 		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
 		return
 	}
+	userID := uuid.NullUUID{UUID: user.ID, Valid: true}
+
+	// A locked account isn't checked at all, so guesses during the lockout
+	// can't succeed
+	if retryAfter := accountLoginRetryAfter(user); retryAfter > 0 {
+		cfg.recordLoginEvent(r, userID, req.Email, loginThrottled)
+		respondWithLoginThrottled(w, retryAfter)
+		return
+	}
+
 	// Check if the password is correct
 	if !auth.CheckPasswordHash(req.Password, user.HashedPassword) {
+		cfg.recordLoginEvent(r, userID, req.Email, loginBadPassword)
+		if err := recordFailedLogin(r.Context(), qtx, user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record failed login", err)
+			return
+		}
 		respondWithError(w, http.StatusUnauthorized, "Invalid email or password", nil)
 		return
 	}
 
-	// Generate access token with fixed 1-hour expiration
-	accessToken, err := auth.MakeJWT(user.ID, cfg.secret, time.Hour)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate access token", err)
+	// With two-factor authentication the password alone isn't enough. Failed
+	// logins aren't reset until the code is checked too.
+	if user.TotpEnabledAt.Valid {
+		cfg.recordLoginEvent(r, userID, req.Email, loginMFARequired)
+		cfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+
+	if _, err := qtx.ResetFailedLogins(r.Context(), user.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
 		return
 	}
 
+	cfg.respondWithLogin(w, r, user)
+}
+
+// respondWithLogin starts a new session for user and responds with its
+// tokens. It is only called once every check has passed and the account's
+// failed logins have been cleared.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	cfg.recordLoginEvent(r, uuid.NullUUID{UUID: user.ID, Valid: true}, user.Email, loginSuccess)
+
 	// Generate refresh token
 	refreshTokenString, err := auth.MakeRefreshToken()
 	if err != nil {
@@ -55,16 +119,24 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Store refresh token in database - use _ to ignore the variable if you don't need it
-	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		UserID: user.ID,
-		Token:  refreshTokenString,
+	// Store refresh token in database; it starts a new session
+	session, err := cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		UserID:    user.ID,
+		Token:     refreshTokenString,
+		UserAgent: r.UserAgent(),
+		IpAddress: cfg.clientIP(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't store refresh token", err)
 		return
 	}
 
+	accessToken, err := cfg.makeAccessToken(user, session.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate access token", err)
+		return
+	}
+
 	// Return both tokens in response
 	respondWithJSON(w, http.StatusOK, struct {
 		ID            uuid.UUID `json:"id"`
@@ -72,12 +144,23 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Token         string    `json:"token"`
 		RefreshToken  string    `json:"refresh_token"`
 		IsChirpyRed  bool      `json:"is_chirpy_red"`
+		Role          string    `json:"role"`
 	}{
 		ID:           user.ID,
 		Email:        user.Email,
 		Token:        accessToken,
 		RefreshToken: refreshTokenString,
 		IsChirpyRed: user.IsChirpyRed,
+		Role:         user.Role,
 	})
 }
 
+
+// Access tokens are short-lived; clients use their refresh token to get a
+// new one.
+const accessTokenTTL = time.Hour
+
+// makeAccessToken issues an access token for a session of user.
+func (cfg *apiConfig) makeAccessToken(user database.User, sessionID uuid.UUID) (string, error) {
+	return auth.MakeJWT(user.ID, user.Role, sessionID, cfg.keyring, accessTokenTTL)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/database"
+)
+
+// An account or IP address can fail a few logins freely. After that each
+// failure doubles the wait before the next attempt is allowed, up to
+// maxLoginBackoff. A successful login resets an account's count; an IP
+// address's failures are forgotten after ipFailureWindow.
+const (
+	accountFailuresAllowed = 3
+	ipFailuresAllowed      = 20
+	ipFailureWindow        = 15 * time.Minute
+	maxLoginBackoff        = 15 * time.Minute
+)
+
+// Outcomes recorded in login_events
+const (
+	loginSuccess     = "success"
+	loginMFARequired = "mfa_required"
+	loginBadPassword = "bad_password"
+	loginUnknownUser = "unknown_user"
+	loginBadMFACode  = "bad_mfa_code"
+	loginThrottled   = "throttled"
+)
+
+// loginBackoff returns how long to wait after failures consecutive failed
+// logins.
+func loginBackoff(failures, allowed int) time.Duration {
+	if failures < allowed {
+		return 0
+	}
+	backoff := time.Second
+	for i := allowed; i < failures && backoff < maxLoginBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxLoginBackoff)
+}
+
+// ipLoginRetryAfter returns how long the IP address has to wait before it
+// may try to log in again. Call it with the address locked by
+// LockLoginAttemptsByIP, or parallel attempts could all pass before any of
+// their failures is recorded.
+func ipLoginRetryAfter(ctx context.Context, q *database.Queries, ip string) (time.Duration, error) {
+	recent, err := q.GetRecentLoginFailuresByIP(ctx, database.GetRecentLoginFailuresByIPParams{
+		IpAddress:     ip,
+		WindowSeconds: ipFailureWindow.Seconds(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := time.Duration(recent.SecondsSinceLast * float64(time.Second))
+	return max(loginBackoff(int(recent.Failures), ipFailuresAllowed)-elapsed, 0), nil
+}
+
+// accountLoginRetryAfter returns how long the account is locked for.
+func accountLoginRetryAfter(user database.User) time.Duration {
+	if !user.LockedUntil.Valid {
+		return 0
+	}
+	return max(time.Until(user.LockedUntil.Time), 0)
+}
+
+// recordFailedLogin counts a failed attempt against an account and locks it
+// for the backoff that follows. q must be the transaction holding the row
+// from GetUserByIDForLogin or GetUserByEmailForLogin.
+func recordFailedLogin(ctx context.Context, q *database.Queries, userID uuid.UUID) error {
+	failures, err := q.RecordFailedLogin(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	backoff := loginBackoff(int(failures), accountFailuresAllowed)
+	if backoff == 0 {
+		return nil
+	}
+	return q.SetUserLockedUntil(ctx, database.SetUserLockedUntilParams{
+		ID:          userID,
+		LockedUntil: sql.NullTime{Time: time.Now().UTC().Add(backoff), Valid: true},
+	})
+}
+
+// recordLoginEvent adds a login attempt to login_events. Failing to record
+// it doesn't fail the login. It is written outside the attempt's
+// transaction, and so is committed before the IP address is unlocked.
+func (cfg *apiConfig) recordLoginEvent(r *http.Request, userID uuid.NullUUID, email, outcome string) {
+	err := cfg.DB.CreateLoginEvent(r.Context(), database.CreateLoginEventParams{
+		UserID:    userID,
+		Email:     email,
+		IpAddress: cfg.clientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		log.Printf("Couldn't record login event: %v", err)
+	}
+}
+
+func respondWithLoginThrottled(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+}
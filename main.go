@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"sync/atomic"
 	_ "github.com/lib/pq"
 	"github.com/joho/godotenv"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"database/sql"
+	"github.com/vanzei/goserver/internal/auth"
 	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/jobs"
+	"github.com/vanzei/goserver/internal/mailer"
+	"github.com/vanzei/goserver/internal/moderation"
+	"github.com/vanzei/goserver/internal/ratelimit"
+	"github.com/vanzei/goserver/internal/stream"
 
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	DB             *database.Queries
 	PLATFORM       string
-	secret         string
+	keyring        *auth.Keyring
 	polkaWebhookSecret string
+	moderation     *moderation.Filter
+	trustProxy     bool
+	mailer         mailer.Mailer
+	publicURL      string
+	rateLimiter    *ratelimit.Limiter
+	rateLimitPlans *planCache
+	stream         *stream.Hub
 }
 
 
@@ -43,9 +62,22 @@ func main() {
 
 	dbQueries := database.New(db)
 
-	secret := os.Getenv("secret")
-	if platform == "" {
-		log.Fatal("Secret must be set")
+	// Access tokens are signed with the keys in JWT_KEYS_DIR. In dev a
+	// throwaway key is generated when none are configured.
+	var keyring *auth.Keyring
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		keyring, err = auth.LoadKeyring(keysDir, os.Getenv("JWT_SIGNING_KEY_ID"))
+		if err != nil {
+			log.Fatalf("Failed to load JWT keys: %v", err)
+		}
+	} else if platform == "dev" {
+		log.Println("JWT_KEYS_DIR not set, signing tokens with a temporary key")
+		keyring, err = auth.NewEphemeralKeyring()
+		if err != nil {
+			log.Fatalf("Failed to generate JWT key: %v", err)
+		}
+	} else {
+		log.Fatal("JWT_KEYS_DIR must be set")
 	}
 
 	polkaKey := os.Getenv("POLKA_KEY")
@@ -53,43 +85,185 @@ func main() {
 		log.Fatal("POLKA_KEY not found")
 	}
 
-	
+	// Mail goes through SMTP when SMTP_ADDR is set. In dev it is written to
+	// files in MAIL_DIR instead.
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Chirpy <no-reply@localhost>"
+	}
+	var mail mailer.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mail, err = mailer.NewSMTPMailer(smtpAddr, mailFrom, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		if err != nil {
+			log.Fatalf("Invalid SMTP_ADDR: %v", err)
+		}
+	} else if platform == "dev" {
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+		log.Printf("SMTP_ADDR not set, writing mail to %s", mailDir)
+		mail, err = mailer.NewFileMailer(mailDir, mailFrom)
+		if err != nil {
+			log.Fatalf("Failed to create mail directory: %v", err)
+		}
+	} else {
+		log.Fatal("SMTP_ADDR must be set")
+	}
+
+	// Links in emails point here
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	// Rate limits are kept in memory unless RATE_LIMIT_STORE=postgres, which
+	// shares them between instances
+	runner := jobs.NewRunner(dbQueries, jobWorkers)
+	var rateLimitStore ratelimit.Store
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		postgresStore := ratelimit.NewPostgresStore(dbQueries)
+		runner.Every(jobKindPruneRateLimits, rateLimitPruneInterval, pruneRateLimitsJob(postgresStore))
+		rateLimitStore = postgresStore
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q, use memory or postgres", store)
+	}
 
 	apiCfg := apiConfig{
 		fileserverHits: atomic.Int32{},
+		db:             db,
 		DB:             dbQueries,
 		PLATFORM:       platform,
-		secret:         secret,
+		keyring:        keyring,
 		polkaWebhookSecret: polkaKey,
+		moderation:     moderation.NewFilter(nil),
+		trustProxy:     os.Getenv("TRUST_PROXY") == "true",
+		mailer:         mail,
+		publicURL:      publicURL,
+		rateLimiter:    ratelimit.New(rateLimitStore),
+		rateLimitPlans: newPlanCache(),
+		stream:         stream.NewHub(dbQueries),
 	}
+
+	if err := apiCfg.loadModerationTerms(context.Background()); err != nil {
+		log.Fatalf("Failed to load moderation terms: %v", err)
+	}
+	go apiCfg.refreshModerationTerms(context.Background(), moderationRefreshInterval)
+	if err := apiCfg.stream.Listen(context.Background(), dbURL); err != nil {
+		log.Fatalf("Failed to listen for stream events: %v", err)
+	}
+	apiCfg.registerJobs(runner)
+	runner.Start()
 	
 	mux := http.NewServeMux()
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(filepathRoot)))))
 	
 	
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.HandleFunc("POST /admin/reset", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerReset))
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerResendVerification)
+	mux.HandleFunc("POST /api/users/email/confirm", apiCfg.handlerConfirmEmailChange)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhook)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerFollowUser)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.handlerLikeChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.handlerRechirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/report", apiCfg.handlerReportChirp)
+	mux.HandleFunc("POST /api/tokens", apiCfg.handlerCreateToken)
+	mux.HandleFunc("POST /api/totp/enroll", apiCfg.handlerEnrollTOTP)
+	mux.HandleFunc("POST /api/totp/verify", apiCfg.handlerVerifyTOTP)
+	mux.HandleFunc("POST /api/totp/disable", apiCfg.handlerDisableTOTP)
+	mux.HandleFunc("POST /api/webhooks", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerCreateWebhook))
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/retry", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerRetryWebhookDelivery))
+	mux.HandleFunc("POST /admin/moderation/terms", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerCreateModerationTerm))
+	mux.HandleFunc("POST /admin/reports/{reportID}/resolve", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerResolveReport))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerUnlockUser))
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerReplayWebhookEvent))
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/metrics", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerMetrics))
+	mux.HandleFunc("GET /admin/moderation/terms", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerListModerationTerms))
+	mux.HandleFunc("GET /admin/moderation/actions", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerGetModerationActions))
+	mux.HandleFunc("GET /admin/reports", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerGetReports))
+	mux.HandleFunc("GET /admin/users/{userID}/login-events", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetLoginEvents))
+	mux.HandleFunc("GET /admin/webhooks/events", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetWebhookEvents))
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
+	mux.HandleFunc("GET /api/stream", apiCfg.handlerStream)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerSearchChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirpbyId)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerGetChirpRevisions)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerGetChirpThread)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerGetFollowing)
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerGetTimeline)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerGetSessions)
+	mux.HandleFunc("GET /api/tokens", apiCfg.handlerGetTokens)
+	mux.HandleFunc("GET /api/scheduled-chirps", apiCfg.handlerGetScheduledChirps)
+	mux.HandleFunc("GET /api/users/me/subscription", apiCfg.handlerGetSubscription)
+	mux.HandleFunc("GET /api/webhooks", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetWebhooks))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerGetWebhookDeliveries))
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", apiCfg.handlerGetTagChirps)
+	mux.HandleFunc("GET /api/users/{userID}/mentions", apiCfg.handlerGetUserMentions)
 
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerModifyUser)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerUpdateChirp)
+	mux.HandleFunc("PUT /api/users", apiCfg.handlerReplaceUser)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerSetUserRole))
+	mux.HandleFunc("PATCH /api/users", apiCfg.handlerModifyUser)
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", apiCfg.handlerUpdateChirp)
+	mux.HandleFunc("PATCH /admin/moderation/terms/{termID}", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerUpdateModerationTerm))
 
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirpbyId)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUnfollowUser)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.handlerUnlikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.handlerUndoRechirp)
+	mux.HandleFunc("DELETE /api/sessions/others", apiCfg.handlerRevokeOtherSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerRevokeSession)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.handlerDeleteToken)
+	mux.HandleFunc("DELETE /api/scheduled-chirps/{scheduledChirpID}", apiCfg.handlerDeleteScheduledChirp)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerDeleteWebhook))
+	mux.HandleFunc("DELETE /admin/moderation/terms/{termID}", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.handlerDeleteModerationTerm))
+	mux.HandleFunc("DELETE /admin/users/{userID}/suspension", apiCfg.middlewareRequireRole(auth.RoleModerator, apiCfg.handlerUnsuspendUser))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: apiCfg.middlewareRateLimit(mux),
 	}
+	// Shutdown doesn't interrupt requests, so end streams for it
+	srv.RegisterOnShutdown(apiCfg.stream.Close)
+
+	// On SIGINT or SIGTERM, stop accepting requests and let running jobs
+	// finish before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Serving files from %s on port: http://localhost:%s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		log.Printf("Serving files from %s on port: http://localhost:%s\n", filepathRoot, port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Couldn't shut down server cleanly: %v", err)
+	}
+	if err := runner.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Jobs were still running at shutdown: %v", err)
+	}
 }
 
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// pageCursor marks the last row of a page in a (created_at, id) ordering.
+// Clients only ever see it in its encoded, opaque form.
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := strconv.FormatInt(createdAt.UnixMicro(), 10) + "." + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+
+	micros, idStr, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	usec, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+
+	return pageCursor{
+		CreatedAt: time.UnixMicro(usec).UTC(),
+		ID:        id,
+	}, nil
+}
+
+// parsePageLimit reads the limit query parameter.
+func parsePageLimit(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultPageLimit, nil
+	}
+	n, err := strconv.Atoi(limitStr)
+	if err != nil || n < 1 || n > maxPageLimit {
+		return 0, ErrInvalidLimit
+	}
+	return n, nil
+}
+
+// parsePageParams reads the limit and cursor query parameters. A nil cursor
+// means the first page was requested.
+func parsePageParams(r *http.Request) (int, *pageCursor, error) {
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cursorStr := r.URL.Query().Get("cursor")
+	if cursorStr == "" {
+		return limit, nil, nil
+	}
+	cursor, err := decodeCursor(cursorStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &cursor, nil
+}
+
+// respondWithPageParamsError reports a bad limit or cursor query parameter.
+func respondWithPageParamsError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrInvalidLimit:
+		respondWithError(w, http.StatusBadRequest, "Limit must be between 1 and "+strconv.Itoa(maxPageLimit), nil)
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", nil)
+	}
+}
+
+func (c *pageCursor) nullCreatedAt() sql.NullTime {
+	if c == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: c.CreatedAt, Valid: true}
+}
+
+func (c *pageCursor) nullID() uuid.NullUUID {
+	if c == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: c.ID, Valid: true}
+}
+
+// Ranked results have no stable (created_at, id) position to resume from, so
+// their cursors wrap a plain offset instead.
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o" + strconv.Itoa(offset)))
+}
+
+// parseOffsetPageParams reads the limit and an offset cursor.
+func parseOffsetPageParams(r *http.Request) (int, int, error) {
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cursorStr := r.URL.Query().Get("cursor")
+	if cursorStr == "" {
+		return limit, 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursorStr)
+	if err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	offsetStr, ok := strings.CutPrefix(string(raw), "o")
+	if !ok {
+		return 0, 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, 0, ErrInvalidCursor
+	}
+	return limit, offset, nil
+}
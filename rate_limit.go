@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/jobs"
+	"github.com/vanzei/goserver/internal/ratelimit"
+)
+
+// Every /api route is limited per caller: the user a token belongs to, or
+// the client IP for anonymous requests. Each route has its own buckets.
+// Reads and writes have different defaults, and rateLimitRoutes overrides
+// them for single routes.
+var (
+	readRateLimit  = ratelimit.Limit{Requests: 300, Period: time.Minute}
+	writeRateLimit = ratelimit.Limit{Requests: 60, Period: time.Minute}
+	// Routes that check passwords or tokens, or send mail
+	authRateLimit = ratelimit.Limit{Requests: 10, Period: time.Minute}
+)
+
+var rateLimitRoutes = map[string]ratelimit.Limit{
+	"POST /api/users":               authRateLimit,
+	"POST /api/login":               authRateLimit,
+	"POST /api/login/mfa":           authRateLimit,
+	"POST /api/users/verify":        authRateLimit,
+	"POST /api/users/verify/resend": authRateLimit,
+	"POST /api/users/email/confirm": authRateLimit,
+	"POST /api/password/forgot":     authRateLimit,
+	"POST /api/password/reset":      authRateLimit,
+	// Health checks and Polka's webhooks aren't limited
+	"GET /api/healthz":         {},
+	"POST /api/polka/webhooks": {},
+}
+
+// How often idle buckets are deleted from Postgres
+const rateLimitPruneInterval = time.Hour
+
+// rateLimitFor returns the limit for the route registered as pattern, and
+// false for routes that aren't limited.
+func rateLimitFor(pattern string) (ratelimit.Limit, bool) {
+	if limit, ok := rateLimitRoutes[pattern]; ok {
+		return limit, !limit.Unlimited()
+	}
+
+	method, path, _ := strings.Cut(pattern, " ")
+	if !strings.HasPrefix(path, "/api/") {
+		return ratelimit.Limit{}, false
+	}
+	if method == http.MethodGet {
+		return readRateLimit, true
+	}
+	return writeRateLimit, true
+}
+
+// middlewareRateLimit applies the limit of the route each request is routed
+// to. If the limits can't be checked, requests are let through rather than
+// taking the API down with the store.
+func (cfg *apiConfig) middlewareRateLimit(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		limit, ok := rateLimitFor(pattern)
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		identity, multiplier := cfg.rateLimitIdentity(r)
+		limit = limit.Scale(multiplier)
+
+		result, err := cfg.rateLimiter.Allow(r.Context(), pattern+"|"+identity, limit)
+		if err != nil {
+			log.Printf("Couldn't check rate limit: %v", err)
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		result.SetHeaders(w.Header())
+		if !result.Allowed {
+			respondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded, try again later", nil)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitIdentity returns the key a request is counted under, and how
+// many times the usual limits the caller's plan allows. Requests with an
+// access token or personal access token are counted by the user they belong
+// to, whichever token they use. Who a token belongs to and their plan are
+// cached for rateLimitPlanTTL, so most requests don't look them up. Invalid
+// tokens are counted by IP; the handler rejects them anyway.
+func (cfg *apiConfig) rateLimitIdentity(r *http.Request) (string, int) {
+	anonymous := "ip:" + cfg.clientIP(r)
+
+	token, err := bearerTokenFromRequest(r)
+	if err != nil {
+		return anonymous, freeEntitlements.rateLimitMultiplier
+	}
+
+	var credential string
+	var lookupUserID func() (uuid.UUID, error)
+	if auth.IsPersonalAccessToken(token) {
+		hash := auth.HashPersonalAccessToken(token)
+		credential = "pat:" + hash
+		lookupUserID = func() (uuid.UUID, error) {
+			pat, err := cfg.DB.GetPersonalAccessTokenByHash(r.Context(), hash)
+			return pat.UserID, err
+		}
+	} else {
+		claims, err := auth.ParseJWT(token, cfg.keyring)
+		if err != nil {
+			return anonymous, freeEntitlements.rateLimitMultiplier
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			return anonymous, freeEntitlements.rateLimitMultiplier
+		}
+		credential = "user:" + userID.String()
+		lookupUserID = func() (uuid.UUID, error) { return userID, nil }
+	}
+
+	caller, ok := cfg.rateLimitPlans.get(credential)
+	if !ok {
+		userID, err := lookupUserID()
+		if err != nil {
+			return anonymous, freeEntitlements.rateLimitMultiplier
+		}
+		user, err := cfg.DB.GetUserByID(r.Context(), userID)
+		if err != nil {
+			return anonymous, freeEntitlements.rateLimitMultiplier
+		}
+		caller = rateLimitCaller{
+			userID:     user.ID,
+			multiplier: entitlementsFor(user).rateLimitMultiplier,
+		}
+		cfg.rateLimitPlans.set(credential, caller)
+	}
+	return "user:" + caller.userID.String(), caller.multiplier
+}
+
+// How long a token's owner and their plan are remembered. A new
+// subscription raises the caller's limits within this long.
+const rateLimitPlanTTL = time.Minute
+
+// rateLimitCaller is who a token belongs to, as far as rate limits go.
+type rateLimitCaller struct {
+	userID     uuid.UUID
+	multiplier int
+}
+
+// planCache remembers rateLimitCallers by credential: "user:" and the user
+// ID for access tokens, "pat:" and the hash for personal access tokens.
+type planCache struct {
+	mu        sync.Mutex
+	entries   map[string]planCacheEntry
+	nextSweep time.Time
+}
+
+type planCacheEntry struct {
+	caller    rateLimitCaller
+	expiresAt time.Time
+}
+
+func newPlanCache() *planCache {
+	return &planCache{entries: map[string]planCacheEntry{}}
+}
+
+func (c *planCache) get(credential string) (rateLimitCaller, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[credential]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return rateLimitCaller{}, false
+	}
+	return entry.caller, true
+}
+
+func (c *planCache) set(credential string, caller rateLimitCaller) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Expired entries are swept out once per TTL, so the cache only holds
+	// credentials seen in the last two
+	if now.After(c.nextSweep) {
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.nextSweep = now.Add(rateLimitPlanTTL)
+	}
+	c.entries[credential] = planCacheEntry{
+		caller:    caller,
+		expiresAt: now.Add(rateLimitPlanTTL),
+	}
+}
+
+// pruneRateLimitsJob returns the job that deletes idle buckets from
+// Postgres. A bucket that has been idle for longer than any limit's period is
+// full, which is the same as not having one.
+func pruneRateLimitsJob(store *ratelimit.PostgresStore) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) error {
+		_, err := store.Prune(ctx, rateLimitPruneInterval)
+		return err
+	}
+}
@@ -0,0 +1,13 @@
+-- name: CreateChirpRevision :one
+INSERT INTO chirp_revisions (chirp_id, body, created_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at ASC, id ASC;
+
+-- name: DeleteChirpRevisions :exec
+DELETE FROM chirp_revisions
+WHERE chirp_id = $1;
@@ -1,11 +1,12 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -26,3 +27,159 @@ WHERE id = $1;
 SELECT * FROM chirps
 WHERE user_id = $1
 ORDER BY created_at ASC;
+
+-- name: GetChirpsPageAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND hidden_at IS NULL
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsPageDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND hidden_at IS NULL
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetAuthorFeedPageAsc :many
+SELECT sqlc.embed(chirps), feed.activity_id, feed.activity_at, feed.rechirped_by
+FROM (
+    SELECT chirps.id AS activity_id, chirps.created_at AS activity_at, chirps.id AS chirp_id, NULL::uuid AS rechirped_by
+    FROM chirps
+    WHERE chirps.user_id = sqlc.arg('user_id')::uuid
+    UNION ALL
+    SELECT rechirps.id, rechirps.created_at, rechirps.chirp_id, rechirps.user_id
+    FROM rechirps
+    WHERE rechirps.user_id = sqlc.arg('user_id')::uuid
+) feed
+JOIN chirps ON chirps.id = feed.chirp_id
+WHERE chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (feed.activity_at, feed.activity_id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY feed.activity_at ASC, feed.activity_id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetAuthorFeedPageDesc :many
+SELECT sqlc.embed(chirps), feed.activity_id, feed.activity_at, feed.rechirped_by
+FROM (
+    SELECT chirps.id AS activity_id, chirps.created_at AS activity_at, chirps.id AS chirp_id, NULL::uuid AS rechirped_by
+    FROM chirps
+    WHERE chirps.user_id = sqlc.arg('user_id')::uuid
+    UNION ALL
+    SELECT rechirps.id, rechirps.created_at, rechirps.chirp_id, rechirps.user_id
+    FROM rechirps
+    WHERE rechirps.user_id = sqlc.arg('user_id')::uuid
+) feed
+JOIN chirps ON chirps.id = feed.chirp_id
+WHERE chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (feed.activity_at, feed.activity_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY feed.activity_at DESC, feed.activity_id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpbyIdForUpdate :one
+SELECT * FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: TombstoneChirp :one
+UPDATE chirps
+SET body = '',
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountChirpReplies :one
+SELECT COUNT(*) FROM chirps
+WHERE in_reply_to = $1;
+
+-- name: GetReplyCounts :many
+SELECT in_reply_to, COUNT(*) AS reply_count FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg('chirp_ids')::uuid[])
+AND deleted_at IS NULL
+AND hidden_at IS NULL
+GROUP BY in_reply_to;
+
+-- name: GetThreadRootID :one
+WITH RECURSIVE ancestors AS (
+    SELECT chirps.id, chirps.in_reply_to, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = $1
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+    FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT ancestors.id FROM ancestors
+ORDER BY ancestors.depth DESC
+LIMIT 1;
+
+-- name: GetChirpThread :many
+WITH RECURSIVE thread AS (
+    SELECT chirps.id
+    FROM chirps
+    WHERE chirps.id = sqlc.arg('root_id')
+    UNION ALL
+    SELECT reply.id
+    FROM chirps reply
+    JOIN thread ON reply.in_reply_to = thread.id
+)
+SELECT chirps.* FROM chirps
+JOIN thread ON chirps.id = thread.id
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('max_chirps');
+
+-- name: SearchChirps :many
+WITH search AS (
+    SELECT websearch_to_tsquery('english', sqlc.arg('query')::text) AS query
+)
+SELECT sqlc.embed(chirps),
+    ts_rank_cd(chirps.search_vector, search.query)::real AS rank,
+    ts_headline(
+        'english',
+        chirps.body,
+        search.query,
+        -- U+E000/U+E001 mark matches; the server turns them into <mark> tags
+        -- after escaping the body, so chirp text can't inject markup
+        'StartSel=' || chr(57344) || ', StopSel=' || chr(57345)
+            || ', MaxFragments=2, MaxWords=20, MinWords=5'
+    )::text AS snippet
+FROM chirps, search
+WHERE chirps.search_vector @@ search.query
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamptz IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamptz)
+AND (sqlc.narg('until')::timestamptz IS NULL OR chirps.created_at < sqlc.narg('until')::timestamptz)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit')
+OFFSET sqlc.arg('page_offset');
+
+-- name: HideChirp :one
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1
+RETURNING *;
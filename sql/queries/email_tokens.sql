@@ -0,0 +1,60 @@
+-- name: CreateEmailToken :one
+INSERT INTO email_tokens (user_id, purpose, token_hash, email, expires_at, requested_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: LockEmailTokens :exec
+-- Held until the transaction ends, so tokens for a user are issued one at a
+-- time.
+SELECT pg_advisory_xact_lock(hashtext('email_tokens'), hashtext(sqlc.arg('user_id')::uuid::text));
+
+-- name: HasLaterEmailTokenRequest :one
+SELECT EXISTS (
+    SELECT 1 FROM email_tokens
+    WHERE user_id = sqlc.arg('user_id')
+    AND purpose = sqlc.arg('purpose')
+    AND requested_at > sqlc.arg('requested_at')::timestamp
+);
+
+-- name: InvalidateEmailTokens :exec
+-- A new token replaces any unused ones for the same purpose.
+UPDATE email_tokens
+SET used_at = NOW()
+WHERE user_id = $1
+AND purpose = $2
+AND used_at IS NULL;
+
+-- name: UseEmailToken :one
+UPDATE email_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+AND purpose = $2
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
+
+-- name: MarkEmailVerified :one
+-- Only verifies the address the token was sent to, in case it has changed
+-- since.
+UPDATE users
+SET updated_at = NOW(),
+    email_verified_at = COALESCE(email_verified_at, NOW())
+WHERE id = $1
+AND email = $2
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET updated_at = NOW(),
+    hashed_password = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: ChangeUserEmail :one
+-- The token proves the new address works, so it is verified right away.
+UPDATE users
+SET updated_at = NOW(),
+    email = $2,
+    email_verified_at = NOW()
+WHERE id = $1
+RETURNING *;
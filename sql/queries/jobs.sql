@@ -0,0 +1,67 @@
+-- name: EnqueueJob :exec
+-- Jobs with a unique_key that's already taken are dropped.
+INSERT INTO jobs (kind, payload, max_attempts, run_at, unique_key)
+VALUES (sqlc.arg('kind'), sqlc.arg('payload'), sqlc.arg('max_attempts'), sqlc.arg('run_at'), sqlc.narg('unique_key'))
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL DO NOTHING;
+
+-- name: ClaimJob :one
+-- Takes the job of one of kinds that has been due the longest, including
+-- running jobs whose worker stopped renewing the lock.
+UPDATE jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_until = sqlc.arg('locked_until')::timestamp,
+    updated_at = NOW()
+WHERE id = (
+    SELECT due.id FROM jobs AS due
+    WHERE due.kind = ANY(sqlc.arg('kinds')::text[])
+    AND (
+        (due.status = 'pending' AND due.run_at <= sqlc.arg('now')::timestamp)
+        OR (due.status = 'running' AND due.locked_until <= sqlc.arg('now')::timestamp)
+    )
+    ORDER BY due.run_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: ExtendJobLock :exec
+-- Called while a job runs, so it isn't taken for abandoned.
+UPDATE jobs
+SET locked_until = sqlc.arg('locked_until')::timestamp
+WHERE id = sqlc.arg('id') AND status = 'running';
+
+-- name: CompleteJob :exec
+-- Payloads can hold personal data, so they aren't kept once a job is done.
+UPDATE jobs
+SET status = 'succeeded',
+    payload = 'null',
+    locked_until = NULL,
+    last_error = '',
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: RetryJob :exec
+UPDATE jobs
+SET status = 'pending',
+    run_at = sqlc.arg('run_at'),
+    locked_until = NULL,
+    last_error = sqlc.arg('last_error'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: FailJob :exec
+UPDATE jobs
+SET status = 'failed',
+    payload = 'null',
+    locked_until = NULL,
+    last_error = sqlc.arg('last_error'),
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: DeleteFinishedJobs :execrows
+DELETE FROM jobs
+WHERE (status = 'succeeded' AND finished_at < sqlc.arg('succeeded_before')::timestamp)
+OR (status = 'failed' AND finished_at < sqlc.arg('failed_before')::timestamp);
@@ -0,0 +1,44 @@
+-- name: CreateLoginEvent :exec
+INSERT INTO login_events (user_id, email, ip_address, user_agent, outcome)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: LockLoginAttemptsByIP :exec
+-- Held until the transaction ends, so attempts from one address are checked
+-- and recorded one at a time.
+SELECT pg_advisory_xact_lock(hashtext('login_attempts'), hashtext(sqlc.arg('ip_address')));
+
+-- name: GetRecentLoginFailuresByIP :one
+-- Times are measured by the database, which also set created_at.
+SELECT COUNT(*) AS failures,
+    COALESCE(EXTRACT(EPOCH FROM NOW() - MAX(created_at)), 0)::float8 AS seconds_since_last
+FROM login_events
+WHERE ip_address = sqlc.arg('ip_address')
+AND outcome IN ('bad_password', 'unknown_user', 'bad_mfa_code')
+AND created_at > NOW() - make_interval(secs => sqlc.arg('window_seconds')::float8);
+
+-- name: RecordFailedLogin :one
+UPDATE users
+SET failed_login_count = failed_login_count + 1
+WHERE id = $1
+RETURNING failed_login_count;
+
+-- name: SetUserLockedUntil :exec
+UPDATE users
+SET locked_until = $2
+WHERE id = $1;
+
+-- name: ResetFailedLogins :execrows
+UPDATE users
+SET failed_login_count = 0,
+    locked_until = NULL
+WHERE id = $1;
+
+-- name: GetLoginEventsPage :many
+SELECT * FROM login_events
+WHERE user_id = sqlc.arg('user_id')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
@@ -0,0 +1,51 @@
+-- name: ListModerationTerms :many
+SELECT * FROM moderation_terms
+ORDER BY term ASC;
+
+-- name: GetModerationTerm :one
+SELECT * FROM moderation_terms
+WHERE id = $1;
+
+-- name: CreateModerationTerm :one
+INSERT INTO moderation_terms (term, match_mode, action)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: UpdateModerationTerm :one
+UPDATE moderation_terms
+SET term = COALESCE(sqlc.narg('term'), term),
+    match_mode = COALESCE(sqlc.narg('match_mode'), match_mode),
+    action = COALESCE(sqlc.narg('action'), action),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: DeleteModerationTerm :execrows
+DELETE FROM moderation_terms
+WHERE id = $1;
+
+-- name: CreateModerationAction :one
+INSERT INTO moderation_actions (action, moderator_id, report_id, chirp_id, chirp_body, user_id, note, suspended_until, role)
+VALUES (
+    sqlc.arg('action'),
+    sqlc.narg('moderator_id'),
+    sqlc.narg('report_id'),
+    sqlc.narg('chirp_id'),
+    sqlc.narg('chirp_body'),
+    sqlc.narg('user_id'),
+    sqlc.arg('note'),
+    sqlc.narg('suspended_until'),
+    sqlc.narg('role')
+)
+RETURNING *;
+
+-- name: GetModerationActionsPage :many
+SELECT * FROM moderation_actions
+WHERE (sqlc.narg('user_id')::uuid IS NULL OR user_id = sqlc.narg('user_id')::uuid)
+AND (sqlc.narg('chirp_id')::uuid IS NULL OR chirp_id = sqlc.narg('chirp_id')::uuid)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
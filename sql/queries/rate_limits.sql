@@ -0,0 +1,28 @@
+-- name: TakeRateLimitToken :one
+-- Returns no row when the bucket doesn't have a whole token, which leaves it
+-- untouched.
+INSERT INTO rate_limit_buckets AS bucket (key, tokens, updated_at)
+VALUES (sqlc.arg('key'), sqlc.arg('capacity')::float8 - 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(
+        sqlc.arg('capacity')::float8,
+        bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at) * sqlc.arg('rate')::float8
+    ) - 1,
+    updated_at = NOW()
+WHERE LEAST(
+    sqlc.arg('capacity')::float8,
+    bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at) * sqlc.arg('rate')::float8
+) >= 1
+RETURNING tokens;
+
+-- name: GetRateLimitTokens :one
+SELECT LEAST(
+    sqlc.arg('capacity')::float8,
+    tokens + EXTRACT(EPOCH FROM NOW() - updated_at) * sqlc.arg('rate')::float8
+)::float8 AS tokens
+FROM rate_limit_buckets
+WHERE key = sqlc.arg('key');
+
+-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets
+WHERE updated_at < NOW() - make_interval(secs => sqlc.arg('idle_seconds')::float8);
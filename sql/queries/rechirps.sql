@@ -0,0 +1,14 @@
+-- name: CreateRechirp :exec
+INSERT INTO rechirps (user_id, chirp_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteRechirp :exec
+DELETE FROM rechirps
+WHERE user_id = $1
+AND chirp_id = $2;
+
+-- name: GetRechirpCounts :many
+SELECT chirp_id, COUNT(*) AS rechirp_count FROM rechirps
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+GROUP BY chirp_id;
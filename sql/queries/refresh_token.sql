@@ -1,6 +1,6 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (user_id, token, expires_at)
-VALUES ($1, $2, NOW() + INTERVAL '60 days')
+INSERT INTO refresh_tokens (user_id, token, expires_at, family_id, user_agent, ip_address)
+VALUES ($1, $2, NOW() + INTERVAL '60 days', gen_random_uuid(), $3, $4)
 RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
@@ -8,10 +8,83 @@ SELECT users.* FROM users
 JOIN refresh_tokens ON users.id = refresh_tokens.user_id
 WHERE refresh_tokens.token = $1
 AND revoked_at IS NULL
+AND superseded_at IS NULL
 AND expires_at > NOW();
 
 -- name: RevokeRefreshToken :one
 UPDATE refresh_tokens SET revoked_at = NOW(),
 updated_at = NOW()
 WHERE token = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: GetRefreshTokenForUpdate :one
+SELECT sqlc.embed(refresh_tokens), (refresh_tokens.expires_at <= NOW())::boolean AS expired
+FROM refresh_tokens
+WHERE refresh_tokens.token = $1
+FOR UPDATE;
+
+-- name: RotateRefreshToken :one
+-- The new token keeps the family's expiry, so rotating doesn't extend a
+-- session beyond its original 60 days.
+INSERT INTO refresh_tokens (user_id, token, expires_at, family_id, parent_id, user_agent, ip_address)
+SELECT parent.user_id, sqlc.arg('token'), parent.expires_at, parent.family_id, parent.id,
+    sqlc.arg('user_agent'), sqlc.arg('ip_address')
+FROM refresh_tokens parent
+WHERE parent.id = sqlc.arg('parent_id')
+RETURNING *;
+
+-- name: SupersedeRefreshToken :exec
+UPDATE refresh_tokens
+SET superseded_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: GetActiveSessions :many
+-- Only the newest token of a live family is neither revoked nor superseded.
+SELECT refresh_tokens.family_id, refresh_tokens.user_agent, refresh_tokens.ip_address,
+    refresh_tokens.last_used_at, refresh_tokens.expires_at,
+    (
+        SELECT MIN(family.created_at) FROM refresh_tokens family
+        WHERE family.family_id = refresh_tokens.family_id
+    )::timestamp AS started_at
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = $1
+AND refresh_tokens.revoked_at IS NULL
+AND refresh_tokens.superseded_at IS NULL
+AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+AND family_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND (sqlc.narg('keep_family_id')::uuid IS NULL OR family_id <> sqlc.narg('keep_family_id')::uuid)
+AND revoked_at IS NULL;
+
+-- name: IsSessionActive :one
+-- Access tokens carry their session's family ID; they only work while the
+-- family's newest token is neither revoked nor expired.
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL
+    AND superseded_at IS NULL
+    AND expires_at > NOW()
+)::boolean;
@@ -0,0 +1,31 @@
+-- name: CreateReport :one
+INSERT INTO reports (chirp_id, reporter_id, reason, details)
+VALUES (sqlc.arg('chirp_id'), sqlc.narg('reporter_id'), sqlc.arg('reason'), sqlc.arg('details'))
+RETURNING *;
+
+-- name: GetReportsPage :many
+SELECT sqlc.embed(reports), chirps.user_id AS author_id, chirps.body AS chirp_body,
+    chirps.hidden_at AS chirp_hidden_at, chirps.deleted_at AS chirp_deleted_at
+FROM reports
+JOIN chirps ON chirps.id = reports.chirp_id
+WHERE reports.status = sqlc.arg('status')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (reports.created_at, reports.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY reports.created_at ASC, reports.id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetReportByID :one
+SELECT * FROM reports
+WHERE id = $1;
+
+-- name: ResolveChirpReports :many
+UPDATE reports
+SET status = 'resolved',
+    resolution = sqlc.arg('resolution'),
+    resolved_at = NOW(),
+    updated_at = NOW()
+WHERE chirp_id = sqlc.arg('chirp_id')
+AND status = 'open'
+RETURNING *;
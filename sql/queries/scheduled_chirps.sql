@@ -0,0 +1,37 @@
+-- name: CreateScheduledChirp :one
+INSERT INTO scheduled_chirps (user_id, body, in_reply_to, publish_at)
+VALUES (sqlc.arg('user_id'), sqlc.arg('body'), sqlc.narg('in_reply_to'), sqlc.arg('publish_at'))
+RETURNING *;
+
+-- name: CountScheduledChirps :one
+SELECT COUNT(*) FROM scheduled_chirps
+WHERE user_id = $1 AND status = 'scheduled';
+
+-- name: GetScheduledChirps :many
+SELECT * FROM scheduled_chirps
+WHERE user_id = $1
+ORDER BY publish_at ASC, id ASC;
+
+-- name: DeleteScheduledChirp :execrows
+DELETE FROM scheduled_chirps
+WHERE id = sqlc.arg('id') AND user_id = sqlc.arg('user_id');
+
+-- name: ClaimDueScheduledChirp :one
+-- Locks the next chirp due for publishing. Other publishers skip it until
+-- the transaction ends.
+SELECT * FROM scheduled_chirps
+WHERE status = 'scheduled' AND publish_at <= sqlc.arg('now')
+ORDER BY publish_at ASC, id ASC
+LIMIT 1
+FOR UPDATE SKIP LOCKED;
+
+-- name: DeletePublishedScheduledChirp :exec
+DELETE FROM scheduled_chirps
+WHERE id = $1;
+
+-- name: FailScheduledChirp :exec
+UPDATE scheduled_chirps
+SET status = 'failed',
+    failure_reason = sqlc.arg('failure_reason'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
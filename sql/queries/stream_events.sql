@@ -0,0 +1,34 @@
+-- name: LockStreamEvents :exec
+SELECT pg_advisory_xact_lock(hashtext('stream_events'));
+
+-- name: CreateStreamEvent :one
+INSERT INTO stream_events (type, chirp_id, user_id, hashtags, payload)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+
+-- name: NotifyStreamEvent :exec
+SELECT pg_notify('stream_events', sqlc.arg('id')::bigint::text);
+
+-- name: RedactStreamEvents :exec
+-- Redacted events keep their id but have no payload.
+UPDATE stream_events
+SET payload = ''
+WHERE chirp_id = $1 AND payload <> '';
+
+-- name: GetStreamEventsAfter :many
+SELECT * FROM stream_events
+WHERE id > sqlc.arg('after_id')
+AND (NOT sqlc.arg('filter_authors')::boolean OR user_id = ANY(sqlc.arg('author_ids')::uuid[]))
+AND (sqlc.arg('hashtag')::text = '' OR sqlc.arg('hashtag')::text = ANY(hashtags))
+ORDER BY id
+LIMIT sqlc.arg('max_events');
+
+-- name: GetLatestStreamEventID :one
+SELECT COALESCE(MAX(id), 0)::bigint FROM stream_events;
+
+-- name: GetOldestStreamEventID :one
+SELECT COALESCE(MIN(id), 0)::bigint FROM stream_events;
+
+-- name: DeleteStreamEventsBefore :execrows
+DELETE FROM stream_events
+WHERE created_at < sqlc.arg('before')::timestamp;
@@ -0,0 +1,35 @@
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: GetSubscriptionForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, status, current_period_end, access_until)
+VALUES (sqlc.arg('user_id'), sqlc.arg('status'), sqlc.arg('current_period_end'), sqlc.arg('access_until'))
+ON CONFLICT (user_id) DO UPDATE
+SET status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    access_until = EXCLUDED.access_until,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ExpireSubscriptions :many
+-- Ends access for subscriptions whose period and grace period are over, and
+-- returns the users who lost Chirpy Red.
+WITH expired AS (
+    UPDATE subscriptions
+    SET status = 'expired',
+        updated_at = NOW()
+    WHERE status <> 'expired' AND access_until <= sqlc.arg('now')
+    RETURNING user_id
+)
+UPDATE users
+SET is_chirpy_red = FALSE,
+    updated_at = NOW()
+FROM expired
+WHERE users.id = expired.user_id
+RETURNING users.id;
@@ -0,0 +1,77 @@
+-- name: SetPendingTOTPSecret :one
+UPDATE users
+SET updated_at = NOW(),
+    totp_secret = $2,
+    totp_last_step = NULL
+WHERE id = $1
+AND totp_enabled_at IS NULL
+RETURNING *;
+
+-- name: EnableTOTP :one
+UPDATE users
+SET updated_at = NOW(),
+    totp_enabled_at = NOW()
+WHERE id = $1
+AND totp_secret IS NOT NULL
+AND totp_enabled_at IS NULL
+RETURNING *;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET updated_at = NOW(),
+    totp_secret = NULL,
+    totp_enabled_at = NULL,
+    totp_last_step = NULL
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+-- Fails for a step at or before the last accepted one, so each code works
+-- once.
+UPDATE users
+SET totp_last_step = sqlc.arg('step')
+WHERE id = sqlc.arg('id')
+AND (totp_last_step IS NULL OR totp_last_step < sqlc.arg('step'));
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL;
+
+-- name: CreateMFAChallenge :one
+INSERT INTO mfa_challenges (user_id, token_hash, expires_at)
+VALUES ($1, $2, NOW() + INTERVAL '5 minutes')
+RETURNING *;
+
+-- name: GetMFAChallengeByHash :one
+SELECT * FROM mfa_challenges
+WHERE token_hash = $1
+AND expires_at > NOW();
+
+-- name: RecordMFAChallengeAttempt :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE id = $1
+RETURNING attempts;
+
+-- name: DeleteMFAChallenge :execrows
+DELETE FROM mfa_challenges
+WHERE id = $1;
+
+-- name: DeleteUserMFAChallenges :exec
+DELETE FROM mfa_challenges
+WHERE user_id = $1;
+
+-- name: DeleteExpiredMFAChallenges :exec
+DELETE FROM mfa_challenges
+WHERE user_id = $1
+AND expires_at <= NOW();
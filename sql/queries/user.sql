@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, username)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -14,12 +15,13 @@ SELECT * FROM users
 WHERE email = $1
 LIMIT 1;
 
--- name: UpdateUser :one
+-- name: UpdateUserProfile :one
+-- Null arguments leave the column unchanged.
 UPDATE users
 SET updated_at = NOW(),
-    email = $2,
-    hashed_password = $3
-WHERE id = $1
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    username = COALESCE(sqlc.narg('username'), username)
+WHERE id = sqlc.arg('id')
 RETURNING *;
 
 -- name: UpdateUserChirpyRed :one
@@ -29,3 +31,37 @@ SET updated_at = NOW(),
 WHERE id = $1
 RETURNING *;
 
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: GetUserByIDForLogin :one
+-- Locks the user's row for a login attempt. Rows referencing the user can
+-- still be inserted meanwhile.
+SELECT * FROM users
+WHERE id = $1
+FOR NO KEY UPDATE;
+
+-- name: GetUserByEmailForLogin :one
+SELECT * FROM users
+WHERE email = $1
+FOR NO KEY UPDATE;
+
+-- name: GetUsersByUsernames :many
+SELECT * FROM users
+WHERE username = ANY(sqlc.arg('usernames')::text[]);
+
+-- name: SetUserSuspendedUntil :one
+UPDATE users
+SET updated_at = NOW(),
+    suspended_until = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(),
+    role = $2
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,81 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (created_by, url, secret, events)
+VALUES (sqlc.arg('created_by'), sqlc.arg('url'), sqlc.arg('secret'), sqlc.arg('events')::text[])
+RETURNING *;
+
+-- name: GetWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+ORDER BY created_at ASC, id ASC;
+
+-- name: GetWebhookEndpointByID :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Queues an event for every endpoint subscribed to its type.
+INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload, next_attempt_at)
+SELECT id, sqlc.arg('event_id'), sqlc.arg('event_type')::text, sqlc.arg('payload'), sqlc.arg('next_attempt_at')
+FROM webhook_endpoints
+WHERE sqlc.arg('event_type')::text = ANY(events);
+
+-- name: ClaimWebhookDeliveries :many
+-- Takes deliveries that are due and pushes their next attempt back to
+-- lease_until, so no other dispatcher sends them in the meantime. If this
+-- one dies mid-send they are picked up again after the lease.
+UPDATE webhook_deliveries
+SET attempts = attempts + 1,
+    next_attempt_at = sqlc.arg('lease_until'),
+    updated_at = NOW()
+WHERE id IN (
+    SELECT due.id FROM webhook_deliveries AS due
+    WHERE due.status = 'pending' AND due.next_attempt_at <= sqlc.arg('now')::timestamp
+    ORDER BY due.next_attempt_at ASC
+    LIMIT sqlc.arg('batch_size')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkWebhookDeliverySucceeded :exec
+UPDATE webhook_deliveries
+SET status = 'succeeded',
+    last_status_code = sqlc.arg('status_code'),
+    last_error = '',
+    delivered_at = NOW(),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: MarkWebhookDeliveryFailed :exec
+-- Records a failed attempt. The delivery is retried at next_attempt_at, or
+-- not at all once its status is dead.
+UPDATE webhook_deliveries
+SET status = sqlc.arg('status'),
+    last_status_code = sqlc.narg('status_code'),
+    last_error = sqlc.arg('last_error'),
+    next_attempt_at = sqlc.arg('next_attempt_at'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: RetryWebhookDelivery :one
+-- Gives a dead delivery a fresh set of attempts.
+UPDATE webhook_deliveries
+SET status = 'pending',
+    attempts = 0,
+    next_attempt_at = sqlc.arg('next_attempt_at'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id') AND endpoint_id = sqlc.arg('endpoint_id') AND status = 'dead'
+RETURNING *;
+
+-- name: GetWebhookDeliveriesPage :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+AND (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
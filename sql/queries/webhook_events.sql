@@ -0,0 +1,45 @@
+-- name: RecordWebhookEvent :one
+-- Stores an incoming event, or counts another delivery of one already
+-- stored. The payload of the first delivery is kept.
+INSERT INTO webhook_events (source, event_id, event_type, payload)
+VALUES (sqlc.arg('source'), sqlc.arg('event_id'), sqlc.arg('event_type'), sqlc.arg('payload'))
+ON CONFLICT (source, event_id) DO UPDATE
+SET deliveries = webhook_events.deliveries + 1,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetWebhookEventByID :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEventForUpdate :one
+SELECT * FROM webhook_events
+WHERE id = $1
+FOR UPDATE;
+
+-- name: MarkWebhookEventDone :one
+UPDATE webhook_events
+SET status = sqlc.arg('status'),
+    error = '',
+    processed_at = NOW(),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: MarkWebhookEventFailed :one
+UPDATE webhook_events
+SET status = 'failed',
+    error = sqlc.arg('error'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: GetWebhookEventsPage :many
+SELECT * FROM webhook_events
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
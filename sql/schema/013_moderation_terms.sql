@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE moderation_terms (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    term TEXT NOT NULL UNIQUE,
+    match_mode TEXT NOT NULL DEFAULT 'substring'
+        CHECK (match_mode IN ('whole_word', 'substring')),
+    action TEXT NOT NULL DEFAULT 'mask'
+        CHECK (action IN ('mask', 'reject', 'flag')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- The words that used to be hardcoded in cleanChirpBody
+INSERT INTO moderation_terms (term, match_mode, action) VALUES
+    ('kerfuffle', 'substring', 'mask'),
+    ('sharbert', 'substring', 'mask'),
+    ('fornax', 'substring', 'mask');
+
+CREATE TABLE moderation_flags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    term TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX moderation_flags_created_at_id_idx ON moderation_flags (created_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE moderation_flags;
+DROP TABLE moderation_terms;
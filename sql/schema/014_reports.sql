@@ -0,0 +1,68 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN hidden_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN suspended_until TIMESTAMP;
+
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    -- NULL for reports raised by the moderation filter
+    reporter_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL CHECK (reason IN (
+        'spam', 'harassment', 'hate', 'violence', 'sexual', 'misinformation', 'other', 'flagged_term'
+    )),
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved')),
+    resolution TEXT CHECK (resolution IN ('hide', 'delete', 'dismiss')),
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A user can only have one open report per chirp
+CREATE UNIQUE INDEX reports_open_reporter_idx ON reports (chirp_id, reporter_id) WHERE status = 'open';
+CREATE INDEX reports_status_created_at_id_idx ON reports (status, created_at, id);
+
+-- Chirps flagged by the filter now wait in the same queue as user reports
+INSERT INTO reports (chirp_id, reason, details, created_at, updated_at)
+SELECT chirp_id, 'flagged_term', term, created_at, created_at FROM moderation_flags;
+
+DROP TABLE moderation_flags;
+
+-- The audit trail has no foreign keys so it outlives the chirps, users and
+-- reports it describes
+CREATE TABLE moderation_actions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    action TEXT NOT NULL CHECK (action IN ('hide', 'delete', 'dismiss', 'warn', 'suspend', 'unsuspend')),
+    moderator_id UUID,
+    report_id UUID,
+    chirp_id UUID,
+    -- The body at the time of the decision, kept for deleted chirps
+    chirp_body TEXT,
+    user_id UUID,
+    note TEXT NOT NULL DEFAULT '',
+    suspended_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX moderation_actions_created_at_id_idx ON moderation_actions (created_at DESC, id DESC);
+CREATE INDEX moderation_actions_user_id_idx ON moderation_actions (user_id);
+
+-- +goose Down
+DROP TABLE moderation_actions;
+
+CREATE TABLE moderation_flags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    term TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX moderation_flags_created_at_id_idx ON moderation_flags (created_at DESC, id DESC);
+
+INSERT INTO moderation_flags (chirp_id, term, created_at)
+SELECT chirp_id, details, created_at FROM reports
+WHERE reason = 'flagged_term' AND status = 'open';
+
+DROP TABLE reports;
+ALTER TABLE users DROP COLUMN suspended_until;
+ALTER TABLE chirps DROP COLUMN hidden_at;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- Role changes are moderation decisions too
+ALTER TABLE moderation_actions ADD COLUMN role TEXT;
+ALTER TABLE moderation_actions DROP CONSTRAINT moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('hide', 'delete', 'dismiss', 'warn', 'suspend', 'unsuspend', 'set_role'));
+
+-- +goose Down
+DELETE FROM moderation_actions WHERE action = 'set_role';
+ALTER TABLE moderation_actions DROP CONSTRAINT moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('hide', 'delete', 'dismiss', 'warn', 'suspend', 'unsuspend'));
+ALTER TABLE moderation_actions DROP COLUMN role;
+
+ALTER TABLE users DROP COLUMN role;
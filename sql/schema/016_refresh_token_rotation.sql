@@ -0,0 +1,17 @@
+-- +goose Up
+-- Every refresh hands out a new token in the same family. Presenting a
+-- token that was already superseded revokes the whole family.
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+ALTER TABLE refresh_tokens ADD COLUMN parent_id UUID REFERENCES refresh_tokens(id) ON DELETE SET NULL;
+ALTER TABLE refresh_tokens ADD COLUMN superseded_at TIMESTAMP;
+
+UPDATE refresh_tokens SET family_id = id;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN superseded_at;
+ALTER TABLE refresh_tokens DROP COLUMN parent_id;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Accounts created before verification existed are treated as verified.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = created_at;
+
+-- Single-use tokens mailed to a user. email is the address the token was
+-- sent to, so a token only verifies the address it was sent to.
+CREATE TABLE email_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+    token_hash TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX email_tokens_user_id_idx ON email_tokens (user_id, purpose);
+
+-- +goose Down
+DROP TABLE email_tokens;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- A change_email token is sent to the new address; the account keeps its
+-- current address until the token is used.
+ALTER TABLE email_tokens DROP CONSTRAINT email_tokens_purpose_check;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password', 'change_email'));
+
+-- +goose Down
+DELETE FROM email_tokens WHERE purpose = 'change_email';
+ALTER TABLE email_tokens DROP CONSTRAINT email_tokens_purpose_check;
+ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password'));
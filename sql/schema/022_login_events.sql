@@ -0,0 +1,37 @@
+-- +goose Up
+-- Consecutive failed logins for an account; locked_until is when the next
+-- attempt is allowed.
+ALTER TABLE users ADD COLUMN failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMP;
+
+-- Every login attempt, successful or not. Failures by IP address are
+-- counted from here.
+CREATE TABLE login_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    email TEXT NOT NULL,
+    ip_address TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    outcome TEXT NOT NULL CHECK (outcome IN (
+        'success', 'mfa_required', 'bad_password', 'unknown_user', 'bad_mfa_code', 'throttled'
+    )),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_events_ip_address_idx ON login_events (ip_address, created_at);
+CREATE INDEX login_events_user_id_idx ON login_events (user_id, created_at);
+
+-- Unlocking an account is recorded with the other staff actions
+ALTER TABLE moderation_actions DROP CONSTRAINT moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('hide', 'delete', 'dismiss', 'warn', 'suspend', 'unsuspend', 'set_role', 'unlock'));
+
+-- +goose Down
+DELETE FROM moderation_actions WHERE action = 'unlock';
+ALTER TABLE moderation_actions DROP CONSTRAINT moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('hide', 'delete', 'dismiss', 'warn', 'suspend', 'unsuspend', 'set_role'));
+
+DROP TABLE login_events;
+ALTER TABLE users DROP COLUMN locked_until;
+ALTER TABLE users DROP COLUMN failed_login_count;
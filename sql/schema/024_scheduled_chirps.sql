@@ -0,0 +1,21 @@
+-- +goose Up
+-- Chirps waiting to be published. The body is moderated again when it's
+-- published, against the terms in force at that time. Published chirps are
+-- deleted from here; ones that couldn't be published stay with the reason.
+CREATE TABLE scheduled_chirps (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+    publish_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'scheduled' CHECK (status IN ('scheduled', 'failed')),
+    failure_reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX scheduled_chirps_publish_at_idx ON scheduled_chirps (publish_at) WHERE status = 'scheduled';
+CREATE INDEX scheduled_chirps_user_id_idx ON scheduled_chirps (user_id, publish_at);
+
+-- +goose Down
+DROP TABLE scheduled_chirps;
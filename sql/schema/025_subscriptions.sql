@@ -0,0 +1,23 @@
+-- +goose Up
+-- One row per user who has ever had Chirpy Red. users.is_chirpy_red stays
+-- the flag entitlements are read from; it is true until access_until
+-- passes, when the expiry job turns it off. access_until is the end of the
+-- paid period plus the grace period, which also covers failed payments.
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_end TIMESTAMP NOT NULL,
+    access_until TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX subscriptions_access_until_idx ON subscriptions (access_until) WHERE status <> 'expired';
+
+-- Existing Chirpy Red users start a fresh 30 day period
+INSERT INTO subscriptions (user_id, status, current_period_end, access_until)
+SELECT id, 'active', (NOW() AT TIME ZONE 'UTC') + INTERVAL '30 days', (NOW() AT TIME ZONE 'UTC') + INTERVAL '37 days'
+FROM users WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
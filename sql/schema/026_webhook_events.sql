@@ -0,0 +1,23 @@
+-- +goose Up
+-- Every webhook event received, keyed by the sender's event ID so that
+-- redelivered events are only processed once. The raw body is kept so
+-- admins can replay events.
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    source TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processed', 'ignored', 'failed')),
+    error TEXT NOT NULL DEFAULT '',
+    deliveries INTEGER NOT NULL DEFAULT 1,
+    processed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (source, event_id)
+);
+
+CREATE INDEX webhook_events_created_at_id_idx ON webhook_events (created_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE webhook_events;
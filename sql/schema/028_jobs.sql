@@ -0,0 +1,29 @@
+-- +goose Up
+-- Background jobs, run by internal/jobs. Workers claim due jobs with
+-- FOR UPDATE SKIP LOCKED and hold them until locked_until; running jobs
+-- whose lock has run out belong to a worker that died and are claimed
+-- again. unique_key stops recurring jobs being scheduled twice for the same
+-- time by different instances.
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    unique_key TEXT,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key) WHERE unique_key IS NOT NULL;
+CREATE INDEX jobs_run_at_idx ON jobs (run_at) WHERE status = 'pending';
+CREATE INDEX jobs_locked_until_idx ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX jobs_finished_at_idx ON jobs (finished_at) WHERE status IN ('succeeded', 'failed');
+
+-- +goose Down
+DROP TABLE jobs;
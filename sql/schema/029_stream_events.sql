@@ -0,0 +1,20 @@
+-- +goose Up
+-- Chirp changes pushed to GET /api/stream, kept for a while so clients can
+-- resume from the last event they saw. Writers take an advisory lock before
+-- inserting, so ids are committed in order and "everything after id" never
+-- misses an event that commits late. Inserts are announced on the
+-- stream_events channel with NOTIFY.
+CREATE TABLE stream_events (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    chirp_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    hashtags TEXT[] NOT NULL DEFAULT '{}',
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX stream_events_created_at_idx ON stream_events (created_at);
+
+-- +goose Down
+DROP TABLE stream_events;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- A scheduled reply keeps the id of the chirp it replies to even after that
+-- chirp is deleted, so it can be marked failed when it falls due instead of
+-- being published as a chirp of its own.
+ALTER TABLE scheduled_chirps DROP CONSTRAINT scheduled_chirps_in_reply_to_fkey;
+
+-- +goose Down
+UPDATE scheduled_chirps SET in_reply_to = NULL
+WHERE in_reply_to IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM chirps WHERE chirps.id = scheduled_chirps.in_reply_to);
+ALTER TABLE scheduled_chirps ADD CONSTRAINT scheduled_chirps_in_reply_to_fkey
+    FOREIGN KEY (in_reply_to) REFERENCES chirps(id) ON DELETE SET NULL;
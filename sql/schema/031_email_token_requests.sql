@@ -0,0 +1,9 @@
+-- +goose Up
+-- Tokens are issued by the job that mails them, which can run late or be
+-- retried. requested_at is when the request for the mail was made, so a job
+-- can tell that a later request has been issued a token already and leave
+-- it alone.
+ALTER TABLE email_tokens ADD COLUMN requested_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- +goose Down
+ALTER TABLE email_tokens DROP COLUMN requested_at;
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vanzei/goserver/internal/auth"
+	"github.com/vanzei/goserver/internal/database"
+	"github.com/vanzei/goserver/internal/jobs"
+)
+
+// Events partner endpoints can subscribe to
+const (
+	webhookEventChirpCreated = "chirp.created"
+	webhookEventChirpDeleted = "chirp.deleted"
+	webhookEventUserCreated  = "user.created"
+	webhookEventUserUpgraded = "user.upgraded"
+)
+
+var webhookEventTypes = map[string]bool{
+	webhookEventChirpCreated: true,
+	webhookEventChirpDeleted: true,
+	webhookEventUserCreated:  true,
+	webhookEventUserUpgraded: true,
+}
+
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliverySucceeded = "succeeded"
+	webhookDeliveryDead      = "dead"
+)
+
+const (
+	// How often the dispatcher looks for due deliveries
+	webhookDispatchInterval = 5 * time.Second
+	// How many deliveries are sent at once
+	webhookDeliveryBatchSize = 20
+	// How long a claimed delivery is hidden from other dispatchers; longer
+	// than a send can take
+	webhookDeliveryLease   = time.Minute
+	webhookDeliveryTimeout = 10 * time.Second
+	// Failed deliveries are retried after webhookRetryBaseDelay, doubling
+	// each time up to maxWebhookRetryDelay, and are dead after
+	// maxWebhookAttempts, about a day after the first
+	maxWebhookAttempts    = 14
+	webhookRetryBaseDelay = 30 * time.Second
+	maxWebhookRetryDelay  = 6 * time.Hour
+)
+
+// Sent with every delivery; the same for every endpoint an event goes to
+// and for every retry, so receivers can drop duplicates.
+const webhookIDHeader = "Webhook-Id"
+
+var webhookHTTPClient = &http.Client{Timeout: webhookDeliveryTimeout}
+
+// outgoingWebhookEvent is the body of every delivery.
+type outgoingWebhookEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type webhookChirpData struct {
+	ID        uuid.UUID     `json:"id"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	InReplyTo uuid.NullUUID `json:"in_reply_to"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type webhookChirpDeletedData struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// Emails aren't shared with partners
+type webhookUserData struct {
+	ID        uuid.UUID `json:"id"`
+	Username  *string   `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookUserData(user database.User) webhookUserData {
+	data := webhookUserData{ID: user.ID, CreatedAt: user.CreatedAt}
+	if user.Username.Valid {
+		data.Username = &user.Username.String
+	}
+	return data
+}
+
+// enqueueWebhookEvent queues an event for every endpoint subscribed to it.
+// Pass the queries of the transaction making the change, so the event is
+// only sent if the change is committed.
+func enqueueWebhookEvent(ctx context.Context, q *database.Queries, eventType string, data any) error {
+	now := time.Now().UTC()
+	event := outgoingWebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:       event.ID,
+		EventType:     eventType,
+		Payload:       string(payload),
+		NextAttemptAt: now,
+	})
+	return err
+}
+
+// handleDispatchWebhooksJob sends every delivery that is due. Deliveries
+// are claimed in batches, so several instances can dispatch at once.
+func (cfg *apiConfig) handleDispatchWebhooksJob(ctx context.Context, job jobs.Job) error {
+	for {
+		sent, err := cfg.sendWebhookBatch(ctx)
+		if err != nil || sent < webhookDeliveryBatchSize {
+			return err
+		}
+	}
+}
+
+// sendWebhookBatch claims a batch of due deliveries and sends them, and
+// returns how many it claimed.
+func (cfg *apiConfig) sendWebhookBatch(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	deliveries, err := cfg.DB.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+		LeaseUntil: now.Add(webhookDeliveryLease),
+		Now:        now,
+		BatchSize:  webhookDeliveryBatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.sendWebhookDelivery(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// sendWebhookDelivery makes one attempt at a delivery and records the
+// outcome. If the outcome can't be recorded, the delivery is tried again
+// when its lease runs out.
+func (cfg *apiConfig) sendWebhookDelivery(ctx context.Context, delivery database.WebhookDelivery) {
+	endpoint, err := cfg.DB.GetWebhookEndpointByID(ctx, delivery.EndpointID)
+	if err != nil {
+		// Deleted endpoints take their deliveries with them
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Couldn't get webhook endpoint %s: %v", delivery.EndpointID, err)
+		}
+		return
+	}
+
+	statusCode, err := postWebhook(ctx, endpoint, delivery)
+	code := sql.NullInt32{Int32: int32(statusCode), Valid: statusCode != 0}
+	if err == nil {
+		err = cfg.DB.MarkWebhookDeliverySucceeded(ctx, database.MarkWebhookDeliverySucceededParams{
+			ID:         delivery.ID,
+			StatusCode: code,
+		})
+		if err != nil {
+			log.Printf("Couldn't record webhook delivery %s: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	status := webhookDeliveryPending
+	if delivery.Attempts >= maxWebhookAttempts {
+		status = webhookDeliveryDead
+	}
+	err = cfg.DB.MarkWebhookDeliveryFailed(ctx, database.MarkWebhookDeliveryFailedParams{
+		ID:            delivery.ID,
+		Status:        status,
+		StatusCode:    code,
+		LastError:     err.Error(),
+		NextAttemptAt: time.Now().UTC().Add(webhookRetryDelay(delivery.Attempts)),
+	})
+	if err != nil {
+		log.Printf("Couldn't record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// postWebhook sends a delivery, signed with the endpoint's secret, and
+// returns the response status. Anything but a 2xx is an error.
+func postWebhook(ctx context.Context, endpoint database.WebhookEndpoint, delivery database.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks")
+	req.Header.Set(webhookIDHeader, delivery.EventID.String())
+	req.Header.Set(auth.WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(auth.WebhookSignatureHeader, auth.SignWebhook(endpoint.Secret, now, body))
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookRetryDelay is how long to wait after a delivery's attempt-th
+// failed attempt.
+func webhookRetryDelay(attempt int32) time.Duration {
+	delay := webhookRetryBaseDelay
+	for i := int32(1); i < attempt && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxWebhookRetryDelay)
+}